@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+)
+
+// cmdCleanup removes utuns left behind by a crashed sing-box. Only interfaces
+// singboxctl.OrphanUTUNs can attribute to this config are touched; with
+// --dry-run (before or after "cleanup") they are only listed.
+func cmdCleanup(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("cleanup", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	dryRun := fs.Bool("dry-run", false, "list orphaned utuns without removing them")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("cleanup flags: %w", err)
+	}
+	if *dryRun {
+		control.SetDryRun(true)
+		singboxctl.SetDryRun(true)
+	}
+	if err := requireRoot("cleanup"); err != nil {
+		return err
+	}
+
+	orphans, err := singboxctl.OrphanUTUNs(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		fmt.Println("[vpnrd] cleanup: no orphaned utuns")
+		return nil
+	}
+
+	failed := 0
+	for _, o := range orphans {
+		if control.DryRun() {
+			fmt.Printf("[vpnrd] cleanup: would remove %s (%s)\n", o.Name, o.Reason)
+			continue
+		}
+		if err := singboxctl.DestroyUTUN(ctx, o.Name); err != nil {
+			fmt.Printf("[vpnrd] cleanup: %s: %v\n", o.Name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("[vpnrd] cleanup: removed %s (%s)\n", o.Name, o.Reason)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d orphaned utuns could not be removed", failed, len(orphans))
+	}
+	return nil
+}
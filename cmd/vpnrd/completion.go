@@ -0,0 +1,125 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// cmdCompletion prints a completion script for shell, generated from the
+// commands table and the registered global flags.
+func cmdCompletion(shell string) error {
+	var names []string
+	for _, c := range commands {
+		names = append(names, c.name)
+	}
+	var flags []string
+	flag.VisitAll(func(f *flag.Flag) { flags = append(flags, f.Name) })
+
+	switch shell {
+	case "bash":
+		fmt.Print(bashCompletion(names, flags))
+	case "zsh":
+		fmt.Print(zshCompletion(flags))
+	case "fish":
+		fmt.Print(fishCompletion(flags))
+	default:
+		return fmt.Errorf("unsupported shell %q (want bash, zsh or fish)", shell)
+	}
+	return nil
+}
+
+func bashCompletion(names, flags []string) string {
+	var dashed []string
+	for _, f := range flags {
+		dashed = append(dashed, "-"+f, "--"+f)
+	}
+	return fmt.Sprintf(`# bash completion for vpnrd
+# eval "$(vpnrd completion bash)"
+_vpnrd() {
+  local cur prev
+  cur="${COMP_WORDS[COMP_CWORD]}"
+  prev="${COMP_WORDS[COMP_CWORD-1]}"
+  case "$prev" in
+    -config|--config)
+      COMPREPLY=( $(compgen -f -X '!*.yaml' -- "$cur") $(compgen -d -- "$cur") )
+      return ;;
+    -profile|--profile)
+      COMPREPLY=( $(compgen -W "$(vpnrd profiles 2>/dev/null | sed -n 's/^[* ] //p')" -- "$cur") )
+      return ;;
+    completion)
+      COMPREPLY=( $(compgen -W "bash zsh fish" -- "$cur") )
+      return ;;
+  esac
+  if [[ "$cur" == -* ]]; then
+    COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+  else
+    COMPREPLY=( $(compgen -W "%s" -- "$cur") )
+  fi
+}
+complete -o filenames -F _vpnrd vpnrd
+`, strings.Join(dashed, " "), strings.Join(names, " "))
+}
+
+func zshCompletion(flags []string) string {
+	var b strings.Builder
+	b.WriteString("#compdef vpnrd\n# vpnrd completion zsh > \"${fpath[1]}/_vpnrd\"\n")
+	b.WriteString("_vpnrd_profiles() {\n  compadd -- ${(f)\"$(vpnrd profiles 2>/dev/null | sed -n 's/^[* ] //p')\"}\n}\n")
+	b.WriteString("_vpnrd() {\n  local -a cmds\n  cmds=(\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "    %q\n", c.name+":"+c.desc)
+	}
+	b.WriteString("  )\n  _arguments \\\n")
+	for _, f := range flags {
+		action := ""
+		switch f {
+		case "config":
+			action = `:config file:_files -g "*.yaml"`
+		case "profile":
+			action = ":profile:_vpnrd_profiles"
+		default:
+			if fl := flag.Lookup(f); fl != nil && !isBoolFlag(fl) {
+				action = ":value:"
+			}
+		}
+		fmt.Fprintf(&b, "    '-%s[%s]%s' \\\n", f, zshEscape(flag.Lookup(f).Usage), action)
+	}
+	b.WriteString("    '1:command:->cmd' \\\n    '*::arg:->args'\n")
+	b.WriteString("  case $state in\n    cmd) _describe 'command' cmds ;;\n")
+	b.WriteString("    args) [[ $words[1] == completion ]] && _values 'shell' bash zsh fish ;;\n  esac\n}\n_vpnrd \"$@\"\n")
+	return b.String()
+}
+
+func fishCompletion(flags []string) string {
+	var b strings.Builder
+	b.WriteString("# fish completion for vpnrd\n# vpnrd completion fish > ~/.config/fish/completions/vpnrd.fish\n")
+	b.WriteString("complete -c vpnrd -f\n")
+	for _, c := range commands {
+		fmt.Fprintf(&b, "complete -c vpnrd -n __fish_use_subcommand -a %s -d %q\n", c.name, c.desc)
+	}
+	b.WriteString("complete -c vpnrd -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'\n")
+	for _, f := range flags {
+		fl := flag.Lookup(f)
+		line := fmt.Sprintf("complete -c vpnrd -o %s -d %q", f, fl.Usage)
+		switch {
+		case f == "config":
+			line += " -r -F -a '(__fish_complete_suffix .yaml)'"
+		case f == "profile":
+			line += " -x -a '(vpnrd profiles 2>/dev/null | string replace -r \"^[* ] \" \"\" | string match -rv \":$\")'"
+		case !isBoolFlag(fl):
+			line += " -r"
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	bf, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && bf.IsBoolFlag()
+}
+
+func zshEscape(s string) string {
+	r := strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`)
+	return r.Replace(s)
+}
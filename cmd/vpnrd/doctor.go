@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/firewall"
+	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+	"github.com/revolver-sys/vpn-router-daemon/internal/router"
+)
+
+type checkLevel string
+
+const (
+	checkPass checkLevel = "PASS"
+	checkWarn checkLevel = "WARN"
+	checkFail checkLevel = "FAIL"
+)
+
+type checkResult struct {
+	Name   string
+	Level  checkLevel
+	Detail string
+}
+
+// cmdDoctor runs preflight checks and prints a pass/warn/fail checklist.
+// It returns an error if any check failed.
+func cmdDoctor(cfgPath string, healthTimeout time.Duration, healthURL string) error {
+	ctx := context.Background()
+	var results []checkResult
+	add := func(name string, level checkLevel, format string, a ...any) {
+		results = append(results, checkResult{Name: name, Level: level, Detail: fmt.Sprintf(format, a...)})
+	}
+
+	// 1) root (pf and interface changes need it)
+	if os.Geteuid() == 0 {
+		add("root", checkPass, "running as root")
+	} else {
+		add("root", checkFail, "euid=%d; pf/ifconfig changes require root (use: sudo vpnrd doctor)", os.Geteuid())
+	}
+
+	// 2) config: parse first so the remaining checks can run even when invalid
+	cfg, err := config.Parse(cfgPath)
+	if err != nil {
+		add("config", checkFail, "%v", err)
+		return printDoctor(results)
+	}
+	router.Configure(cfg)
+	if err := cfg.Validate(); err != nil {
+		add("config", checkFail, "%v", err)
+	} else {
+		add("config", checkPass, "%s", cfgPath)
+	}
+
+	// 3) scripts
+	scripts := []struct{ key, path string }{
+		{"vpn_router_setup_path", cfg.VPNRouterSetupPath},
+		{"vpn_router_pf_apply_path", cfg.VPNRouterPFApplyPath},
+		{"vpn_router_down_path", cfg.VPNRouterDownPath},
+	}
+	for _, sc := range scripts {
+		name := "script " + sc.key
+		if sc.key == "vpn_router_pf_apply_path" && cfg.PFManaged {
+			add(name, checkPass, "not used (pf_managed; anchor %s)", cfg.PFAnchor)
+			continue
+		}
+		if strings.TrimSpace(sc.path) == "" {
+			add(name, checkFail, "not set")
+			continue
+		}
+		if err := config.CheckExecutable(sc.path); err != nil {
+			add(name, checkFail, "%v", err)
+			continue
+		}
+		add(name, checkPass, "%s", sc.path)
+	}
+
+	// 4) sing-box binary (only fatal if we are expected to start it)
+	sbLevel := checkWarn
+	if cfg.SingBoxAutoStart {
+		sbLevel = checkFail
+	}
+	if err := config.CheckExecutable(cfg.SingBoxPath); err != nil {
+		add("sing-box binary", sbLevel, "%v", err)
+	} else if res, err := control.RunScript(ctx, cfg.SingBoxPath, cfg.CommandTimeout, "version"); err != nil {
+		add("sing-box binary", sbLevel, "%s version: %v", cfg.SingBoxPath, err)
+	} else {
+		add("sing-box binary", checkPass, "%s", firstLine(res.Stdout))
+	}
+
+	// 5) firewall tool (pfctl / nft)
+	if fw, err := firewall.For(cfg.Firewall); err != nil {
+		add("firewall", checkFail, "%v", err)
+	} else if p, err := exec.LookPath(fw.Binary()); err != nil {
+		add(fw.Binary(), checkFail, "%s not found in PATH", fw.Binary())
+	} else {
+		fi := fw.Info(ctx)
+		switch {
+		case fi.Err != "":
+			add(fw.Binary(), checkWarn, "%s present but querying %s failed: %s", p, fw.Name(), fi.Err)
+		case !fi.Enabled:
+			add(fw.Binary(), checkWarn, "%s present; %s currently disabled (vpnrd up enables it)", p, fw.Name())
+		default:
+			add(fw.Binary(), checkPass, "%s present; %s enabled", p, fw.Name())
+		}
+	}
+
+	// 6) health URL reachable right now (plain reachability; egress IP not enforced)
+	if healthURL == "" {
+		healthURL = cfg.HealthCheckURL
+	}
+	if healthTimeout == 0 {
+		healthTimeout = cfg.HealthTimeout
+	}
+	h := healthcheck.Check(ctx, healthURL, healthTimeout)
+	if h.OK {
+		add("health url", checkPass, "%s status=%d latency=%s body=%q", healthURL, h.StatusCode, h.Latency, h.Body)
+	} else {
+		add("health url", checkFail, "%s status=%d err=%q", healthURL, h.StatusCode, h.Err)
+	}
+
+	return printDoctor(results)
+}
+
+func printDoctor(results []checkResult) error {
+	fails := 0
+	for _, r := range results {
+		fmt.Printf("[vpnrd] doctor: [%s] %s: %s\n", r.Level, r.Name, r.Detail)
+		if r.Level == checkFail {
+			fails++
+		}
+	}
+	if fails > 0 {
+		return fmt.Errorf("%d check(s) failed", fails)
+	}
+	fmt.Printf("[vpnrd] doctor: environment ready\n")
+	return nil
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+// Process exit codes. They are stable (see README "Exit codes") so launchd,
+// cron or monitoring can decide whether a restart is worthwhile.
+const (
+	exitFailure    = 1 // unclassified
+	exitConfig     = 2 // config missing or invalid: restarting won't help
+	exitTunnelDown = 3 // no utun, sing-box not owned/running, health failed: usually transient
+	exitPermission = 4 // not root
+	exitTimeout    = 5 // a script or wait timed out
+)
+
+// exitCode maps err to its exit code via the vpnerr classes.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, vpnerr.ErrConfig):
+		return exitConfig
+	case errors.Is(err, vpnerr.ErrPermission):
+		return exitPermission
+	case errors.Is(err, vpnerr.ErrNoUTUN), errors.Is(err, vpnerr.ErrSingBoxNotOwned), errors.Is(err, vpnerr.ErrHealthFailed):
+		return exitTunnelDown
+	case errors.Is(err, vpnerr.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	}
+	return exitFailure
+}
+
+// fatal logs "<what> failed: <err>" and exits with err's exit code.
+func fatal(what string, err error) {
+	logx.Errorf("%s failed: %v", what, err)
+	os.Exit(exitCode(err))
+}
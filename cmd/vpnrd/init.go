@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+)
+
+// cmdInit writes a commented starter config to cfgPath and round-trips it
+// through the config loader. Script paths in the template are placeholders,
+// so validation problems are reported as "edit before use" rather than failures.
+func cmdInit(cfgPath string, args []string) error {
+	fs := flag.NewFlagSet("init", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	force := fs.Bool("force", false, "overwrite an existing config file")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("init flags: %w", err)
+	}
+
+	if err := config.WriteTemplate(cfgPath, *force); err != nil {
+		return err
+	}
+	fmt.Printf("[vpnrd] init: wrote %s\n", cfgPath)
+
+	cfg, err := config.Parse(cfgPath)
+	if err != nil {
+		return fmt.Errorf("template does not parse (bug): %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Printf("[vpnrd] init: edit before use: %v\n", err)
+		return nil
+	}
+	fmt.Printf("[vpnrd] init: config valid\n")
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/atomicfile"
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+)
+
+const (
+	launchdLabel = "com.revolver.vpnrd"
+	// LaunchDaemons (not LaunchAgents) run as root at boot, which "vpnrd run" needs.
+	launchdPlistPath = "/Library/LaunchDaemons/" + launchdLabel + ".plist"
+	// defaultLaunchdLog is used when vpnrd_log_file is not configured.
+	defaultLaunchdLog = "/usr/local/var/log/vpnrd.log"
+)
+
+// launchdPlist runs "vpnrd run" as root. KeepAlive restarts it only after a
+// failed exit, so "launchctl bootout" (SIGTERM, exit 0) keeps it stopped;
+// ThrottleInterval spaces out restarts of a daemon that keeps giving up.
+var launchdPlist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{xml .Binary}}</string>
+		<string>-config</string>
+		<string>{{xml .Config}}</string>
+		<string>run</string>
+	</array>
+	<key>UserName</key>
+	<string>root</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ThrottleInterval</key>
+	<integer>10</integer>
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>PATH</key>
+		<string>/usr/local/bin:/opt/homebrew/bin:/usr/bin:/bin:/usr/sbin:/sbin</string>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{xml .Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Log}}</string>
+</dict>
+</plist>
+`))
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// cmdInstallLaunchd prints a LaunchDaemon plist for this binary and config,
+// or installs it with --write.
+func cmdInstallLaunchd(cfg *config.Config, cfgPath string, args []string) error {
+	fs := flag.NewFlagSet("install-launchd", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	write := fs.Bool("write", false, "write the plist to "+launchdPlistPath+" (needs root)")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("install-launchd flags: %w", err)
+	}
+
+	// launchd has no working directory to resolve relative paths against.
+	bin, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("locate vpnrd binary: %w", err)
+	}
+	if bin, err = filepath.EvalSymlinks(bin); err != nil {
+		return fmt.Errorf("locate vpnrd binary: %w", err)
+	}
+	absCfg, err := filepath.Abs(cfgPath)
+	if err != nil {
+		return fmt.Errorf("config path: %w", err)
+	}
+	logFile := cfg.VPNRDLogFile
+	if logFile == "" {
+		logFile = defaultLaunchdLog
+	}
+
+	var b bytes.Buffer
+	if err := launchdPlist.Execute(&b, struct{ Label, Binary, Config, Log string }{
+		launchdLabel, bin, absCfg, logFile,
+	}); err != nil {
+		return fmt.Errorf("render plist: %w", err)
+	}
+
+	if !*write {
+		os.Stdout.Write(b.Bytes())
+		fmt.Fprintf(os.Stderr, "[vpnrd] install-launchd: save this as %s (root:wheel, 0644), then:\n", launchdPlistPath)
+		fmt.Fprintf(os.Stderr, "  sudo launchctl bootstrap system %s\n", launchdPlistPath)
+		fmt.Fprintf(os.Stderr, "[vpnrd] or run: sudo vpnrd install-launchd --write\n")
+		return nil
+	}
+
+	if err := requireRoot("install-launchd"); err != nil {
+		return err
+	}
+	if control.DryRun() {
+		logx.Infof("[vpnrd] dry-run: would write %s and bootstrap %s", launchdPlistPath, launchdLabel)
+		return nil
+	}
+	if err := atomicfile.Write(launchdPlistPath, b.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("write plist: %w", err)
+	}
+	fmt.Printf("[vpnrd] install-launchd: wrote %s\n", launchdPlistPath)
+	if cfg.VPNRDLogFile == "" {
+		fmt.Printf("[vpnrd] install-launchd: logging to %s; set vpnrd_log_file to the same path for \"vpnrd logs\"\n", logFile)
+	}
+	fmt.Printf("[vpnrd] install-launchd: start it with: sudo launchctl bootstrap system %s\n", launchdPlistPath)
+	return nil
+}
+
+// cmdUninstallLaunchd stops the LaunchDaemon if it is loaded and removes its plist.
+func cmdUninstallLaunchd(ctx context.Context) error {
+	if err := requireRoot("uninstall-launchd"); err != nil {
+		return err
+	}
+	if control.DryRun() {
+		logx.Infof("[vpnrd] dry-run: would bootout %s and remove %s", launchdLabel, launchdPlistPath)
+		return nil
+	}
+
+	// Not loaded is fine; the plist may have been written but never bootstrapped.
+	bctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if out, err := exec.CommandContext(bctx, "launchctl", "bootout", "system/"+launchdLabel).CombinedOutput(); err != nil {
+		logx.Debugf("[vpnrd] launchctl bootout system/%s: %v: %s", launchdLabel, err, bytes.TrimSpace(out))
+	} else {
+		fmt.Printf("[vpnrd] uninstall-launchd: stopped %s\n", launchdLabel)
+	}
+
+	if err := os.Remove(launchdPlistPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("[vpnrd] uninstall-launchd: %s not installed\n", launchdPlistPath)
+			return nil
+		}
+		return fmt.Errorf("remove plist: %w", err)
+	}
+	fmt.Printf("[vpnrd] uninstall-launchd: removed %s\n", launchdPlistPath)
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logtail"
+)
+
+// logSource is one log file shown by "vpnrd logs".
+type logSource struct {
+	tag  string // line prefix
+	path string
+	last time.Time // timestamp of the previous line, for continuation lines
+}
+
+type logLine struct {
+	t    time.Time
+	tag  string
+	text string
+}
+
+// cmdLogs prints the last lines of vpnrd's and sing-box's logs merged by
+// timestamp; with --follow it keeps streaming new lines until Ctrl-C.
+func cmdLogs(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	follow := fs.Bool("follow", false, "keep printing new lines (like tail -f)")
+	fs.BoolVar(follow, "f", false, "shorthand for --follow")
+	n := fs.Int("lines", 50, "lines to show from each log")
+	vpnrdLog := fs.String("vpnrd-log", cfg.VPNRDLogFile, "vpnrd log file (overrides vpnrd_log_file)")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("logs flags: %w", err)
+	}
+
+	var sources []*logSource
+	if *vpnrdLog != "" {
+		sources = append(sources, &logSource{tag: "vpnrd", path: *vpnrdLog})
+	}
+	if cfg.SingBoxLogFile != "" {
+		sources = append(sources, &logSource{tag: "sing-box", path: cfg.SingBoxLogFile})
+	}
+	if len(sources) == 0 {
+		return fmt.Errorf("no log files configured (set vpnrd_log_file and/or singbox_log_file)")
+	}
+
+	var lines []logLine
+	for _, src := range sources {
+		ls, err := logtail.Lines(src.path, *n)
+		if err != nil {
+			return fmt.Errorf("%s log: %w", src.tag, err)
+		}
+		if ls == nil {
+			fmt.Printf("[vpnrd] logs: %s log %s is missing or empty\n", src.tag, src.path)
+		}
+		lines = append(lines, src.parse(ls)...)
+	}
+	printLogLines(lines)
+
+	if !*follow {
+		return nil
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	followers := make([]*logtail.Follower, len(sources))
+	for i, src := range sources {
+		followers[i] = logtail.NewFollower(src.path)
+	}
+	t := time.NewTicker(500 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		// Lines that arrived in the same poll are merged; across polls, arrival order wins.
+		var batch []logLine
+		for i, src := range sources {
+			ls, err := followers[i].Poll()
+			if err != nil {
+				fmt.Printf("[vpnrd] logs: %s: %v\n", src.tag, err)
+				continue
+			}
+			batch = append(batch, src.parse(ls)...)
+		}
+		printLogLines(batch)
+	}
+}
+
+// parse timestamps each line; lines without one (stack traces, wrapped output)
+// keep the previous line's time so they stay attached to it after merging.
+func (src *logSource) parse(ls []string) []logLine {
+	out := make([]logLine, 0, len(ls))
+	for _, l := range ls {
+		l = reANSI.ReplaceAllString(l, "")
+		if t, ok := parseLogTime(l); ok {
+			src.last = t
+		}
+		out = append(out, logLine{t: src.last, tag: src.tag, text: l})
+	}
+	return out
+}
+
+func printLogLines(lines []logLine) {
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].t.Before(lines[j].t) })
+	for _, l := range lines {
+		fmt.Printf("%-8s | %s\n", l.tag, l.text)
+	}
+}
+
+var (
+	reANSI = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+	// vpnrd (Go log.LstdFlags): "2006/01/02 15:04:05 ..." in local time.
+	reGoLogTime = regexp.MustCompile(`^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})`)
+	// sing-box: "-0700 2006-01-02 15:04:05 INFO ...".
+	reSingBoxTime = regexp.MustCompile(`^([+-]\d{4} \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})`)
+)
+
+func parseLogTime(l string) (time.Time, bool) {
+	if m := reGoLogTime.FindStringSubmatch(l); m != nil {
+		if t, err := time.ParseInLocation("2006/01/02 15:04:05", m[1], time.Local); err == nil {
+			return t, true
+		}
+	}
+	if m := reSingBoxTime.FindStringSubmatch(l); m != nil {
+		if t, err := time.Parse("-0700 2006-01-02 15:04:05", m[1]); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
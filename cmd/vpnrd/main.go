@@ -2,46 +2,86 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
-	"regexp"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/revolver-sys/vpn-router-daemon/internal/config"
 	"github.com/revolver-sys/vpn-router-daemon/internal/control"
 	"github.com/revolver-sys/vpn-router-daemon/internal/debugdump"
 	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+	"github.com/revolver-sys/vpn-router-daemon/internal/killswitch"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/pf"
+	"github.com/revolver-sys/vpn-router-daemon/internal/router"
 	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
 	"github.com/revolver-sys/vpn-router-daemon/internal/status"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
 )
 
 // const version = "0.2.0"
 
 var version = "dev"
 
-func usage() {
-	fmt.Fprintf(os.Stderr, `vpnrd - Sing-box pf NAT VPN router daemon
-
-Usage:
-  vpnrd up        - start VPN router (sing-box + pf NAT)
-  vpnrd down      - stop VPN router and restore normal state
-  vpnrd run       - run watchdog daemon (keeps tunnel healthy)
-  vpnrd status    - show current status
-  vpnrd -h        - show help
+// commands is the subcommand list shown in usage and offered by shell completion.
+var commands = []struct{ name, desc string }{
+	{"up", "start VPN router (sing-box + pf NAT) (--json prints each script's result)"},
+	{"down", "stop VPN router and restore normal state (--json)"},
+	{"restart", "restart owned sing-box (re-adopt an external one) and re-apply pf for its utun, as a watchdog recovery does"},
+	{"run", "run watchdog daemon (keeps tunnel healthy)"},
+	{"status", "show current status (--json, --probe, or --watch [--interval 2s] to refresh live)"},
+	{"profiles", "list profiles (<config dir>/<name>.yaml) for -profile"},
+	{"init", "write a starter config to -config path (--force to overwrite)"},
+	{"doctor", "preflight checks (config, scripts, sing-box, pf, root, health URL)"},
+	{"selftest", "run up, wait for a healthy egress, run down and check the tunnel is gone (--yes); \"selftest recovery\" breaks and recovers a running tunnel"},
+	{"killswitch-test", "verify default-route and WAN-bound traffic cannot bypass the tunnel"},
+	{"topology", "show WAN/LAN/tunnel interfaces, default route and the pf_apply arguments (--json)"},
+	{"owner", "show which process owns a tun interface, e.g. \"owner utun66\" (--json)"},
+	{"show-cmd", "print the command vpnrd starts sing-box with and where its output goes (--json)"},
+	{"logs", "show vpnrd + sing-box logs merged by time (--follow, --lines 50)"},
+	{"cleanup", "remove utuns left behind by a crashed sing-box (--dry-run lists them)"},
+	{"install-launchd", "print a LaunchDaemon plist running \"vpnrd run\" as root (--write installs it)"},
+	{"uninstall-launchd", "stop and remove the LaunchDaemon installed by install-launchd"},
+	{"completion", "print shell completion script (bash|zsh|fish)"},
+}
 
-`)
+func usage() {
+	fmt.Fprintf(os.Stderr, "vpnrd - Sing-box pf NAT VPN router daemon\n\nUsage:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  vpnrd %-11s - %s\n", c.name, c.desc)
+	}
+	fmt.Fprintf(os.Stderr, "  vpnrd %-11s - %s\n\n", "-h", "show help")
 	flag.PrintDefaults()
 }
 
-func requireRoot() error {
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("this command must run as root. Use: sudo vpnrd <cmd>")
+// allowNonroot is set by --allow-nonroot (root-requiring commands proceed without root).
+var allowNonroot bool
+
+// rootCommands must run as root: they drive pfctl, ifconfig and signal processes.
+var rootCommands = map[string]bool{"up": true, "down": true, "restart": true, "run": true}
+
+// requireRoot fails cmd unless vpnrd runs as root or elevates via use_sudo;
+// --allow-nonroot downgrades that to a warning.
+func requireRoot(cmd string) error {
+	// Dry-run only inspects state and logs, so it is allowed without root.
+	if control.DryRun() || os.Geteuid() == 0 {
+		return nil
 	}
-	return nil
+	if control.Sudo() {
+		logx.Debugf("[vpnrd] %s without root: scripts and pfctl run via sudo (use_sudo)", cmd)
+		return nil
+	}
+	if allowNonroot {
+		logx.Warnf("[vpnrd] warning: %s without root (--allow-nonroot); pf and sing-box control will likely fail", cmd)
+		return nil
+	}
+	return fmt.Errorf("%w: vpnrd %s must run as root (try: sudo vpnrd %s; --allow-nonroot skips this check)", vpnerr.ErrPermission, cmd, cmd)
 }
 
 func main() {
@@ -49,23 +89,44 @@ func main() {
 
 	flag.Usage = usage
 	showVersion := flag.Bool("version", false, "show version and exit")
-	debug := flag.Bool("debug", false, "enable debug dumps (or set VPNRD_DEBUG=1)")
+	debug := flag.Bool("debug", false, "enable debug dumps (or set VPNRD_DEBUG=1; written to debug_dump_dir, none when it is unset)")
 	wanIF := flag.String("wan", "", "override WAN interface (config default if empty)")
 	lanIF := flag.String("lan", "", "override LAN interface (config default if empty)")
 	healthURL := flag.String("health-url", "", "override watchdog health URL (config default if empty)")
 	healthTimeout := flag.Duration("health-timeout", 0, "override watchdog health timeout (e.g. 2s)")
+	flag.BoolVar(&allowNonroot, "allow-nonroot", false, "run up/down/restart/run without root (pf and process control will likely fail)")
+	dryRun := flag.Bool("dry-run", false, "log scripts and sing-box start/stop instead of executing them")
+	logLevel := flag.String("log-level", "", "error, warn, info or debug (overrides config log_level)")
+	verbose := flag.Bool("verbose", false, "shorthand for --log-level debug")
 
 	// Global flag: config path
 	defaultCfg, _ := config.DefaultPath()
 	cfgPath := flag.String("config", defaultCfg, "path to config file")
+	profile := flag.String("profile", "", "use <config dir>/<name>.yaml instead of -config")
 
 	flag.Parse()
 
+	if *profile != "" {
+		p, err := config.ProfilePath(*profile)
+		if err != nil {
+			logx.Errorf("profile: %v", err)
+			os.Exit(exitConfig)
+		}
+		*cfgPath = p
+	}
+
 	debugdump.EnableFromEnv()
 	if *debug {
 		debugdump.Enable()
 	}
 
+	if *dryRun {
+		control.SetDryRun(true)
+		singboxctl.SetDryRun(true)
+		pf.SetDryRun(true)
+		logx.Infof("[vpnrd] dry-run: no scripts will run and sing-box will not be started/stopped")
+	}
+
 	if *showVersion {
 		fmt.Printf("vpnrd version %s\n", version)
 		return
@@ -76,14 +137,69 @@ func main() {
 		os.Exit(1)
 	}
 
+	if flag.Arg(0) == "completion" {
+		if err := cmdCompletion(flag.Arg(1)); err != nil {
+			fatal("completion", err)
+		}
+		return
+	}
+
+	if flag.Arg(0) == "profiles" {
+		if err := cmdProfiles(*cfgPath); err != nil {
+			fatal("profiles", err)
+		}
+		return
+	}
+
+	// init creates the config, so it runs before loading one.
+	if flag.Arg(0) == "init" {
+		if err := cmdInit(*cfgPath, flag.Args()[1:]); err != nil {
+			fatal("init", err)
+		}
+		return
+	}
+
+	// doctor reports config problems itself instead of bailing out on them.
+	if flag.Arg(0) == "doctor" {
+		if err := cmdDoctor(*cfgPath, *healthTimeout, *healthURL); err != nil {
+			fatal("doctor", err)
+		}
+		return
+	}
+
 	cfg, err := config.Load(*cfgPath)
 	if err != nil {
-		log.Printf("config load failed: %v", err)
-		os.Exit(1)
+		fatal("config load", err)
+	}
+
+	router.Configure(cfg)
+
+	// Dumps need both --debug / VPNRD_DEBUG and debug_dump_dir.
+	debugdump.Configure(cfg.DebugDumpDir, cfg.DebugDumpMax)
+
+	lvl, _ := logx.ParseLevel(cfg.LogLevel) // validated by config.Load
+	if *logLevel != "" {
+		if lvl, err = logx.ParseLevel(*logLevel); err != nil {
+			fatal("log level", err)
+		}
+	}
+	if *verbose {
+		lvl = logx.Debug
 	}
+	logx.SetLevel(lvl)
+
+	singboxctl.MigrateLegacyPidfile(cfg)
 
 	cmd := flag.Arg(0)
 
+	// Fail early with a clear message instead of baffling partial pfctl/kill failures.
+	if rootCommands[cmd] {
+		if err := requireRoot(cmd); err != nil {
+			logx.Errorf("%v", err)
+			os.Exit(exitPermission)
+		}
+	}
+
 	effectiveHealthTimeout := cfg.HealthTimeout
 	// If user provided --health-timeout (before OR after the subcommand), prefer it.
 	// We set the flag default to 0 so "not provided" is distinguishable.
@@ -101,231 +217,262 @@ func main() {
 	effectiveWAN := cfg.WANIF
 	effectiveLAN := cfg.LANIF
 
+	watch := false
+	asJSON := false
+	statusProbe := false
+	watchInterval := 2 * time.Second
+
 	// Support flags placed *after* the subcommand, e.g.:
 	//   vpnrd run --health-timeout 2s
 	// The standard flag package stops parsing at the first non-flag ("run"),
-	// so we parse the remaining args again for run/status.
-	if (cmd == "run" || cmd == "status") && len(flag.Args()) > 1 {
+	// so we parse the remaining args again for run/status/up/down.
+	if (cmd == "run" || cmd == "status" || cmd == "up" || cmd == "down") && len(flag.Args()) > 1 {
 		fs := flag.NewFlagSet(cmd, flag.ContinueOnError)
 		fs.SetOutput(io.Discard) // avoid noisy output; we show our own messages
 		extraHealthTimeout := fs.Duration("health-timeout", effectiveHealthTimeout, "health check timeout (overrides config)")
 		extraHealthURL := fs.String("health-url", effectiveHealthURL, "health check URL (overrides config)")
+		extraWatch := fs.Bool("watch", false, "status: refresh continuously until Ctrl-C")
+		extraJSON := fs.Bool("json", false, "status: print the snapshot as JSON; up/down: print script results as JSON")
+		extraProbe := fs.Bool("probe", false, "status: probe now (health, and throughput if configured) instead of reading the running watchdog's state")
+		extraInterval := fs.Duration("interval", watchInterval, "status --watch: refresh interval")
 		_ = fs.Parse(flag.Args()[1:])
 		if fs.Parsed() {
 			effectiveHealthTimeout = *extraHealthTimeout
 			effectiveHealthURL = *extraHealthURL
+			watch = *extraWatch
+			asJSON = *extraJSON
+			statusProbe = *extraProbe
+			watchInterval = *extraInterval
 		}
 	}
 
 	if *wanIF != "" {
 		effectiveWAN = *wanIF
+		cfg.WANAutoDetect = false // an explicit --wan is not re-detected by the watchdog either
 	}
 
 	if *lanIF != "" {
 		effectiveLAN = *lanIF
 	}
 
+	if cfg.WANAutoDetect && (cmd == "up" || cmd == "run" || cmd == "killswitch-test" || cmd == "topology" || cmd == "selftest") {
+		effectiveWAN = router.DetectWAN(context.Background(), cfg)
+	}
+	opts := router.Options{
+		ConfigPath:    *cfgPath,
+		WAN:           effectiveWAN,
+		LAN:           effectiveLAN,
+		HealthURL:     effectiveHealthURL,
+		HealthTimeout: effectiveHealthTimeout,
+	}
+
 	switch cmd {
 	case "up":
-		if err := cmdUp(cfg, *cfgPath, effectiveWAN, effectiveLAN); err != nil {
-			log.Fatalf("up failed: %v", err)
+		runs, err := cmdUp(context.Background(), cfg, opts)
+		printScripts("up", runs, err, asJSON)
+		if err != nil {
+			fatal("up", err)
+		}
+		if cfg.PFManaged && !asJSON {
+			fmt.Printf("[vpnrd] pf anchor %s: loaded\n", cfg.PFAnchor)
 		}
 	case "down":
-		if err := cmdDown(cfg); err != nil {
-			log.Fatalf("down failed: %v", err)
+		runs, err := cmdDown(context.Background(), cfg)
+		printScripts("down", runs, err, asJSON)
+		if err != nil {
+			fatal("down", err)
+		}
+	case "restart":
+		if err := cmdRestart(context.Background(), cfg, opts); err != nil {
+			fatal("restart", err)
 		}
 	case "run":
-		if err := cmdRun(cfg, *cfgPath, effectiveHealthTimeout, effectiveHealthURL, effectiveWAN, effectiveLAN); err != nil {
-			log.Fatalf("run failed: %v", err)
+		if err := cmdRun(cfg, opts); err != nil {
+			fatal("run", err)
+		}
+	case "killswitch-test":
+		if err := cmdKillSwitchTest(cfg, effectiveHealthURL, effectiveHealthTimeout, effectiveWAN); err != nil {
+			fatal("killswitch-test", err)
 		}
 	case "status":
-		if err := cmdStatus(cfg, *cfgPath, effectiveHealthTimeout); err != nil {
-			log.Fatalf("status failed: %v", err)
+		if watch {
+			if err := cmdStatusWatch(cfg, *cfgPath, effectiveHealthTimeout, watchInterval); err != nil {
+				fatal("status", err)
+			}
+			return
+		}
+		if err := cmdStatus(cfg, *cfgPath, effectiveHealthTimeout, asJSON, statusProbe); err != nil {
+			fatal("status", err)
+		}
+	case "selftest":
+		if err := cmdSelftest(cfg, opts, flag.Args()[1:]); err != nil {
+			fatal("selftest", err)
+		}
+	case "topology":
+		if err := cmdTopology(context.Background(), cfg, opts, flag.Args()[1:]); err != nil {
+			fatal("topology", err)
+		}
+	case "owner":
+		if err := cmdOwner(context.Background(), cfg, flag.Args()[1:]); err != nil {
+			fatal("owner", err)
+		}
+	case "show-cmd":
+		if err := cmdShowCmd(cfg, flag.Args()[1:]); err != nil {
+			fatal("show-cmd", err)
+		}
+	case "logs":
+		if err := cmdLogs(cfg, flag.Args()[1:]); err != nil {
+			fatal("logs", err)
+		}
+	case "cleanup":
+		if err := cmdCleanup(context.Background(), cfg, flag.Args()[1:]); err != nil {
+			fatal("cleanup", err)
+		}
+	case "install-launchd":
+		if err := cmdInstallLaunchd(cfg, *cfgPath, flag.Args()[1:]); err != nil {
+			fatal("install-launchd", err)
+		}
+	case "uninstall-launchd":
+		if err := cmdUninstallLaunchd(context.Background()); err != nil {
+			fatal("uninstall-launchd", err)
+		}
+	case "simulate":
+		if err := cmdSimulate(cfg, flag.Args()[1:]); err != nil {
+			fatal("simulate", err)
 		}
 	default:
-		log.Printf("unknown command: %q\n", cmd)
+		logx.Errorf("unknown command: %q\n", cmd)
 		usage()
 		os.Exit(1)
 	}
 }
 
-func cmdUp(cfg *config.Config, cfgPath string, wanIF string, lanIF string) error {
-	if err := requireRoot(); err != nil {
-		return err
+// cmdUp brings the router up and returns the scripts it ran, failed ones
+// included, for printScripts.
+func cmdUp(ctx context.Context, cfg *config.Config, opts router.Options) ([]scriptRun, error) {
+	up, err := router.Up(ctx, cfg, opts)
+	var runs []scriptRun
+	if up != nil && up.Setup != nil {
+		runs = append(runs, scriptRun{"setup", up.Setup})
 	}
-
-	// 0) Setup LAN + dnsmasq + pf anchors (slow). This script may have its own WAN/LAN defaults.
-	setupRes, err := control.RunScript(context.Background(), cfg.VPNRouterSetupPath, cfg.CommandTimeout)
-	if err != nil {
-		return formatScriptFailure("setup", setupRes, err)
-	}
-	printScriptSuccess("setup", setupRes)
-
-	// If WAN/LAN were not provided (config.yaml commented out), try to parse them from setup stdout.
-	effectiveWAN := strings.TrimSpace(wanIF)
-	effectiveLAN := strings.TrimSpace(lanIF)
-	if effectiveWAN == "" || effectiveLAN == "" {
-		// Example line: "WAN: en5  LAN: en8"
-		re := regexp.MustCompile(`(?m)^WAN:\s*(\S+)\s+LAN:\s*(\S+)\s*$`)
-		if m := re.FindStringSubmatch(setupRes.Stdout); len(m) == 3 {
-			if effectiveWAN == "" {
-				effectiveWAN = m[1]
-			}
-			if effectiveLAN == "" {
-				effectiveLAN = m[2]
-			}
-		}
-	}
-	if effectiveWAN == "" || effectiveLAN == "" {
-		return fmt.Errorf("wan_if/lan_if not set (config %q). Set them in config.yaml or pass --wan-if/--lan-if", cfgPath)
-	}
-
-	// 1) Ensure sing-box is running (Policy B adoption supported) and get the tunnel interface.
-	var utun string
-	if cfg.SingBoxAutoStart {
-		st, err := singboxctl.EnsureRunning(context.Background(), cfg, cfg.SingBoxStartTimeout)
-		if err != nil {
-			return fmt.Errorf("sing-box ensure running: %w", err)
-		}
-		log.Printf("[vpnrd] sing-box status: pid=%d owned=%t utun=%q", st.PID, st.OwnedByUs, st.NewUTUN)
-		utun = st.NewUTUN
-	}
-	if utun == "" {
-		return fmt.Errorf("no utun interface detected (sing-box auto-start disabled or failed)")
+	if up != nil && up.PFApply != nil {
+		runs = append(runs, scriptRun{"pf_apply", up.PFApply})
 	}
+	return runs, err
+}
 
-	// 2) Apply pf NAT + kill-switch rules (fast).
-	args := []string{
-		fmt.Sprintf("utun=%s", utun),
-		fmt.Sprintf("wan=%s", effectiveWAN),
-		fmt.Sprintf("lan=%s", effectiveLAN),
-		fmt.Sprintf("vpn_server_ips=%q", strings.Join(cfg.VPNServerIPs, ",")),
-		fmt.Sprintf("wan_dns=%q", strings.Join(cfg.WANDNSIPs, ",")),
-		fmt.Sprintf("allow_ntp=%t", cfg.AllowWANNTP),
-	}
-	log.Printf("[vpnrd] pf_apply args: %s", strings.Join(args, " "))
-	res, err := control.RunScript(context.Background(), cfg.VPNRouterPFApplyPath, cfg.CommandTimeout, args...)
-	if err != nil {
-		return formatScriptFailure("pf_apply", res, err)
+// cmdDown brings the router down and returns the down script's run.
+func cmdDown(ctx context.Context, cfg *config.Config) ([]scriptRun, error) {
+	res, err := router.Down(ctx, cfg)
+	if res == nil {
+		return nil, err
 	}
-	printScriptSuccess("pf_apply", res)
-
-	log.Printf("[vpnrd] router UP; utun=%s", utun)
-	return nil
+	return []scriptRun{{"down", res}}, err
 }
 
-func cmdDown(cfg *config.Config) error {
-	if err := requireRoot(); err != nil {
+// cmdRestart runs one recovery by hand: sing-box restarted, pf re-applied.
+func cmdRestart(ctx context.Context, cfg *config.Config, opts router.Options) error {
+	sb, err := router.Recover(ctx, cfg, opts)
+	if err != nil {
 		return err
 	}
-
-	// 0) Stop sing-box if vpnrd owns it
-	if err := singboxctl.StopIfOwned(cfg); err != nil {
-		return fmt.Errorf("sing-box stop: %w", err)
+	if sb == nil {
+		fmt.Printf("[vpnrd] restart: done (sing-box not found afterwards)\n")
+		return nil
 	}
-
-	// 1) Restore router state
-	res, err := control.RunScript(context.Background(), cfg.VPNRouterDownPath, cfg.CommandTimeout)
-	if err != nil {
-		return formatScriptFailure("down", res, err)
+	if sb.Planned {
+		fmt.Printf("[vpnrd] restart (dry run): sing-box would come up on %s; nothing was changed\n", orNone(sb.TunLabel()))
+		return nil
 	}
-	printScriptSuccess("down", res)
+	fmt.Printf("[vpnrd] restart: sing-box pid=%d owned=%t utun=%s\n", sb.PID, sb.OwnedByUs, orNone(sb.TunLabel()))
 	return nil
 }
 
-func cmdRun(cfg *config.Config, cfgPath string, healthTimeout time.Duration, healthURL string, effectiveWAN, effectiveLAN string) error {
-	if err := requireRoot(); err != nil {
-		return err
-	}
+// cmdRun runs the watchdog until SIGTERM (launchd, kill) or SIGINT (Ctrl-C).
+func cmdRun(cfg *config.Config, opts router.Options) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return router.NewWatchdog(cfg, opts).Run(ctx)
+}
 
-	// If caller overrides health timeout (CLI), use it as the polling interval too.
-	// (Otherwise user sees "timeout=2s" but still waits 10s between checks.)
-	interval := cfg.CheckInterval
-	if healthTimeout > 0 {
-		interval = healthTimeout
+func cmdStatus(cfg *config.Config, cfgPath string, healthTimeout time.Duration, asJSON, probe bool) error {
+	// A running watchdog publishes its live view to the status file every check;
+	// prefer it over re-probing so both agree. --probe forces a fresh look,
+	// including the throughput download; otherwise the watchdog's last one is shown.
+	var s status.Snapshot
+	live := false
+	if cfg.StatusFilePath != "" {
+		if prev, err := status.ReadFile(cfg.StatusFilePath); err == nil {
+			switch fresh := status.Fresh(prev, 3*cfg.CheckInterval); {
+			case fresh && !probe:
+				s, live = prev, true
+			case fresh:
+				s = status.Collect(context.Background(), cfg, cfgPath, healthTimeout)
+				// The watchdog's in-memory state (history etc.) is only visible through its status file.
+				s.Watchdog = prev.Watchdog
+				s.Throughput = prev.Throughput
+			}
+			// A stale file is left by a watchdog that is gone: none of it describes now.
+		}
+	}
+	if s.TimeUTC == "" {
+		s = status.Collect(context.Background(), cfg, cfgPath, healthTimeout)
+	}
+	if probe {
+		status.ProbeThroughput(context.Background(), cfg, &s)
 	}
 
-	log.Printf("watchdog running; interval=%s health_url=%s failure_threshold=%d",
-		interval, healthURL, cfg.FailureThreshold)
+	if asJSON {
+		b, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal status: %w", err)
+		}
+		fmt.Println(string(b))
+		return nil
+	}
 
-	t := time.NewTicker(interval)
-	defer t.Stop()
+	if live {
+		fmt.Printf("[vpnrd] note: live state from the running watchdog (%s); use --probe to re-check now\n", cfg.StatusFilePath)
+	} else if os.Geteuid() != 0 {
+		fmt.Printf("[vpnrd] note: not running as root; pf info unavailable (status is degraded)\n")
+	}
 
-	consecutiveFails := 0
-	recoveries := 0
+	printStatus(s)
 
-	for {
-		h := healthcheck.CheckExpected(context.Background(), healthURL, healthTimeout, cfg.VPNServerIPs)
-		debugdump.Dump("health", h)
+	// Optional debug dump (full struct)
+	debugdump.Dump("status_snapshot", s)
 
-		if h.OK {
-			if consecutiveFails > 0 {
-				log.Printf("health recovered after %d fails; body=%q latency=%s", consecutiveFails, h.Body, h.Latency)
-			}
-			consecutiveFails = 0
-		} else {
-			consecutiveFails++
-			log.Printf("health FAIL #%d: status=%d err=%q body=%q latency=%s",
-				consecutiveFails, h.StatusCode, h.Err, h.Body, h.Latency)
-		}
-
-		if consecutiveFails >= cfg.FailureThreshold {
-			if recoveries >= cfg.MaxRecoveries {
-				log.Printf("recovery budget exhausted (recoveries=%d); manual intervention required", recoveries)
-			} else {
-				recoveries++
-				log.Printf("attempting recovery #%d...", recoveries)
-
-				// (Optional) snapshot before recovery
-				snap := status.Collect(context.Background(), cfg, cfgPath, healthTimeout)
-				debugdump.Dump("status_before_recover", snap)
-
-				recErr := doRecovery(context.Background(), cfg, effectiveWAN, effectiveLAN)
-				if recErr != nil {
-					log.Printf("recovery #%d failed: %v", recoveries, recErr)
-				} else {
-					log.Printf("recovery #%d executed", recoveries)
-				}
-
-				time.Sleep(cfg.RecoverCooldown)
-
-				h2 := healthcheck.CheckExpected(context.Background(), healthURL, healthTimeout, cfg.VPNServerIPs)
-				debugdump.Dump("health_after_recover", h2)
-				if h2.OK {
-					if recErr == nil {
-						log.Printf("recovery #%d succeeded; health OK", recoveries)
-					} else {
-						log.Printf("health OK after failed recovery #%d (not counted as recovery success)", recoveries)
-					}
-					consecutiveFails = 0
-				} else {
-					log.Printf("recovery #%d did not restore health: status=%d err=%q body=%q",
-						recoveries, h2.StatusCode, h2.Err, h2.Body)
-				}
-			}
-		}
+	return nil
+}
 
-		<-t.C
+// orNone returns v, or "none" when it is empty.
+func orNone(v string) string {
+	if v == "" {
+		return "none"
 	}
+	return v
 }
 
-func cmdStatus(cfg *config.Config, cfgPath string, healthTimeout time.Duration) error {
-	s := status.Collect(context.Background(), cfg, cfgPath, healthTimeout)
-
+// printStatus renders the human-friendly status lines.
+func printStatus(s status.Snapshot) {
 	// Human-friendly lines
 	fmt.Printf("[vpnrd] time: %s\n", s.TimeUTC)
 	fmt.Printf("[vpnrd] config: %s\n", s.ConfigPath)
 
 	if s.SingBox != nil && s.SingBox.OwnedByUs {
-		fmt.Printf("[vpnrd] sing-box: owned pid=%d running=%v utun_hint=%q\n",
-			s.SingBox.PID, s.SingBox.Running, s.SingBox.NewUTUN)
+		fmt.Printf("[vpnrd] sing-box: owned pid=%d running=%v utun=%s\n",
+			s.SingBox.PID, s.SingBox.Running, orNone(s.SingBox.TunLabel()))
 	} else {
 		fmt.Printf("[vpnrd] sing-box: owned pidfile missing\n")
 	}
 
 	if s.SingBoxExternal != nil && s.SingBoxExternal.Running {
-		fmt.Printf("[vpnrd] sing-box: external pid=%d running=%v (matches config)\n",
-			s.SingBoxExternal.PID, s.SingBoxExternal.Running)
+		fmt.Printf("[vpnrd] sing-box: external pid=%d running=%v utun=%s (matches config)\n",
+			s.SingBoxExternal.PID, s.SingBoxExternal.Running, orNone(s.SingBoxExternal.TunLabel()))
+	}
+
+	if len(s.SingBoxCommand.Argv) > 0 {
+		fmt.Printf("[vpnrd] sing-box command: %s\n", s.SingBoxCommand)
 	}
 
 	if len(s.UTUNs) > 0 {
@@ -334,17 +481,131 @@ func cmdStatus(cfg *config.Config, cfgPath string, healthTimeout time.Duration)
 		fmt.Printf("[vpnrd] utuns: none\n")
 	}
 
-	fmt.Printf("[vpnrd] pf: enabled=%v\n", s.PFEnabled)
+	if r := s.DefaultRoute; r != nil {
+		// sing-box auto_route points the default route at its utun; anything
+		// else means this host's own traffic bypasses the tunnel.
+		via := "not the tunnel"
+		for _, sb := range []*singboxctl.Status{s.SingBox, s.SingBoxExternal} {
+			if sb != nil && sb.Running && sb.NewUTUN == r.Interface {
+				via = "tunnel"
+			}
+		}
+		fmt.Printf("[vpnrd] default route: %s via %s (%s)\n", r.Interface, orNone(r.Gateway), via)
+	} else if s.DefaultRouteErr != "" {
+		fmt.Printf("[vpnrd] default route: %s\n", s.DefaultRouteErr)
+	}
+
+	fw := s.Firewall
+	if fw == "" {
+		fw = "pf"
+	}
+	if fw == "nftables" {
+		fmt.Printf("[vpnrd] %s: nat=%v packets=%d bytes=%d\n", fw, s.PFEnabled, s.NATPackets, s.NATBytes)
+	} else {
+		fmt.Printf("[vpnrd] %s: enabled=%v\n", fw, s.PFEnabled)
+	}
 	if s.PFErr != "" {
-		fmt.Printf("[vpnrd] pf err: %s\n", s.PFErr)
+		fmt.Printf("[vpnrd] %s err: %s\n", fw, s.PFErr)
 	}
 
-	fmt.Printf("[vpnrd] health: ok=%v status=%d latency=%s body=%q err=%q\n",
-		s.Health.OK, s.Health.StatusCode, s.Health.Latency, s.Health.Body, s.Health.Err)
+	fmt.Printf("[vpnrd] health: ok=%v status=%d latency=%s body=%q err=%q remote=%s\n",
+		s.Health.OK, s.Health.StatusCode, s.Health.Latency, s.Health.Body, s.Health.Err, orNone(s.Health.RemoteAddr))
+	if v6 := s.Health.IPv6; v6 != nil {
+		fmt.Printf("[vpnrd] health ipv6: ok=%v status=%d latency=%s body=%q err=%q remote=%s\n",
+			v6.OK, v6.StatusCode, v6.Latency, v6.Body, v6.Err, orNone(v6.RemoteAddr))
+	}
+	if s.CaptivePortal {
+		fmt.Printf("[vpnrd] captive portal detected: %s\n", s.CaptivePortalDetail)
+	}
+	if c := s.ClashAPI; c != nil {
+		fmt.Printf("[vpnrd] clash api: connections=%d up=%d B/s down=%d B/s total up=%d down=%d bytes\n",
+			c.Connections, c.UpRate, c.DownRate, c.UploadTotal, c.DownloadTotal)
+	} else if s.ClashAPIErr != "" {
+		fmt.Printf("[vpnrd] clash api: %s\n", s.ClashAPIErr)
+	}
 
-	// Optional debug dump (full struct)
-	debugdump.Dump("status_snapshot", s)
+	if w := s.Watchdog; w != nil {
+		fmt.Printf("[vpnrd] watchdog: wan_if=%s consecutive_failures=%d recoveries=%d (%d failed in a row) history=%d/%d failed\n",
+			orNone(w.WAN), w.ConsecutiveFailures, w.Recoveries, w.RecoveryFailures, w.HistoryFailed, len(w.History))
+		if st := w.HistoryStats; st.Total > 0 {
+			fmt.Printf("[vpnrd] watchdog: success=%.1f%% latency p50=%s p95=%s (last %d checks)\n",
+				100*st.SuccessRate, st.LatencyP50.Round(time.Millisecond), st.LatencyP95.Round(time.Millisecond), st.Total)
+		}
+		if w.Paused {
+			fmt.Printf("[vpnrd] watchdog: automatic recovery PAUSED (admin API; POST /resume)\n")
+		}
+		if b := w.HealthBreaker; b != nil && b.Open {
+			fmt.Printf("[vpnrd] watchdog: health endpoint breaker OPEN (%d unreachable probes; next probe %s)\n",
+				b.Failures, b.NextProbe.Format(time.RFC3339))
+		}
+		if w.RecoveryRateLimited {
+			fmt.Printf("[vpnrd] watchdog: recovery RATE-LIMITED (max_recoveries_per_window reached)\n")
+		}
+	}
+
+	if len(s.SingBoxLogTail) > 0 {
+		fmt.Printf("[vpnrd] sing-box log (last %d lines):\n", len(s.SingBoxLogTail))
+		for _, l := range s.SingBoxLogTail {
+			fmt.Printf("    %s\n", l)
+		}
+	} else if s.SingBoxLogErr != "" {
+		fmt.Printf("[vpnrd] sing-box log: %s\n", s.SingBoxLogErr)
+	}
+
+	if s.Throughput != nil {
+		fmt.Printf("[vpnrd] throughput: ok=%v rate=%dB/s min=%dB/s bytes=%d duration=%s err=%q\n",
+			s.Throughput.OK, s.Throughput.BytesPerSec, s.Throughput.MinBPS, s.Throughput.Bytes, s.Throughput.Duration, s.Throughput.Err)
+	}
+}
 
+func cmdKillSwitchTest(cfg *config.Config, healthURL string, healthTimeout time.Duration, wanIF string) error {
+	expected := healthcheck.NewEgressResolver(cfg.ExpectedEgressDNS, cfg.ExpectedEgressDNSRefresh).
+		Expected(context.Background(), cfg.VPNServerIPs)
+	ks := killswitch.Verify(context.Background(), healthURL, healthTimeout, wanIF, expected)
+	debugdump.Dump("killswitch", ks)
+
+	fmt.Printf("[vpnrd] killswitch: default route: ok=%v egress=%q err=%q\n",
+		ks.Default.OK, healthcheck.EgressIP(ks.Default.Body), ks.Default.Err)
+	if ks.WAN != nil {
+		fmt.Printf("[vpnrd] killswitch: WAN-bound (%s via %s): ok=%v egress=%q err=%q\n",
+			ks.WANIP, wanIF, ks.WAN.OK, healthcheck.EgressIP(ks.WAN.Body), ks.WAN.Err)
+	}
+	if ks.Leak != "" {
+		fmt.Printf("[vpnrd] killswitch: *** FAILURE: %s ***\n", ks.Leak)
+		return fmt.Errorf("traffic leaks outside the tunnel: %s", ks.Leak)
+	}
+	if ks.Err != "" {
+		if !ks.OK {
+			return fmt.Errorf("%s", ks.Err)
+		}
+		fmt.Printf("[vpnrd] killswitch: warning: %s\n", ks.Err)
+	}
+	fmt.Printf("[vpnrd] killswitch: ok (no egress outside %v)\n", expected)
+	return nil
+}
+
+func cmdProfiles(cfgPath string) error {
+	dir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+	names, err := config.Profiles()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("[vpnrd] profiles in %s:\n", dir)
+	if len(names) == 0 {
+		fmt.Printf("  (none)\n")
+		return nil
+	}
+	for _, n := range names {
+		p, _ := config.ProfilePath(n)
+		mark := " "
+		if p == cfgPath {
+			mark = "*"
+		}
+		fmt.Printf("%s %s\n", mark, n)
+	}
 	return nil
 }
 
@@ -374,18 +635,54 @@ func printScriptSuccess(tag string, res *control.Result) {
 	fmt.Printf("[vpnrd] %s: ok\n", tag)
 }
 
-func formatScriptFailure(tag string, res *control.Result, err error) error {
-	// Build a rich error message that includes captured outputs.
-	if res == nil {
-		return fmt.Errorf("%s: %w", tag, err)
-	}
+// scriptRun is one script a command ran, tagged as in its output.
+type scriptRun struct {
+	tag string
+	res *control.Result
+}
 
-	msg := fmt.Sprintf("%s failed: %v (exit=%d)", tag, err, res.ExitCode)
-	if res.Stdout != "" {
-		msg += "\nstdout:\n" + res.Stdout
+// scriptJSON is a scriptRun as printed by --json.
+type scriptJSON struct {
+	Tag      string `json:"tag"`
+	OK       bool   `json:"ok"`
+	ExitCode int    `json:"exit_code"` // -1: killed at timeout, or no script involved
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	TimedOut bool   `json:"timed_out,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// printScripts prints the scripts a command ran. Without asJSON only the
+// successful ones are printed (a failure's output is in the error). With
+// asJSON each run is one JSON object per line, failed ones included; when cmd
+// failed outside any script, a last object tagged cmd carries the error.
+func printScripts(cmd string, runs []scriptRun, err error, asJSON bool) {
+	if !asJSON {
+		for _, r := range runs {
+			if r.res.Err == nil {
+				printScriptSuccess(r.tag, r.res)
+			}
+		}
+		return
 	}
-	if res.Stderr != "" {
-		msg += "\nstderr:\n" + res.Stderr
+	enc := json.NewEncoder(os.Stdout)
+	scriptFailed := false
+	for _, r := range runs {
+		out := scriptJSON{
+			Tag:      r.tag,
+			OK:       r.res.Err == nil,
+			ExitCode: r.res.ExitCode,
+			Stdout:   r.res.Stdout,
+			Stderr:   r.res.Stderr,
+			TimedOut: r.res.TimedOut,
+		}
+		if r.res.Err != nil {
+			out.Error = r.res.Err.Error()
+			scriptFailed = true
+		}
+		_ = enc.Encode(out)
+	}
+	if err != nil && !scriptFailed {
+		_ = enc.Encode(scriptJSON{Tag: cmd, ExitCode: -1, Error: err.Error()})
 	}
-	return fmt.Errorf("%s", msg)
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+)
+
+// cmdOwner prints the best guess at which process created a tun interface,
+// e.g. "vpnrd owner utun66" when several VPNs coexist.
+func cmdOwner(ctx context.Context, cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("owner", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("owner flags: %w", err)
+	}
+	name := fs.Arg(0)
+	if name != "" {
+		// Flags may follow the interface: vpnrd owner utun66 --json
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return fmt.Errorf("owner flags: %w", err)
+		}
+	}
+	if name == "" || fs.NArg() > 0 {
+		return fmt.Errorf("usage: vpnrd owner <interface> [--json]")
+	}
+
+	o, err := singboxctl.UTUNOwner(ctx, cfg, name)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		b, err := json.MarshalIndent(o, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	if o.PID == 0 {
+		fmt.Printf("%s: %s\n", o.Iface, o.Reason)
+		return nil
+	}
+	fmt.Printf("%s: pid=%d command=%q (%s)\n", o.Iface, o.PID, orNone(o.Command), o.Reason)
+	return nil
+}
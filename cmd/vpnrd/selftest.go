@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+	"github.com/revolver-sys/vpn-router-daemon/internal/router"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+	"github.com/revolver-sys/vpn-router-daemon/internal/status"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+// selftestPhase is one step of "vpnrd selftest" and how it went.
+type selftestPhase struct {
+	name string
+	took time.Duration
+	err  error
+}
+
+// selftest runs and reports the phases of one self-test.
+type selftest struct {
+	phases []selftestPhase
+}
+
+// phase runs fn as the named phase and prints its timing and outcome; detail
+// is printed either way.
+func (st *selftest) phase(name string, fn func() (string, error)) error {
+	start := time.Now()
+	detail, err := fn()
+	p := selftestPhase{name: name, took: time.Since(start), err: err}
+	st.phases = append(st.phases, p)
+	status := "ok"
+	if err != nil {
+		status = "FAIL: " + err.Error()
+	}
+	if detail != "" {
+		status += " (" + detail + ")"
+	}
+	fmt.Printf("[vpnrd] selftest: %-8s %-8s %s\n", name, p.took.Round(time.Millisecond), status)
+	return err
+}
+
+// result is the first failed phase as an error, or nil after printing PASS.
+func (st *selftest) result() error {
+	failed := slices.IndexFunc(st.phases, func(p selftestPhase) bool { return p.err != nil })
+	if failed >= 0 {
+		return fmt.Errorf("%s phase: %w", st.phases[failed].name, st.phases[failed].err)
+	}
+	fmt.Println("[vpnrd] selftest: PASS")
+	return nil
+}
+
+// cmdSelftest runs "vpnrd selftest" (up, verify, down, confirm) or
+// "vpnrd selftest recovery" (stop, recover, verify). Both need --yes.
+func cmdSelftest(cfg *config.Config, opts router.Options, args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	yes := fs.Bool("yes", false, "confirm: this disturbs the network")
+	verifyTimeout := fs.Duration("verify-timeout", 30*time.Second, "how long to wait for a healthy egress after up")
+	timeout := fs.Duration("timeout", 60*time.Second, "recovery: deadline from stopping sing-box to a healthy egress")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("selftest flags: %w", err)
+	}
+	mode := fs.Arg(0)
+	if mode != "" {
+		// Flags may follow the mode: vpnrd selftest recovery --yes
+		if err := fs.Parse(fs.Args()[1:]); err != nil {
+			return fmt.Errorf("selftest flags: %w", err)
+		}
+	}
+	switch mode {
+	case "", "cycle":
+		if !*yes {
+			return fmt.Errorf("this runs the up and down scripts for real (the network drops briefly); re-run with --yes to proceed")
+		}
+	case "recovery":
+		if !*yes {
+			return fmt.Errorf("this stops the running sing-box and recovers it (the network drops briefly); re-run with --yes to proceed")
+		}
+	default:
+		return fmt.Errorf("unknown selftest %q (want: cycle or recovery)", mode)
+	}
+	if err := requireRoot("selftest"); err != nil {
+		return err
+	}
+	if mode == "recovery" {
+		return selftestRecovery(context.Background(), cfg, opts, *timeout)
+	}
+	return selftestCycle(context.Background(), cfg, opts, *verifyTimeout)
+}
+
+// selftestCycle brings the router up, waits for a healthy egress, brings it
+// down again and checks the tunnel is really gone: a smoke test for scripts
+// and config before deploying them. Down always runs once up was attempted,
+// so the machine ends where it started. It refuses to run while a tunnel is
+// already up.
+func selftestCycle(ctx context.Context, cfg *config.Config, opts router.Options, verifyTimeout time.Duration) error {
+	if sb, _ := singboxctl.Inspect(cfg); sb != nil && sb.Running {
+		return fmt.Errorf("sing-box already running (owned pid=%d); selftest would tear it down; run \"vpnrd down\" first", sb.PID)
+	}
+	if ext, _ := singboxctl.InspectExternal(ctx, cfg); ext != nil && ext.Running {
+		return fmt.Errorf("sing-box already running (external pid=%d); selftest needs to own the tunnel", ext.PID)
+	}
+
+	var st selftest
+
+	// Baseline: the WAN egress the tunnel must replace, and restore afterwards.
+	var wanIP string
+	_ = st.phase("baseline", func() (string, error) {
+		h := healthcheck.Check(ctx, opts.HealthURL, opts.HealthTimeout)
+		wanIP = healthcheck.EgressIP(h.Body)
+		return "wan egress=" + orNone(wanIP), nil
+	})
+
+	var utun string
+	upErr := st.phase("up", func() (string, error) {
+		up, err := router.Up(ctx, cfg, opts)
+		if up != nil && up.SingBox != nil {
+			utun = up.SingBox.NewUTUN
+		}
+		if utun == "" {
+			return "", err
+		}
+		return "utun=" + utun, err
+	})
+
+	if upErr == nil {
+		_ = st.phase("verify", func() (string, error) {
+			return awaitEgress(ctx, cfg, opts, wanIP, time.Now().Add(verifyTimeout))
+		})
+	}
+
+	// Always tear down, whatever happened above.
+	downErr := st.phase("down", func() (string, error) {
+		_, err := router.Down(ctx, cfg)
+		return "", err
+	})
+
+	if downErr == nil {
+		_ = st.phase("confirm", func() (string, error) {
+			if utun != "" && !waitIfaceGone(utun, 10*time.Second) {
+				return "", fmt.Errorf("%s still exists", utun)
+			}
+			h := healthcheck.Check(ctx, opts.HealthURL, opts.HealthTimeout)
+			ip := healthcheck.EgressIP(h.Body)
+			if wanIP != "" && ip != wanIP {
+				return "egress=" + orNone(ip), fmt.Errorf("egress not back to the WAN address %s", wanIP)
+			}
+			if utun == "" {
+				return "egress=" + orNone(ip), nil
+			}
+			return fmt.Sprintf("%s gone, egress=%s", utun, orNone(ip)), nil
+		})
+	}
+
+	return st.result()
+}
+
+// selftestRecovery breaks a healthy tunnel on purpose (stops the owned
+// sing-box), runs the watchdog's recovery and waits until the egress is the
+// expected one again, all within timeout. A failure names the step that got
+// stuck; the tunnel may then be down ("vpnrd up" brings it back).
+func selftestRecovery(ctx context.Context, cfg *config.Config, opts router.Options, timeout time.Duration) error {
+	if cfg.AdoptOnly() {
+		return fmt.Errorf("sing_box_manage_mode is adopt_only; vpnrd never stops sing-box, so there is no recovery to test")
+	}
+	sb, _ := singboxctl.Inspect(cfg)
+	if sb == nil || !sb.Running || !sb.OwnedByUs {
+		return fmt.Errorf("%w: recovery selftest needs a tunnel brought up by \"vpnrd up\"", vpnerr.ErrSingBoxNotOwned)
+	}
+	if cfg.StatusFilePath != "" {
+		if s, err := status.ReadFile(cfg.StatusFilePath); err == nil && status.Fresh(s, 3*cfg.CheckInterval) {
+			return fmt.Errorf("a watchdog is running (%s is fresh) and would recover concurrently; stop it first", cfg.StatusFilePath)
+		}
+	}
+	singboxctl.ResolveTun(cfg, sb)
+
+	var st selftest
+	expected := healthcheck.NewEgressResolver(cfg.ExpectedEgressDNS, cfg.ExpectedEgressDNSRefresh).Expected(ctx, cfg.VPNServerIPs)
+	if err := st.phase("baseline", func() (string, error) {
+		h := healthcheck.CheckExpected(ctx, opts.HealthURL, opts.HealthTimeout, expected)
+		if !h.OK {
+			return "", fmt.Errorf("tunnel not healthy before the test: %s", h.Err)
+		}
+		return fmt.Sprintf("utun=%s egress=%s", orNone(sb.NewUTUN), orNone(healthcheck.EgressIP(h.Body))), nil
+	}); err != nil {
+		return st.result()
+	}
+
+	start := time.Now()
+	deadline := start.Add(timeout)
+	if err := st.phase("stop", func() (string, error) {
+		if err := singboxctl.StopIfOwned(ctx, cfg); err != nil {
+			return "", fmt.Errorf("stop sing-box pid=%d: %w", sb.PID, err)
+		}
+		return fmt.Sprintf("sing-box pid=%d stopped", sb.PID), nil
+	}); err != nil {
+		return st.result()
+	}
+
+	var utun string
+	if err := st.phase("recover", func() (string, error) {
+		rctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		sb, err := router.Recover(rctx, cfg, opts)
+		if sb != nil {
+			utun = sb.NewUTUN
+		}
+		if err != nil {
+			return stuckAt(err), err
+		}
+		return "utun=" + utun, nil
+	}); err != nil {
+		return st.result()
+	}
+
+	_ = st.phase("verify", func() (string, error) {
+		detail, err := awaitEgress(ctx, cfg, opts, "", deadline)
+		if err != nil {
+			return "stuck: health still failing through " + orNone(utun), err
+		}
+		return fmt.Sprintf("%s; restored %s after stop", detail, time.Since(start).Round(time.Millisecond)), nil
+	})
+	return st.result()
+}
+
+// stuckAt names the recovery step an error came from.
+func stuckAt(err error) string {
+	switch {
+	case errors.Is(err, singboxctl.ErrSingBoxExited):
+		return "stuck: sing-box exited on start (see singbox_log_file)"
+	case errors.Is(err, singboxctl.ErrUTUNNotCreated):
+		return "stuck: no utun appeared"
+	case errors.Is(err, singboxctl.ErrUTUNNoAddress):
+		return "stuck: utun never got an address"
+	case errors.Is(err, singboxctl.ErrNoTunnel):
+		return "stuck: no tunnel attributed to sing-box"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "stuck: out of time"
+	}
+	return "stuck: applying pf"
+}
+
+// awaitEgress polls the expected-egress check until it passes (and, with
+// wanIP set, reports something other than the WAN address) or deadline.
+func awaitEgress(ctx context.Context, cfg *config.Config, opts router.Options, wanIP string, deadline time.Time) (string, error) {
+	expected := healthcheck.NewEgressResolver(cfg.ExpectedEgressDNS, cfg.ExpectedEgressDNSRefresh).Expected(ctx, cfg.VPNServerIPs)
+	for {
+		h := healthcheck.CheckExpected(ctx, opts.HealthURL, opts.HealthTimeout, expected)
+		ip := healthcheck.EgressIP(h.Body)
+		if h.OK && (ip == "" || ip != wanIP) {
+			return "egress=" + orNone(ip), nil
+		}
+		if time.Now().After(deadline) {
+			if h.OK {
+				return "egress=" + ip, fmt.Errorf("egress is still the WAN address")
+			}
+			return "", vpnerr.Class(vpnerr.ErrHealthFailed, errors.New(h.Err))
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// waitIfaceGone reports whether name disappears within timeout.
+func waitIfaceGone(name string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if _, err := net.InterfaceByName(name); err != nil {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+)
+
+// cmdShowCmd prints the exact command vpnrd starts sing-box with, so what runs
+// as root can be reviewed without reading the code.
+func cmdShowCmd(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("show-cmd", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("show-cmd flags: %w", err)
+	}
+
+	plan := singboxctl.PlannedStart(cfg)
+	if *asJSON {
+		b, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+	fmt.Println(plan)
+	switch {
+	case cfg.AdoptOnly():
+		fmt.Println("# not used: sing_box_manage_mode is adopt_only (vpnrd never starts sing-box)")
+	case !cfg.SingBoxAutoStart:
+		fmt.Println("# not used by up: singbox_auto_start is off")
+	}
+	return nil
+}
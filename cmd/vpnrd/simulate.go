@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/admin"
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+)
+
+// simulateScenarios are the faults "vpnrd simulate" can inject.
+var simulateScenarios = map[string]string{
+	"tunnel-down": "stop the owned sing-box; the running watchdog must notice and recover",
+	"health-fail": "make the running watchdog's next failure_threshold checks fail",
+	"slow-health": "delay the running watchdog's next failure_threshold checks by health_timeout/2",
+}
+
+// cmdSimulate rehearses an outage against the live setup. It is deliberately
+// left out of the command list and refuses to act without --yes.
+func cmdSimulate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	yes := fs.Bool("yes", false, "confirm: this disturbs the running tunnel")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("simulate flags: %w", err)
+	}
+	scenario := fs.Arg(0)
+	if _, ok := simulateScenarios[scenario]; !ok {
+		fmt.Println("usage: vpnrd simulate <scenario> --yes")
+		for _, name := range []string{"tunnel-down", "health-fail", "slow-health"} {
+			fmt.Printf("  %-12s %s\n", name, simulateScenarios[name])
+		}
+		return fmt.Errorf("unknown scenario %q", scenario)
+	}
+	// Flags may follow the scenario: vpnrd simulate health-fail --yes
+	if err := fs.Parse(fs.Args()[1:]); err != nil {
+		return fmt.Errorf("simulate flags: %w", err)
+	}
+	if !*yes {
+		return fmt.Errorf("simulate %s: %s; re-run with --yes to proceed", scenario, simulateScenarios[scenario])
+	}
+
+	ctx := context.Background()
+	if scenario == "tunnel-down" {
+		if err := requireRoot("simulate tunnel-down"); err != nil {
+			return err
+		}
+		if cfg.AdoptOnly() {
+			return fmt.Errorf("simulate tunnel-down: sing-box is not owned by vpnrd (sing_box_manage_mode: adopt_only)")
+		}
+		if err := singboxctl.StopOwned(ctx, cfg, cfg.SingBoxStopTimeout); err != nil {
+			return fmt.Errorf("simulate tunnel-down: %w", err)
+		}
+		logx.Infof("[vpnrd] event=simulate scenario=tunnel-down owned sing-box stopped; not recovering")
+		return nil
+	}
+
+	if cfg.AdminListen == "" {
+		return fmt.Errorf("simulate %s needs the running watchdog's admin API (set admin_listen/admin_token)", scenario)
+	}
+	reply, err := admin.Post(ctx, cfg.AdminListen, cfg.AdminToken, admin.Action("simulate/"+scenario))
+	if err != nil {
+		return fmt.Errorf("simulate %s: %w", scenario, err)
+	}
+	fmt.Printf("[vpnrd] simulate: %s\n", reply)
+	return nil
+}
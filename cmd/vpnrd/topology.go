@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/router"
+)
+
+// cmdTopology prints the interfaces, tunnel and default route pf would be
+// applied with, plus the pf_apply arguments, without changing anything.
+func cmdTopology(ctx context.Context, cfg *config.Config, opts router.Options, args []string) error {
+	fs := flag.NewFlagSet("topology", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	asJSON := fs.Bool("json", false, "print as JSON")
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("topology flags: %w", err)
+	}
+
+	t := router.Topology(ctx, cfg, opts)
+	if *asJSON {
+		b, err := json.MarshalIndent(t, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		return nil
+	}
+
+	printIface := func(label string, ifc router.Interface) {
+		fmt.Printf("%-14s %s", label+":", orNone(ifc.Name))
+		if len(ifc.Addrs) > 0 {
+			fmt.Printf("  %s", strings.Join(ifc.Addrs, ", "))
+		}
+		if ifc.Gateway != "" {
+			fmt.Printf("  gw %s", ifc.Gateway)
+		}
+		if ifc.Err != "" {
+			fmt.Printf("  (%s)", ifc.Err)
+		}
+		fmt.Println()
+	}
+	printIface("WAN", t.WAN)
+	printIface("LAN", t.LAN)
+	if t.LANCIDR != "" {
+		fmt.Printf("%-14s %s\n", "LAN CIDR:", t.LANCIDR)
+	}
+	printIface("Tunnel", t.Tunnel)
+	if t.SingBoxPID > 0 {
+		fmt.Printf("%-14s pid=%d owned=%t\n", "sing-box:", t.SingBoxPID, t.SingBoxOwned)
+	}
+	if t.DefaultRoute != nil {
+		fmt.Printf("%-14s dev %s via %s\n", "Default route:", t.DefaultRoute.Interface, orNone(t.DefaultRoute.Gateway))
+	} else {
+		fmt.Printf("%-14s none (%s)\n", "Default route:", t.RouteErr)
+	}
+	switch {
+	case t.PFAnchor != "":
+		fmt.Printf("%-14s anchor %s (pf_managed)\n", "pf:", t.PFAnchor)
+	case t.PFApplyArgs != nil:
+		fmt.Printf("%-14s %s %s\n", "pf_apply:", cfg.VPNRouterPFApplyPath, strings.Join(t.PFApplyArgs, " "))
+	default:
+		fmt.Printf("%-14s not applicable until a tunnel is up\n", "pf_apply:")
+	}
+	return nil
+}
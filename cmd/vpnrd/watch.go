@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+	"github.com/revolver-sys/vpn-router-daemon/internal/status"
+)
+
+// ANSI: cursor home + clear screen.
+const clearScreen = "\033[H\033[2J"
+
+// cmdStatusWatch re-renders the status every interval until Ctrl-C / SIGTERM.
+// It skips the throughput probe and checks the egress IP against vpn_server_ips
+// (plus expected_egress_dns).
+func cmdStatusWatch(cfg *config.Config, cfgPath string, healthTimeout, interval time.Duration) error {
+	if interval < 500*time.Millisecond {
+		return fmt.Errorf("--interval must be >= 500ms (got %s)", interval)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	egress := healthcheck.NewEgressResolver(cfg.ExpectedEgressDNS, cfg.ExpectedEgressDNSRefresh)
+	lastEgress := ""
+	for {
+		h := healthcheck.CheckExpected(ctx, cfg.HealthCheckURL, healthTimeout, egress.Expected(ctx, cfg.VPNServerIPs))
+		if ctx.Err() != nil {
+			fmt.Println()
+			return nil
+		}
+		s := status.CollectWithHealth(ctx, cfg, cfgPath, h)
+
+		egress := healthcheck.EgressIP(h.Body)
+		changed := ""
+		if lastEgress != "" && egress != lastEgress {
+			changed = fmt.Sprintf(" (changed from %s)", lastEgress)
+		}
+		if egress != "" {
+			lastEgress = egress
+		}
+
+		fmt.Print(clearScreen)
+		fmt.Printf("[vpnrd] watching every %s (Ctrl-C to exit)\n", interval)
+		fmt.Printf("[vpnrd] egress: %s%s\n", egress, changed)
+		printStatus(s)
+
+		select {
+		case <-ctx.Done():
+			fmt.Println()
+			return nil
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package admin serves the watchdog's optional HTTP control API:
+//
+//	GET  /status   live snapshot (JSON)
+//	GET  /metrics  Prometheus text format (same gauges as metrics_textfile)
+//	POST /recover  run a recovery now
+//	POST /restart  restart the owned sing-box and re-apply pf
+//	POST /pause    suspend automatic recovery
+//	POST /resume   re-enable automatic recovery
+//	POST /simulate/health-fail  fail the next failure_threshold checks ("vpnrd simulate")
+//	POST /simulate/slow-health  delay the next check by half of health_timeout
+//
+// Every request needs "Authorization: Bearer <admin_token>".
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/metrics"
+	"github.com/revolver-sys/vpn-router-daemon/internal/status"
+)
+
+// Action is a control request handed to the watchdog loop, which alone
+// mutates tunnel state.
+type Action string
+
+const (
+	Recover Action = "recover"
+	Restart Action = "restart"
+	Pause   Action = "pause"
+	Resume  Action = "resume"
+
+	SimulateHealthFail Action = "simulate/health-fail"
+	SimulateSlowHealth Action = "simulate/slow-health"
+)
+
+// Server answers API requests from Live and forwards actions to Actions.
+type Server struct {
+	Token   string
+	Live    *status.LiveState
+	Actions chan<- Action
+}
+
+// Handler returns the API's routes.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", s.status)
+	mux.HandleFunc("GET /metrics", s.metrics)
+	for _, a := range []Action{Recover, Restart, Pause, Resume, SimulateHealthFail, SimulateSlowHealth} {
+		mux.HandleFunc("POST /"+string(a), s.action(a))
+	}
+	return s.auth(mux)
+}
+
+func (s *Server) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(s.Token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) status(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(s.Live.Snapshot())
+}
+
+func (s *Server) metrics(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = w.Write(metrics.Format(Gauges(s.Live.Snapshot())))
+}
+
+// action queues a for the watchdog; it runs on the loop's next turn, so the
+// reply only confirms acceptance.
+func (s *Server) action(a Action) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		select {
+		case s.Actions <- a:
+			logx.Infof("[vpnrd] event=admin_action action=%s", a)
+			w.WriteHeader(http.StatusAccepted)
+			_, _ = w.Write([]byte(string(a) + " accepted\n"))
+		default:
+			http.Error(w, "another action is pending", http.StatusConflict)
+		}
+	}
+}
+
+// Post sends action a to the API listening on listen (an admin_listen value; a
+// wildcard host is reached via loopback) and returns the reply.
+func Post(ctx context.Context, listen, token string, a Action) (string, error) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		return "", err
+	}
+	if host == "" || net.ParseIP(host) != nil && net.ParseIP(host).IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+net.JoinHostPort(host, port)+"/"+string(a), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	msg := strings.TrimSpace(string(b))
+	if resp.StatusCode != http.StatusAccepted {
+		return "", fmt.Errorf("admin API %s: %s", resp.Status, msg)
+	}
+	return msg, nil
+}
+
+// Gauges derives the exported metrics from a snapshot.
+func Gauges(s status.Snapshot) metrics.Gauges {
+	g := metrics.Gauges{
+		HealthOK:       s.Health.OK,
+		HealthLatency:  s.Health.Latency,
+		SingBoxRunning: (s.SingBox != nil && s.SingBox.Running) || (s.SingBoxExternal != nil && s.SingBoxExternal.Running),
+	}
+	if s.Watchdog != nil {
+		g.ConsecutiveFailures = s.Watchdog.ConsecutiveFailures
+		g.RecoveryTotal = s.Watchdog.Recoveries
+		g.HealthSuccessRatio = s.Watchdog.HistoryStats.SuccessRate
+		g.HealthLatencyP95 = s.Watchdog.HistoryStats.LatencyP95
+	}
+	if s.Throughput != nil {
+		g.HasThroughput, g.ThroughputBPS = true, s.Throughput.BytesPerSec
+	}
+	return g
+}
+
+// Serve runs the API on addr until ctx is done.
+func Serve(ctx context.Context, addr string, h http.Handler) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: h, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		<-ctx.Done()
+		sctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(sctx)
+	}()
+	logx.Infof("admin API listening on %s", ln.Addr())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+// Package clashapi reads traffic and connection stats from sing-box's Clash
+// API (experimental.clash_api.external_controller in the sing-box config).
+// They show whether anything actually flows through the tunnel, which an
+// external health probe cannot.
+package clashapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Stats is one look at the Clash API.
+type Stats struct {
+	UpRate        int64 `json:"up_rate"`   // bytes/s, from /traffic
+	DownRate      int64 `json:"down_rate"` // bytes/s, from /traffic
+	UploadTotal   int64 `json:"upload_total"`
+	DownloadTotal int64 `json:"download_total"`
+	Connections   int   `json:"connections"` // active, from /connections
+}
+
+// Total is upload plus download since sing-box started.
+func (s Stats) Total() int64 {
+	return s.UploadTotal + s.DownloadTotal
+}
+
+// Client queries one Clash API endpoint.
+type Client struct {
+	Addr    string // host:port or http://host:port (clash_api_addr)
+	Secret  string // sent as a bearer token (clash_api_secret); "" = none
+	Timeout time.Duration
+}
+
+// Stats reads /connections (totals, active connections) and the first sample
+// of the /traffic stream (current rates).
+func (c Client) Stats(ctx context.Context) (Stats, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var st Stats
+	var conns struct {
+		UploadTotal   int64             `json:"uploadTotal"`
+		DownloadTotal int64             `json:"downloadTotal"`
+		Connections   []json.RawMessage `json:"connections"`
+	}
+	if err := c.get(ctx, "/connections", &conns); err != nil {
+		return st, err
+	}
+	st.UploadTotal, st.DownloadTotal, st.Connections = conns.UploadTotal, conns.DownloadTotal, len(conns.Connections)
+
+	var traffic struct {
+		Up   int64 `json:"up"`
+		Down int64 `json:"down"`
+	}
+	if err := c.get(ctx, "/traffic", &traffic); err != nil {
+		return st, err
+	}
+	st.UpRate, st.DownRate = traffic.Up, traffic.Down
+	return st, nil
+}
+
+// get decodes the first JSON line of path; /traffic streams one per second.
+func (c Client) get(ctx context.Context, path string, v any) error {
+	base := c.Addr
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(base, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("clash api: %w", err)
+	}
+	if c.Secret != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Secret)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("clash api %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("clash api %s: unauthorized (check clash_api_secret)", path)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("clash api %s: status %d", path, resp.StatusCode)
+	}
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return fmt.Errorf("clash api %s: %w", path, err)
+		}
+		return fmt.Errorf("clash api %s: empty response", path)
+	}
+	if err := json.Unmarshal(sc.Bytes(), v); err != nil {
+		return fmt.Errorf("clash api %s: %w", path, err)
+	}
+	return nil
+}
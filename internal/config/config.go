@@ -1,52 +1,247 @@
 package config
 
 import (
-	"errors"
 	"fmt"
+	"mime"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
-	"gopkg.in/yaml.v3"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/pf"
+	"github.com/revolver-sys/vpn-router-daemon/internal/utun"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
 )
 
 type Config struct {
+	// Path is the file this config was read from (set by Parse/Load).
+	Path string `yaml:"-"`
+
 	// Interfaces (optional; scripts can still have defaults)
 	WANIF string `yaml:"wan_if"`
 	LANIF string `yaml:"lan_if"`
+	// Use the default route's interface as WAN; wan_if is the fallback when detection fails.
+	WANAutoDetect bool `yaml:"wan_auto_detect"`
 
 	// VPNRouterUpPath   string `yaml:"vpn_router_up_path"`
 	VPNRouterDownPath string `yaml:"vpn_router_down_path"`
 	// Router scripts (new split)
 	VPNRouterSetupPath   string `yaml:"vpn_router_setup_path"`
 	VPNRouterPFApplyPath string `yaml:"vpn_router_pf_apply_path"`
+	// use_sudo: run the router scripts and pfctl via "sudo -n" (a NOPASSWD
+	// sudoers entry is required) so vpnrd itself need not run as root.
+	UseSudo  bool   `yaml:"use_sudo"`
+	SudoPath string `yaml:"sudo_path"` // default /usr/bin/sudo
+
+	// pf_managed: vpnrd loads the NAT/kill-switch rules into pf_anchor itself
+	// (see internal/pf) instead of running vpn_router_pf_apply_path.
+	PFManaged       bool   `yaml:"pf_managed"`
+	PFAnchor        string `yaml:"pf_anchor"`         // default "vpnrd"; /etc/pf.conf must reference it
+	PFRulesTemplate string `yaml:"pf_rules_template"` // text/template file; empty = built-in rules
+	LANCIDR         string `yaml:"lan_cidr"`          // LAN subnet NATed into the tunnel (default 192.168.50.0/24)
 
-	HealthCheckURL string        `yaml:"health_check_url"`
-	CheckInterval  time.Duration `yaml:"check_interval"`
-	CommandTimeout time.Duration `yaml:"command_timeout"`
+	HealthCheckURL          string        `yaml:"health_check_url"`
+	HealthFollowRedirects   bool          `yaml:"health_follow_redirects"`    // default false: a redirect fails the check
+	CaptivePortalURL        string        `yaml:"captive_portal_url"`         // must return 204; empty = built-in default
+	HealthCheckProxy        string        `yaml:"health_check_proxy"`         // socks5:// or http:// proxy for the health probe; empty = direct
+	HealthCheckSource       string        `yaml:"health_check_source"`        // source IP or interface the probe binds to; empty = default route
+	HealthMinTLSVersion     string        `yaml:"min_tls_version"`            // "1.2" / "1.3"; empty = Go default
+	HealthTLSPins           []string      `yaml:"health_tls_pins"`            // "sha256/<base64 SPKI>"; empty = CA verification only
+	HealthCheckRetries      *int          `yaml:"health_check_retries"`       // extra attempts within health_timeout (default 1, 0 = none)
+	HealthMaxBody           int           `yaml:"health_max_body"`            // bytes of response body kept (default 4096)
+	HealthCheckMaxBody      int           `yaml:"health_check_max_body"`      // deprecated alias of health_max_body
+	HealthCheckFullBody     bool          `yaml:"health_check_full_body"`     // debugging: keep up to 1 MiB of the body
+	HealthExpectSubstring   string        `yaml:"health_expect_substring"`    // an HTTP body without it fails the check
+	HealthExpectContentType string        `yaml:"health_expect_content_type"` // e.g. "text/plain"; another Content-Type (or an HTML body) fails the check
+	HealthUserAgent         string        `yaml:"health_user_agent"`          // User-Agent of HTTP probes; empty = Go's default
+	HealthFreshConnection   bool          `yaml:"health_fresh_connection"`    // no keep-alive: every probe dials through the current tunnel
+	HealthExpectHeader      HeaderMatch   `yaml:"health_check_expect_header"` // an HTTP response without it (or with another value) fails the check
+	HealthIPFamily          string        `yaml:"health_check_ip_family"`     // "v4" (default), "v6" or "both": the stacks the egress check verifies
+	HealthCheckIPv6URL      string        `yaml:"health_check_ipv6_url"`      // IPv6-only echo endpoint, dialed over tcp6; empty = built-in default
+	CheckInterval           time.Duration `yaml:"check_interval"`
+	CommandTimeout          time.Duration `yaml:"command_timeout"`
+
+	// Per-script timeouts; each falls back to command_timeout when unset.
+	UpTimeout      time.Duration `yaml:"up_timeout"` // vpn_router_setup_path
+	DownTimeout    time.Duration `yaml:"down_timeout"`
+	PFApplyTimeout time.Duration `yaml:"pf_apply_timeout"`
+	// pf_apply runs retried after a timeout (pfctl can block briefly); a non-zero exit is never retried.
+	PFApplyTimeoutRetries *int `yaml:"pf_apply_timeout_retries"` // default 1, 0 = none
 
 	// sing-box control
 	SingBoxAdoptExternal *bool         `yaml:"singbox_adopt_external"`
+	SingBoxManageMode    string        `yaml:"sing_box_manage_mode"` // own | adopt_only | adopt_or_own
 	SingBoxPath          string        `yaml:"singbox_path"`
 	SingBoxConfigPath    string        `yaml:"singbox_config_path"`
 	SingBoxAutoStart     bool          `yaml:"singbox_auto_start"`
 	SingBoxAutoStop      bool          `yaml:"singbox_auto_stop"`
 	SingBoxStartTimeout  time.Duration `yaml:"singbox_start_timeout"`
-	SingBoxStopTimeout   time.Duration `yaml:"singbox_stop_timeout"`
-	SingBoxPidFile       string        `yaml:"singbox_pid_file"`
+	SingBoxStopTimeout   time.Duration `yaml:"singbox_stop_timeout"`  // grace after each stop_signals signal
+	SingBoxStopSIGKILL   *bool         `yaml:"singbox_stop_sigkill"`  // false: never escalate to SIGKILL (default true)
+	StopSignalScope      string        `yaml:"stop_signal_scope"`     // pgid (default: sing-box's process group, then the pid) or pid
+	StopSignals          []string      `yaml:"stop_signals"`          // sent in order, singbox_stop_timeout apart, before SIGKILL (default [TERM])
+	TunReadyStable       time.Duration `yaml:"tun_ready_stable"`      // utun IPv4 must hold this long before it counts as ready
+	SingBoxMaxLifetime   time.Duration `yaml:"sing_box_max_lifetime"` // restart an owned sing-box whose tunnel is older than this (0 = never)
+	MaxTunnelAge         time.Duration `yaml:"max_tunnel_age"`        // alias of sing_box_max_lifetime
+	SingBoxPidFile       string        `yaml:"singbox_pid_file"`      // must be under a runtime dir (see RuntimeDirs)
 	SingBoxLogFile       string        `yaml:"singbox_log_file"`
 
+	// Restart an owned sing-box when singbox_config_path changes (after "sing-box check" passes).
+	WatchSingBoxConfig bool `yaml:"watch_singbox_config"`
+
+	// Authoritative tunnel interface (e.g. utun99, matching sing-box interface_name):
+	// skips all utun auto-detection.
+	TunInterfaceName string `yaml:"tun_interface_name"`
+
+	// With several tun inbounds (split tunnel), wait until every one's
+	// interface_name is ready instead of any one of them.
+	TunWaitAll bool `yaml:"tun_wait_all"`
+
+	// utuns never selected as ours, e.g. ["utun5", "utun0-3"] (Tailscale, other VPNs)
+	IgnoreUTUNs []string `yaml:"ignore_utuns"`
+
+	// Failover: alternative sing-box configs (endpoints), rotated by the watchdog.
+	// vpn_server_ip_groups[i] is the expected egress / allowlist set for singbox_configs[i]
+	// (falls back to vpn_server_ips when missing).
+	SingBoxConfigs    []string   `yaml:"singbox_configs"`
+	VPNServerIPGroups [][]string `yaml:"vpn_server_ip_groups"`
+	FailoverAfter     int        `yaml:"failover_after"` // consecutive failed recoveries before switching endpoint
+
 	// Watchdog
 	FailureThreshold int           `yaml:"failure_threshold"`
 	RecoverCooldown  time.Duration `yaml:"recover_cooldown"`
 	MaxRecoveries    int           `yaml:"max_recoveries"`
-	HealthTimeout    time.Duration `yaml:"health_timeout"`
+
+	// No health evaluation for startup_grace_period after "vpnrd run" starts
+	// and after each recovery; the next few checks then run every
+	// startup_check_interval instead of check_interval.
+	StartupGracePeriod   time.Duration `yaml:"startup_grace_period"`
+	StartupCheckInterval time.Duration `yaml:"startup_check_interval"`
+
+	// Give up after this many failed recoveries in a row (0 = never): log it, run
+	// the on_give_up hooks, optionally the down script, and exit non-zero.
+	MaxConsecutiveRecoveryFailures int  `yaml:"max_consecutive_recovery_failures"`
+	GiveUpDown                     bool `yaml:"give_up_down"`
+
+	// Post-recovery check right after pf_apply; a failure rolls the recovery back.
+	RecoverVerifyTimeout time.Duration `yaml:"recover_verify_timeout"` // default: health_timeout
+	RecoverVerifyDown    bool          `yaml:"recover_verify_down"`    // also run the down script on rollback
+	HealthTimeout        time.Duration `yaml:"health_timeout"`         // per-probe HTTP timeout; separate from command_timeout
+
+	// Circuit breaker for the health endpoint itself (0 = off): an unreachable
+	// health URL while the captive-portal URL answers is not a tunnel failure;
+	// after this many in a row the URL is only probed every health_breaker_interval.
+	HealthBreakerThreshold int           `yaml:"health_breaker_threshold"`
+	HealthBreakerInterval  time.Duration `yaml:"health_breaker_interval"`
+	HistorySize            int           `yaml:"history_size"` // recent health results kept in memory
+	DownOnExit             bool          `yaml:"down_on_exit"` // run "down" when the watchdog gets SIGTERM/SIGINT
+
+	// Hard ceiling on recoveries per sliding window (0 = unlimited), on top of max_recoveries.
+	MaxRecoveriesPerWindow int           `yaml:"max_recoveries_per_window"`
+	RecoveryWindow         time.Duration `yaml:"recovery_window"`
+
+	// Collapse repeated identical health-failure lines into "repeated N times" summaries.
+	LogDedup bool `yaml:"log_dedup"`
+
+	// Throughput probe (optional; disabled when throughput_check_url is empty).
+	// The URL decides the payload size, e.g. https://speed.cloudflare.com/__down?bytes=1000000
+	ThroughputCheckURL string        `yaml:"throughput_check_url"`
+	ThroughputMinBPS   int64         `yaml:"throughput_min_bps"` // bytes per second
+	ThroughputInterval time.Duration `yaml:"throughput_interval"`
+	ThroughputTimeout  time.Duration `yaml:"throughput_timeout"`
+
+	// sing-box Clash API (optional; experimental.clash_api in the sing-box
+	// config): traffic and connection stats for status, and a secondary health
+	// signal (no connections and no new traffic for clash_api_idle_window).
+	ClashAPIAddr       string         `yaml:"clash_api_addr"` // host:port of external_controller
+	ClashAPISecret     string         `yaml:"clash_api_secret"`
+	ClashAPIIdleWindow *time.Duration `yaml:"clash_api_idle_window"` // default 10m, 0 = off
+
+	// Kill-switch verification in the watchdog (optional): default-route and
+	// WAN-bound probes must not egress outside vpn_server_ips.
+	KillSwitchCheck    bool          `yaml:"killswitch_check"`
+	KillSwitchInterval time.Duration `yaml:"killswitch_interval"`
+
+	// Firewall backend read by status/doctor: "pf" or "nftables"; empty = by OS.
+	Firewall string `yaml:"firewall"`
 
 	// Kill-switch allowlists (planned)
 	VPNServerIPs []string `yaml:"vpn_server_ips"` // e.g. ["89.40.206.121"]
-	WANDNSIPs    []string `yaml:"wan_dns_ips"`    // optional
-	AllowWANNTP  bool     `yaml:"allow_wan_ntp"`  // optional
+	// Add the active sing-box config's outbound server addresses (resolved) to the
+	// vpn_server_ips passed to pf_apply, so the allowlist follows config changes.
+	VPNServerIPsFromSingBox bool `yaml:"vpn_server_ips_from_singbox"`
+
+	// Expected egress published in DNS (optional): its A/AAAA records are added to
+	// vpn_server_ips for the egress check, re-resolved every expected_egress_dns_refresh.
+	ExpectedEgressDNS        string        `yaml:"expected_egress_dns"`
+	ExpectedEgressDNSRefresh time.Duration `yaml:"expected_egress_dns_refresh"`
+	// Expected IPv6 egress (IPs or CIDRs) for the IPv6 probe; empty = any IPv6 answer passes.
+	ExpectedEgressIPv6 []string `yaml:"expected_egress_ipv6"`
+	WANDNSIPs          []string `yaml:"wan_dns_ips"`   // optional
+	AllowWANNTP        bool     `yaml:"allow_wan_ntp"` // optional
+
+	// Lifecycle hooks: shell commands run at each event (see internal/hooks)
+	Hooks Hooks `yaml:"hooks"`
+
+	// Observability (optional; empty = disabled)
+	MetricsTextfile string `yaml:"metrics_textfile"` // node_exporter textfile collector .prom path
+	StatusFilePath  string `yaml:"status_file_path"` // JSON status snapshot rewritten every check
+	VPNRDLogFile    string `yaml:"vpnrd_log_file"`   // where vpnrd's own log ends up (e.g. launchd StandardErrorPath); read by "vpnrd logs"
+
+	// Watchdog state (failure counters, recovery rate-limit window) saved to
+	// state_dir/watchdog.json every check and restored by the next "vpnrd run"
+	// unless older than state_max_age. Empty state_dir = not persisted.
+	StateDir    string        `yaml:"state_dir"`
+	StateMaxAge time.Duration `yaml:"state_max_age"`
+
+	// HTTP admin API served by "vpnrd run" (empty admin_listen = off). It can
+	// control the tunnel, so it needs admin_token and a loopback address unless
+	// admin_allow_remote is set.
+	AdminListen      string `yaml:"admin_listen"` // e.g. 127.0.0.1:8787
+	AdminToken       string `yaml:"admin_token"`
+	AdminAllowRemote bool   `yaml:"admin_allow_remote"`
+
+	// set by applyDefaults / UseEndpoint
+	baseVPNServerIPs []string
+	endpoint         int
+
+	// Debug dumps, only with --debug / VPNRD_DEBUG: JSON files in this dir; empty = no dumps
+	DebugDumpDir string `yaml:"debug_dump_dir"`
+
+	DebugDumpMax int `yaml:"debug_dump_max"` // max dump files kept in debug_dump_dir
+
+	// error, warn, info (default) or debug; --log-level overrides it.
+	LogLevel string `yaml:"log_level"`
+}
+
+// HeaderMatch is a response header a health probe must carry; an empty Value
+// accepts any value.
+type HeaderMatch struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+// Hooks are user commands (run via /bin/sh -c) for lifecycle events.
+type Hooks struct {
+	PreUp        []string `yaml:"pre_up"`
+	PostUp       []string `yaml:"post_up"`
+	PreDown      []string `yaml:"pre_down"`
+	PostDown     []string `yaml:"post_down"`
+	OnRecover    []string `yaml:"on_recover"`
+	OnHealthFail []string `yaml:"on_health_fail"`
+	OnGiveUp     []string `yaml:"on_give_up"`     // max_consecutive_recovery_failures reached
+	OnTunnelDown []string `yaml:"on_tunnel_down"` // the sing-box process went away
+	OnRecovered  []string `yaml:"on_recovered"`   // health OK again after on_health_fail
+	OnWANChanged []string `yaml:"on_wan_changed"` // wan_auto_detect followed a new default route
+
+	// Fatal makes a failing hook abort the command it belongs to (default: log and continue).
+	Fatal bool `yaml:"fatal"`
 }
 
 // defoult config.yaml path: /Users/alexgoodkarma/vpn/config/vpnrd/config.yaml
@@ -58,36 +253,189 @@ func DefaultPath() (string, error) {
 	return filepath.Join(home, "vpn", "config", "vpnrd", "config.yaml"), nil
 }
 
+// Dir returns the directory holding the default config and profiles.
+func Dir() (string, error) {
+	p, err := DefaultPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Dir(p), nil
+}
+
+// ProfilePath resolves a profile name to <config dir>/<name>.yaml.
+func ProfilePath(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid profile name %q", name)
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strings.TrimSuffix(name, ".yaml")+".yaml"), nil
+}
+
+// Profiles lists profile names (*.yaml in the config dir, without extension), sorted.
+func Profiles() ([]string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, strings.TrimSuffix(filepath.Base(m), ".yaml"))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
 func Load(path string) (*Config, error) {
-	b, err := os.ReadFile(path)
+	c, err := Parse(path)
 	if err != nil {
-		return nil, fmt.Errorf("read config %q: %w", path, err)
+		return nil, err
 	}
 
-	var c Config
-	if err := yaml.Unmarshal(b, &c); err != nil {
-		return nil, fmt.Errorf("parse yaml %q: %w", path, err)
+	if err := validate(c); err != nil {
+		return nil, err
 	}
 
-	applyDefaults(&c)
+	return c, nil
+}
 
-	if err := validate(&c); err != nil {
+// Parse reads the config file (and the files it includes, see decodeFile)
+// and applies defaults without validating it.
+// Used by diagnostics that want to keep going on an invalid config.
+func Parse(path string) (*Config, error) {
+	var c Config
+	if err := decodeFile(path, &c, nil); err != nil {
 		return nil, err
 	}
 
+	c.Path = path
+	expandPaths(&c)
+	applyDefaults(&c)
+
 	return &c, nil
 }
 
+// expandPaths expands $VAR/${VAR} and a leading ~/ in the path settings:
+// the router scripts, singbox_path, singbox_config_path, singbox_configs,
+// singbox_pid_file, singbox_log_file, status_file_path, vpnrd_log_file,
+// metrics_textfile, debug_dump_dir, state_dir and sudo_path. Under sudo, $HOME and ~ are
+// root's unless sudo preserves HOME.
+func expandPaths(c *Config) {
+	for _, p := range []*string{
+		&c.VPNRouterSetupPath, &c.VPNRouterPFApplyPath, &c.VPNRouterDownPath, &c.PFRulesTemplate,
+		&c.SingBoxPath, &c.SingBoxConfigPath, &c.SingBoxPidFile, &c.SingBoxLogFile,
+		&c.StatusFilePath, &c.VPNRDLogFile, &c.MetricsTextfile, &c.DebugDumpDir,
+		&c.StateDir, &c.SudoPath,
+	} {
+		*p = ExpandPath(*p)
+	}
+	for i := range c.SingBoxConfigs {
+		c.SingBoxConfigs[i] = ExpandPath(c.SingBoxConfigs[i])
+	}
+}
+
+// ExpandPath expands environment variables and a leading "~" (the current
+// user's home directory) in p.
+func ExpandPath(p string) string {
+	p = os.ExpandEnv(p)
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, p[1:])
+		}
+	}
+	return p
+}
+
+// RuntimeDirs are the directories singbox_pid_file may live in: ones the OS
+// clears at boot, so a pidfile there cannot name a process from before the
+// last reboot. The first is the default.
+func RuntimeDirs() []string {
+	dirs := []string{"/var/run", "/private/var/run", "/run", "/tmp", "/private/tmp"}
+	if runtime.GOOS == "linux" {
+		dirs[0], dirs[2] = dirs[2], dirs[0]
+	}
+	if d := os.Getenv("XDG_RUNTIME_DIR"); d != "" {
+		dirs = append(dirs, d)
+	}
+	return dirs
+}
+
+// DefaultSingBoxPidFile is singbox_pid_file when unset.
+func DefaultSingBoxPidFile() string {
+	return filepath.Join(RuntimeDirs()[0], "vpnrd", "singbox.pid")
+}
+
+// LegacySingBoxPidFile is where singbox_pid_file defaulted to before it had
+// to be under a runtime dir; a sing-box started then is still recorded there.
+func LegacySingBoxPidFile() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "config", "vpnrd", "singbox.pid")
+}
+
+func underRuntimeDir(path string) bool {
+	path = filepath.Clean(path)
+	for _, d := range RuntimeDirs() {
+		if strings.HasPrefix(path, filepath.Clean(d)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIPOrCIDR reports whether s is an IP address or a CIDR block.
+func isIPOrCIDR(s string) bool {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		_, _, err := net.ParseCIDR(s)
+		return err == nil
+	}
+	return net.ParseIP(s) != nil
+}
+
 func applyDefaults(c *Config) {
 	if c.HealthCheckURL == "" {
 		c.HealthCheckURL = "https://api.ipify.org?format=text"
 	}
+	if c.HealthIPFamily == "" {
+		c.HealthIPFamily = "v4"
+	}
 	if c.CheckInterval == 0 {
 		c.CheckInterval = 10 * time.Second
 	}
+	if c.StateMaxAge == 0 {
+		c.StateMaxAge = 10 * time.Minute
+	}
+	if c.HealthBreakerInterval == 0 {
+		c.HealthBreakerInterval = 60 * time.Second
+	}
+	if c.PFAnchor == "" {
+		c.PFAnchor = "vpnrd"
+	}
+	if c.LANCIDR == "" {
+		c.LANCIDR = "192.168.50.0/24"
+	}
+	if c.SudoPath == "" {
+		c.SudoPath = "/usr/bin/sudo"
+	}
 	if c.CommandTimeout == 0 {
 		c.CommandTimeout = 20 * time.Second
 	}
+	if c.UpTimeout == 0 {
+		c.UpTimeout = c.CommandTimeout
+	}
+	if c.DownTimeout == 0 {
+		c.DownTimeout = c.CommandTimeout
+	}
+	if c.PFApplyTimeout == 0 {
+		c.PFApplyTimeout = c.CommandTimeout
+	}
 
 	// sing-box defaults
 	if c.SingBoxPath == "" {
@@ -99,21 +447,57 @@ func applyDefaults(c *Config) {
 	if c.SingBoxStopTimeout == 0 {
 		c.SingBoxStopTimeout = 8 * time.Second
 	}
-	// /Users/alexgoodkarma/vpn/config/vpnrd/singbox.pid
+	if c.StopSignalScope == "" {
+		c.StopSignalScope = StopScopePGID
+	}
+	if len(c.StopSignals) == 0 {
+		c.StopSignals = []string{"TERM"}
+	}
+	if c.TunReadyStable == 0 {
+		c.TunReadyStable = 1 * time.Second
+	}
+	if c.SingBoxMaxLifetime == 0 {
+		c.SingBoxMaxLifetime = c.MaxTunnelAge
+	}
+	if c.HealthMaxBody == 0 {
+		c.HealthMaxBody = c.HealthCheckMaxBody
+	}
+	// /var/run/vpnrd/singbox.pid (cleared at boot, so a pidfile never outlives its process's boot)
 	// /Users/alexgoodkarma/vpn/config/vpnrd/singbox.log
-	if c.SingBoxPidFile == "" || c.SingBoxLogFile == "" {
+	if c.SingBoxPidFile == "" {
+		c.SingBoxPidFile = DefaultSingBoxPidFile()
+	}
+	if c.SingBoxLogFile == "" {
 		home, _ := os.UserHomeDir()
-		if c.SingBoxPidFile == "" {
-			c.SingBoxPidFile = filepath.Join(home, "config", "vpnrd", "singbox.pid")
-		}
-		if c.SingBoxLogFile == "" {
-			c.SingBoxLogFile = filepath.Join(home, "config", "vpnrd", "singbox.log")
-		}
+		c.SingBoxLogFile = filepath.Join(home, "config", "vpnrd", "singbox.log")
 	}
 	if c.SingBoxAdoptExternal == nil {
 		v := true
 		c.SingBoxAdoptExternal = &v
 	}
+	if c.SingBoxManageMode == "" {
+		// Legacy singbox_adopt_external=false meant "always start our own".
+		c.SingBoxManageMode = ManageAdoptOrOwn
+		if !*c.SingBoxAdoptExternal {
+			c.SingBoxManageMode = ManageOwn
+		}
+	}
+
+	// Failover: start on singbox_config_path if it is one of the endpoints, else the first one.
+	c.baseVPNServerIPs = c.VPNServerIPs
+	if len(c.SingBoxConfigs) > 0 {
+		idx := 0
+		for i, p := range c.SingBoxConfigs {
+			if p == c.SingBoxConfigPath {
+				idx = i
+				break
+			}
+		}
+		c.UseEndpoint(idx)
+	}
+	if c.FailoverAfter == 0 {
+		c.FailoverAfter = 2
+	}
 
 	// Watchdog
 	if c.FailureThreshold == 0 {
@@ -122,20 +506,211 @@ func applyDefaults(c *Config) {
 	if c.RecoverCooldown == 0 {
 		c.RecoverCooldown = 5 * time.Second
 	}
+	if c.StartupGracePeriod == 0 {
+		c.StartupGracePeriod = 5 * time.Second
+	}
+	if c.StartupCheckInterval == 0 {
+		c.StartupCheckInterval = min(2*time.Second, c.CheckInterval)
+	}
 	if c.MaxRecoveries == 0 {
 		c.MaxRecoveries = 5
 	}
 	if c.HealthTimeout == 0 {
-		c.HealthTimeout = 5 * time.Second
+		// Derived from the polling interval so a probe always finishes before the next tick.
+		c.HealthTimeout = c.CheckInterval / 2
+		if c.HealthTimeout > 5*time.Second {
+			c.HealthTimeout = 5 * time.Second
+		}
+	}
+
+	if c.RecoverVerifyTimeout == 0 {
+		c.RecoverVerifyTimeout = c.HealthTimeout
+	}
+	if c.HistorySize == 0 {
+		c.HistorySize = 20
+	}
+	if c.ExpectedEgressDNSRefresh == 0 {
+		c.ExpectedEgressDNSRefresh = 5 * time.Minute
+	}
+	if c.RecoveryWindow == 0 {
+		c.RecoveryWindow = 10 * time.Minute
 	}
 
+	// Throughput probe
+	if c.ThroughputInterval == 0 {
+		c.ThroughputInterval = 5 * time.Minute
+	}
+	if c.ThroughputTimeout == 0 {
+		c.ThroughputTimeout = 15 * time.Second
+	}
+
+	if c.KillSwitchInterval == 0 {
+		c.KillSwitchInterval = 5 * time.Minute
+	}
+
+	// Debug
+	if c.DebugDumpMax == 0 {
+		c.DebugDumpMax = 50
+	}
+}
+
+// checkHTTPURL returns a problem message if raw (when set) is not an absolute
+// http(s) URL with a host, e.g. a "htps://" typo.
+func checkHTTPURL(key, raw string) string {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Sprintf("%s %q: %v", key, raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Sprintf("%s %q: scheme must be http or https", key, raw)
+	}
+	if u.Host == "" {
+		return fmt.Sprintf("%s %q: missing host", key, raw)
+	}
+	return ""
+}
+
+// checkProbeURL validates health_check_url: http(s) as in checkHTTPURL, or
+// tcp://host:port, unix:///path, file:///path (see healthcheck probers).
+func checkProbeURL(raw string) string {
+	const key = "health_check_url"
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return fmt.Sprintf("%s %q: %v", key, raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return checkHTTPURL(key, raw)
+	case "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return fmt.Sprintf("%s %q: tcp needs host:port", key, raw)
+		}
+	case "unix", "file":
+		if u.Path == "" {
+			return fmt.Sprintf("%s %q: missing path", key, raw)
+		}
+	default:
+		return fmt.Sprintf("%s %q: scheme must be http, https, tcp, unix or file", key, raw)
+	}
+	return ""
 }
 
+// sing-box ownership policies (sing_box_manage_mode).
+const (
+	ManageOwn        = "own"          // never adopt; always run our own sing-box
+	ManageAdoptOnly  = "adopt_only"   // only observe an external sing-box; never start or stop one
+	ManageAdoptOrOwn = "adopt_or_own" // adopt an external sing-box, else start our own
+)
+
 func (c *Config) AdoptExternal() bool {
-	if c.SingBoxAdoptExternal == nil {
-		return true
+	return c.SingBoxManageMode != ManageOwn
+}
+
+// ClashAPIIdle is how long the Clash API may show no connections and no new
+// traffic before a check fails (0 = never).
+func (c *Config) ClashAPIIdle() time.Duration {
+	if c.ClashAPIIdleWindow == nil {
+		return 10 * time.Minute
+	}
+	return *c.ClashAPIIdleWindow
+}
+
+// PFApplyRetries is how many times a timed-out pf_apply is re-run.
+func (c *Config) PFApplyRetries() int {
+	if c.PFApplyTimeoutRetries == nil {
+		return 1
+	}
+	return *c.PFApplyTimeoutRetries
+}
+
+// HealthRetries is how many times one health probe is retried before it fails.
+func (c *Config) HealthRetries() int {
+	if c.HealthCheckRetries == nil {
+		return 1
+	}
+	return *c.HealthCheckRetries
+}
+
+// stop_signal_scope values.
+const (
+	StopScopePGID = "pgid" // sing-box's process group (helpers included), then the pid
+	StopScopePID  = "pid"  // the sing-box process only
+)
+
+// stopSignalNames are the signals stop_signals may list, without "SIG".
+var stopSignalNames = map[string]syscall.Signal{
+	"HUP": syscall.SIGHUP, "INT": syscall.SIGINT, "QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM, "USR1": syscall.SIGUSR1, "USR2": syscall.SIGUSR2,
+}
+
+// StopSignalList is stop_signals as signals ("TERM", "SIGTERM" and "term"
+// all name SIGTERM); validate rejects unknown names.
+func (c *Config) StopSignalList() []syscall.Signal {
+	var sigs []syscall.Signal
+	for _, s := range c.StopSignals {
+		if sig, ok := stopSignalNames[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+			sigs = append(sigs, sig)
+		}
+	}
+	return sigs
+}
+
+// StopSIGKILL reports whether stopping sing-box may escalate to SIGKILL.
+func (c *Config) StopSIGKILL() bool {
+	return c.SingBoxStopSIGKILL == nil || *c.SingBoxStopSIGKILL
+}
+
+// AdoptOnly reports whether vpnrd must never start or stop sing-box itself.
+func (c *Config) AdoptOnly() bool {
+	return c.SingBoxManageMode == ManageAdoptOnly
+}
+
+// SingBoxConfigPaths returns every sing-box config vpnrd may have started sing-box
+// with: the active one first, then the other singbox_configs entries. After a
+// failover the running sing-box uses one of the others.
+func (c *Config) SingBoxConfigPaths() []string {
+	paths := []string{c.SingBoxConfigPath}
+	for _, p := range c.SingBoxConfigs {
+		if p != c.SingBoxConfigPath {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// Endpoints returns how many sing-box endpoints are configured for failover (0 = failover off).
+func (c *Config) Endpoints() int {
+	return len(c.SingBoxConfigs)
+}
+
+// Endpoint returns the index of the active entry in singbox_configs.
+func (c *Config) Endpoint() int {
+	return c.endpoint
+}
+
+// UseEndpoint makes singbox_configs[i] active: it becomes SingBoxConfigPath and its
+// IP group (or the base vpn_server_ips) becomes VPNServerIPs. i wraps around.
+func (c *Config) UseEndpoint(i int) {
+	n := len(c.SingBoxConfigs)
+	if n == 0 {
+		return
 	}
-	return *c.SingBoxAdoptExternal
+	i = ((i % n) + n) % n
+	c.endpoint = i
+	c.SingBoxConfigPath = c.SingBoxConfigs[i]
+	c.VPNServerIPs = c.baseVPNServerIPs
+	if i < len(c.VPNServerIPGroups) && len(c.VPNServerIPGroups[i]) > 0 {
+		c.VPNServerIPs = c.VPNServerIPGroups[i]
+	}
+}
+
+// Validate reports all config problems in one error (nil if valid).
+func (c *Config) Validate() error {
+	return validate(c)
 }
 
 func validate(c *Config) error {
@@ -155,6 +730,17 @@ func validate(c *Config) error {
 		if c.SingBoxStartTimeout < 1*time.Second {
 			problems = append(problems, "singbox_start_timeout must be >= 1s")
 		}
+		if c.TunReadyStable < 0 || c.TunReadyStable >= c.SingBoxStartTimeout {
+			problems = append(problems, "tun_ready_stable must be >= 0 and < singbox_start_timeout")
+		}
+	}
+	if c.StopSignalScope != StopScopePGID && c.StopSignalScope != StopScopePID {
+		problems = append(problems, fmt.Sprintf("stop_signal_scope %q must be %s or %s", c.StopSignalScope, StopScopePGID, StopScopePID))
+	}
+	for _, s := range c.StopSignals {
+		if _, ok := stopSignalNames[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; !ok {
+			problems = append(problems, fmt.Sprintf("stop_signals: %q is not one of HUP, INT, QUIT, TERM, USR1, USR2 (the final KILL is singbox_stop_sigkill)", s))
+		}
 	}
 
 	// if c.VPNRouterUpPath == "" {
@@ -164,30 +750,261 @@ func validate(c *Config) error {
 	//	problems = append(problems, "vpn_router_down_path is required")
 	// }
 	// Scripts: required + must exist + must be executable
+	if c.UseSudo {
+		if err := CheckExecutable(c.SudoPath); err != nil {
+			problems = append(problems, fmt.Sprintf("sudo_path invalid (use_sudo): %v", err))
+		}
+	}
 	if strings.TrimSpace(c.VPNRouterSetupPath) == "" {
 		problems = append(problems, "vpn_router_setup_path is required")
-	} else if err := mustBeExecutableFile(c.VPNRouterSetupPath); err != nil {
+	} else if err := CheckExecutable(c.VPNRouterSetupPath); err != nil {
 		problems = append(problems, fmt.Sprintf("vpn_router_setup_path invalid: %v", err))
 	}
-	if strings.TrimSpace(c.VPNRouterPFApplyPath) == "" {
-		problems = append(problems, "vpn_router_pf_apply_path is required")
-	} else if err := mustBeExecutableFile(c.VPNRouterPFApplyPath); err != nil {
+	if c.PFManaged {
+		if c.PFAnchor == "" || strings.ContainsAny(c.PFAnchor, " \t\"") {
+			problems = append(problems, fmt.Sprintf("pf_anchor %q must be a non-empty name without spaces or quotes", c.PFAnchor))
+		}
+		if _, err := pf.ParseTemplate(c.PFRulesTemplate); err != nil {
+			problems = append(problems, err.Error())
+		}
+		if _, _, err := net.ParseCIDR(c.LANCIDR); err != nil {
+			problems = append(problems, fmt.Sprintf("lan_cidr %q must be a CIDR (e.g. 192.168.50.0/24)", c.LANCIDR))
+		}
+	} else if strings.TrimSpace(c.VPNRouterPFApplyPath) == "" {
+		problems = append(problems, "vpn_router_pf_apply_path is required (or set pf_managed: true)")
+	} else if err := CheckExecutable(c.VPNRouterPFApplyPath); err != nil {
 		problems = append(problems, fmt.Sprintf("vpn_router_pf_apply_path invalid: %v", err))
 	}
 	if c.CheckInterval < 1*time.Second {
 		problems = append(problems, "check_interval must be >= 1s")
 	}
+	if c.StartupGracePeriod < 0 {
+		problems = append(problems, "startup_grace_period must be >= 0")
+	}
+	if c.StartupCheckInterval <= 0 || c.StartupCheckInterval > c.CheckInterval {
+		problems = append(problems, "startup_check_interval must be > 0 and <= check_interval")
+	}
 	if c.CommandTimeout < 1*time.Second {
 		problems = append(problems, "command_timeout must be >= 1s")
 	}
+	if c.UpTimeout < 1*time.Second {
+		problems = append(problems, "up_timeout must be >= 1s")
+	}
+	if c.DownTimeout < 1*time.Second {
+		problems = append(problems, "down_timeout must be >= 1s")
+	}
+	if c.PFApplyTimeout < 1*time.Second {
+		problems = append(problems, "pf_apply_timeout must be >= 1s")
+	}
+	if p := checkProbeURL(c.HealthCheckURL); p != "" {
+		problems = append(problems, p)
+	}
+	for _, u := range []struct{ key, val string }{
+		{"captive_portal_url", c.CaptivePortalURL},
+		{"health_check_ipv6_url", c.HealthCheckIPv6URL},
+		{"throughput_check_url", c.ThroughputCheckURL},
+	} {
+		if p := checkHTTPURL(u.key, u.val); p != "" {
+			problems = append(problems, p)
+		}
+	}
+
+	if c.HealthTimeout < 500*time.Millisecond {
+		problems = append(problems, "health_timeout must be >= 500ms")
+	} else if c.HealthTimeout >= c.CheckInterval {
+		problems = append(problems, fmt.Sprintf("health_timeout (%s) must be < check_interval (%s)", c.HealthTimeout, c.CheckInterval))
+	}
+
+	if p := strings.TrimSpace(c.HealthCheckProxy); p != "" {
+		if u, err := url.Parse(p); err != nil || u.Host == "" ||
+			(u.Scheme != "socks5" && u.Scheme != "socks5h" && u.Scheme != "http" && u.Scheme != "https") {
+			problems = append(problems, fmt.Sprintf("health_check_proxy %q must be socks5://host:port or http://host:port", p))
+		}
+	}
+
+	if strings.TrimSpace(c.HealthCheckSource) != "" && strings.TrimSpace(c.HealthCheckProxy) != "" {
+		problems = append(problems, "health_check_source and health_check_proxy are mutually exclusive")
+	}
+
+	if strings.ContainsAny(c.HealthUserAgent, "\r\n") {
+		problems = append(problems, "health_user_agent must be a single line")
+	}
+	if h := c.HealthExpectHeader; h.Name == "" && h.Value != "" {
+		problems = append(problems, "health_check_expect_header: value needs a name")
+	} else if strings.ContainsAny(h.Name, " \t\r\n:") || strings.ContainsAny(h.Value, "\r\n") {
+		problems = append(problems, fmt.Sprintf("health_check_expect_header %q: %q is not a valid header", h.Name, h.Value))
+	}
+	switch c.HealthIPFamily {
+	case "v4", "v6", "both":
+	default:
+		problems = append(problems, fmt.Sprintf("health_check_ip_family %q must be v4, v6 or both", c.HealthIPFamily))
+	}
+	for _, e := range c.ExpectedEgressIPv6 {
+		if ip, _, _ := strings.Cut(strings.TrimSpace(e), "/"); !isIPOrCIDR(e) || net.ParseIP(ip).To4() != nil {
+			problems = append(problems, fmt.Sprintf("expected_egress_ipv6 entry %q must be an IPv6 address or CIDR", e))
+		}
+	}
+	if c.HealthExpectContentType != "" {
+		if _, _, err := mime.ParseMediaType(c.HealthExpectContentType); err != nil {
+			problems = append(problems, fmt.Sprintf("health_expect_content_type %q: %v", c.HealthExpectContentType, err))
+		}
+	}
+	switch c.HealthMinTLSVersion {
+	case "", "1.0", "1.1", "1.2", "1.3":
+	default:
+		problems = append(problems, fmt.Sprintf("min_tls_version %q must be 1.0, 1.1, 1.2 or 1.3", c.HealthMinTLSVersion))
+	}
+	for _, p := range c.HealthTLSPins {
+		if !strings.HasPrefix(p, "sha256/") {
+			problems = append(problems, fmt.Sprintf("health_tls_pins entry %q must look like sha256/<base64>", p))
+		}
+	}
+	if !underRuntimeDir(c.SingBoxPidFile) {
+		problems = append(problems, fmt.Sprintf("singbox_pid_file %q must be under a runtime directory that is cleared at boot (%s)",
+			c.SingBoxPidFile, strings.Join(RuntimeDirs(), ", ")))
+	}
+	if _, err := logx.ParseLevel(c.LogLevel); err != nil {
+		problems = append(problems, "log_level: "+err.Error())
+	}
+	if r := c.PFApplyRetries(); r < 0 || r > 5 {
+		problems = append(problems, "pf_apply_timeout_retries must be between 0 and 5")
+	}
+	if r := c.HealthRetries(); r < 0 || r > 5 {
+		problems = append(problems, "health_check_retries must be between 0 and 5")
+	}
+	if c.HealthCheckMaxBody != 0 && c.HealthCheckMaxBody != c.HealthMaxBody {
+		problems = append(problems, "health_check_max_body is a deprecated alias of health_max_body; set only one of them")
+	}
+	if c.HealthMaxBody < 0 || c.HealthMaxBody > 1<<20 {
+		problems = append(problems, "health_max_body must be between 0 and 1048576 bytes")
+	}
+
+	if c.HistorySize < 0 {
+		problems = append(problems, "history_size must be >= 0")
+	}
+	if c.HealthBreakerThreshold < 0 {
+		problems = append(problems, "health_breaker_threshold must be >= 0 (0 = off)")
+	}
+	if c.HealthBreakerThreshold > 0 && c.HealthBreakerInterval < c.CheckInterval {
+		problems = append(problems, "health_breaker_interval must be >= check_interval")
+	}
+	if c.StateMaxAge < 0 {
+		problems = append(problems, "state_max_age must be > 0")
+	}
+	if c.MaxConsecutiveRecoveryFailures < 0 {
+		problems = append(problems, "max_consecutive_recovery_failures must be >= 0 (0 = unlimited)")
+	}
+	if c.MaxRecoveriesPerWindow < 0 {
+		problems = append(problems, "max_recoveries_per_window must be >= 0")
+	}
+	if c.MaxRecoveriesPerWindow > 0 && c.RecoveryWindow < c.CheckInterval {
+		problems = append(problems, "recovery_window must be >= check_interval")
+	}
+
+	if strings.TrimSpace(c.ThroughputCheckURL) != "" {
+		if c.ThroughputMinBPS <= 0 {
+			problems = append(problems, "throughput_min_bps must be > 0 when throughput_check_url is set")
+		}
+		if c.ThroughputInterval < c.CheckInterval {
+			problems = append(problems, "throughput_interval must be >= check_interval")
+		}
+		if c.ThroughputTimeout < 1*time.Second {
+			problems = append(problems, "throughput_timeout must be >= 1s")
+		}
+	}
+	if c.ClashAPIAddr != "" {
+		if idle := c.ClashAPIIdle(); idle != 0 && idle < c.CheckInterval {
+			problems = append(problems, "clash_api_idle_window must be 0 (off) or >= check_interval")
+		}
+	}
+
+	if strings.ContainsAny(c.TunInterfaceName, " \t/") {
+		problems = append(problems, fmt.Sprintf("tun_interface_name %q is not an interface name", c.TunInterfaceName))
+	}
+	if _, err := utun.ParseIgnore(c.IgnoreUTUNs); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if c.MaxTunnelAge != 0 && c.MaxTunnelAge != c.SingBoxMaxLifetime {
+		problems = append(problems, "max_tunnel_age is an alias of sing_box_max_lifetime; set only one of them")
+	}
+	if c.SingBoxMaxLifetime != 0 && c.SingBoxMaxLifetime < 10*time.Minute {
+		problems = append(problems, "sing_box_max_lifetime (max_tunnel_age) must be 0 (off) or >= 10m")
+	}
+
+	switch c.SingBoxManageMode {
+	case ManageOwn, ManageAdoptOnly, ManageAdoptOrOwn:
+	default:
+		problems = append(problems, fmt.Sprintf("sing_box_manage_mode %q must be own, adopt_only or adopt_or_own", c.SingBoxManageMode))
+	}
+
+	switch c.Firewall {
+	case "", "pf", "nftables":
+	default:
+		problems = append(problems, fmt.Sprintf("firewall %q must be pf or nftables (empty = by OS)", c.Firewall))
+	}
+
+	if c.ExpectedEgressDNSRefresh < 0 {
+		problems = append(problems, "expected_egress_dns_refresh must be >= 0")
+	}
+
+	if c.KillSwitchCheck && len(c.VPNServerIPs) == 0 && c.ExpectedEgressDNS == "" {
+		problems = append(problems, "killswitch_check requires vpn_server_ips or expected_egress_dns (expected VPN egress)")
+	}
+
+	for i, p := range c.SingBoxConfigs {
+		if strings.TrimSpace(p) == "" {
+			problems = append(problems, fmt.Sprintf("singbox_configs[%d] is empty", i))
+		}
+	}
+	if len(c.VPNServerIPGroups) > len(c.SingBoxConfigs) {
+		problems = append(problems, "vpn_server_ip_groups has more entries than singbox_configs")
+	}
+	// A mistyped entry would never match the egress check and never open pf.
+	for _, e := range c.baseVPNServerIPs {
+		if !isIPOrCIDR(e) {
+			problems = append(problems, fmt.Sprintf("vpn_server_ips entry %q must be an IP or CIDR (IPv4 or IPv6)", e))
+		}
+	}
+	for i, g := range c.VPNServerIPGroups {
+		for _, e := range g {
+			if !isIPOrCIDR(e) {
+				problems = append(problems, fmt.Sprintf("vpn_server_ip_groups[%d] entry %q must be an IP or CIDR (IPv4 or IPv6)", i, e))
+			}
+		}
+	}
+	if c.FailoverAfter < 0 {
+		problems = append(problems, "failover_after must be >= 0")
+	}
+
+	if c.AdminListen != "" {
+		if len(c.AdminToken) < 16 {
+			problems = append(problems, "admin_token must be at least 16 characters when admin_listen is set")
+		}
+		host, _, err := net.SplitHostPort(c.AdminListen)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("admin_listen %q: %v", c.AdminListen, err))
+		} else if ip := net.ParseIP(host); !c.AdminAllowRemote && host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			problems = append(problems, fmt.Sprintf("admin_listen %q is not a loopback address (set admin_allow_remote: true to expose it)", c.AdminListen))
+		}
+	}
+
+	if c.MetricsTextfile != "" && !strings.HasSuffix(c.MetricsTextfile, ".prom") {
+		problems = append(problems, "metrics_textfile must end in .prom (node_exporter ignores other files)")
+	}
+
+	if c.DebugDumpMax < 0 {
+		problems = append(problems, "debug_dump_max must be >= 0")
+	}
 
 	if len(problems) > 0 {
-		return errors.New("config invalid: " + joinProblems(problems))
+		return fmt.Errorf("%w: %s", vpnerr.ErrConfig, joinProblems(problems))
 	}
 	return nil
 }
 
-func mustBeExecutableFile(path string) error {
+// CheckExecutable reports whether path is an existing regular file with an execute bit.
+func CheckExecutable(path string) error {
 	fi, err := os.Stat(path)
 	if err != nil {
 		return fmt.Errorf("%q not accessible: %w", path, err)
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a minimal valid config plus extra YAML into a temp dir
+// and returns its path. The router scripts are a no-op script in that dir.
+// A top-level key in extra replaces the minimal config's.
+func writeConfig(t *testing.T, extra string) string {
+	t.Helper()
+	dir := t.TempDir()
+	script := filepath.Join(dir, "noop.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, l := range []string{
+		"vpn_router_setup_path: " + script,
+		"vpn_router_pf_apply_path: " + script,
+		"vpn_router_down_path: " + script,
+		"singbox_config_path: " + filepath.Join(dir, "sb.json"),
+		"singbox_pid_file: /tmp/vpnrd-test/singbox.pid",
+		"wan_if: en0",
+		"lan_if: en8",
+	} {
+		key, _, _ := strings.Cut(l, ":")
+		if !strings.Contains("\n"+extra, "\n"+key+":") {
+			lines = append(lines, l)
+		}
+	}
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"+extra+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestScriptTimeouts(t *testing.T) {
+	tests := []struct {
+		name              string
+		yaml              string
+		up, down, pfApply time.Duration
+	}{
+		{name: "all from command_timeout", yaml: "command_timeout: 20s", up: 20 * time.Second, down: 20 * time.Second, pfApply: 20 * time.Second},
+		{name: "overrides", yaml: "command_timeout: 20s\nup_timeout: 40s\npf_apply_timeout: 2s", up: 40 * time.Second, down: 20 * time.Second, pfApply: 2 * time.Second},
+		{name: "down only, default command_timeout", yaml: "down_timeout: 90s", up: 20 * time.Second, down: 90 * time.Second, pfApply: 20 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := Load(writeConfig(t, tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.UpTimeout != tt.up || c.DownTimeout != tt.down || c.PFApplyTimeout != tt.pfApply {
+				t.Fatalf("up/down/pf_apply = %s/%s/%s, want %s/%s/%s",
+					c.UpTimeout, c.DownTimeout, c.PFApplyTimeout, tt.up, tt.down, tt.pfApply)
+			}
+		})
+	}
+}
+
+func TestScriptTimeoutValidation(t *testing.T) {
+	for _, key := range []string{"up_timeout", "down_timeout", "pf_apply_timeout", "command_timeout"} {
+		_, err := Load(writeConfig(t, key+": 500ms"))
+		if err == nil || !strings.Contains(err.Error(), key+" must be >= 1s") {
+			t.Errorf("%s: 500ms: err = %v", key, err)
+		}
+	}
+}
+
+func TestExpandPath(t *testing.T) {
+	t.Setenv("HOME", "/home/op")
+	t.Setenv("VPNRD_TEST_DIR", "/srv/vpn")
+	tests := []struct{ in, want string }{
+		{"$HOME/vpn/up.sh", "/home/op/vpn/up.sh"},
+		{"${VPNRD_TEST_DIR}/sb.json", "/srv/vpn/sb.json"},
+		{"~/vpn/up.sh", "/home/op/vpn/up.sh"},
+		{"~", "/home/op"},
+		{"~other/up.sh", "~other/up.sh"}, // only the current user's ~
+		{"/etc/vpn/~/x", "/etc/vpn/~/x"},
+		{"$VPNRD_TEST_UNSET/x", "/x"},
+		{"/usr/local/bin/sing-box", "/usr/local/bin/sing-box"},
+	}
+	for _, tt := range tests {
+		if got := ExpandPath(tt.in); got != tt.want {
+			t.Errorf("ExpandPath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLoadExpandsPaths(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("VPNRD_TEST_RUN", "/tmp/vpnrd-test")
+	script := filepath.Join(home, "up.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Load(writeConfig(t, strings.Join([]string{
+		"vpn_router_setup_path: $HOME/up.sh",
+		"vpn_router_down_path: ~/up.sh",
+		"singbox_config_path: ${HOME}/sb.json",
+		"singbox_pid_file: ${VPNRD_TEST_RUN}/singbox.pid",
+		"singbox_log_file: ~/logs/singbox.log",
+		"singbox_path: $HOME/bin/sing-box",
+	}, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct{ name, got, want string }{
+		{"vpn_router_setup_path", c.VPNRouterSetupPath, script},
+		{"vpn_router_down_path", c.VPNRouterDownPath, script},
+		{"singbox_config_path", c.SingBoxConfigPath, filepath.Join(home, "sb.json")},
+		{"singbox_pid_file", c.SingBoxPidFile, "/tmp/vpnrd-test/singbox.pid"},
+		{"singbox_log_file", c.SingBoxLogFile, filepath.Join(home, "logs", "singbox.log")},
+		{"singbox_path", c.SingBoxPath, filepath.Join(home, "bin", "sing-box")},
+	} {
+		if f.got != f.want {
+			t.Errorf("%s = %q, want %q", f.name, f.got, f.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want string // substring of the error; "" = valid
+	}{
+		{name: "minimal"},
+		{name: "missing script", yaml: "vpn_router_setup_path: /nonexistent/up.sh", want: "vpn_router_setup_path"},
+		{name: "unexpanded variable leaves a bad path", yaml: "vpn_router_setup_path: $VPNRD_TEST_UNSET/up.sh", want: "vpn_router_setup_path"},
+		{name: "pidfile outside a runtime dir", yaml: "singbox_pid_file: /etc/vpnrd/singbox.pid", want: "singbox_pid_file"},
+		{name: "pidfile via a variable", yaml: "singbox_pid_file: $VPNRD_TEST_ETC/singbox.pid", want: "singbox_pid_file"},
+		{name: "bad stop_signal_scope", yaml: "stop_signal_scope: session", want: "stop_signal_scope"},
+		{name: "max_tunnel_age too short", yaml: "max_tunnel_age: 5m", want: "must be 0 (off) or >= 10m"},
+		{name: "max_tunnel_age and sing_box_max_lifetime differ", yaml: "max_tunnel_age: 12h\nsing_box_max_lifetime: 24h", want: "set only one"},
+		{name: "health_max_body too large", yaml: "health_max_body: 2097152", want: "health_max_body must be between"},
+		{name: "health_check_max_body and health_max_body differ", yaml: "health_check_max_body: 1024\nhealth_max_body: 2048", want: "set only one"},
+	}
+	t.Setenv("VPNRD_TEST_ETC", "/etc/vpnrd")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeConfig(t, tt.yaml))
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want one mentioning %s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthMaxBody(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want int
+	}{
+		{"", 0},
+		{"health_max_body: 1024", 1024},
+		{"health_check_max_body: 2048", 2048},
+		{"health_max_body: 1024\nhealth_check_max_body: 1024", 1024},
+	}
+	for _, tt := range tests {
+		c, err := Load(writeConfig(t, tt.yaml))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.yaml, err)
+		}
+		if c.HealthMaxBody != tt.want {
+			t.Errorf("%q: health_max_body = %d, want %d", tt.yaml, c.HealthMaxBody, tt.want)
+		}
+	}
+}
+
+func TestMaxTunnelAge(t *testing.T) {
+	tests := []struct {
+		yaml string
+		want time.Duration
+	}{
+		{"", 0},
+		{"max_tunnel_age: 12h", 12 * time.Hour},
+		{"sing_box_max_lifetime: 24h", 24 * time.Hour},
+		{"max_tunnel_age: 12h\nsing_box_max_lifetime: 12h", 12 * time.Hour},
+	}
+	for _, tt := range tests {
+		c, err := Load(writeConfig(t, tt.yaml))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.yaml, err)
+		}
+		if c.SingBoxMaxLifetime != tt.want {
+			t.Errorf("%q: sing_box_max_lifetime = %s, want %s", tt.yaml, c.SingBoxMaxLifetime, tt.want)
+		}
+	}
+}
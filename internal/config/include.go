@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+// includeList is the include: value, a single path or a list of them.
+type includeList []string
+
+func (l *includeList) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind == yaml.ScalarNode {
+		*l = includeList{n.Value}
+		return nil
+	}
+	var xs []string
+	if err := n.Decode(&xs); err != nil {
+		return err
+	}
+	*l = xs
+	return nil
+}
+
+// decodeFile unmarshals path into c on top of whatever c already holds. Files
+// named by its include: key are decoded first, in order, so later files (and
+// finally path itself) override earlier ones; lists are replaced, not
+// appended. Relative includes are resolved against the including file's
+// directory. stack holds the files being decoded, to report include cycles.
+func decodeFile(path string, c *Config, stack []string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	for i, p := range stack {
+		if p == abs {
+			return vpnerr.Class(vpnerr.ErrConfig, fmt.Errorf("include cycle: %s", strings.Join(append(stack[i:], abs), " -> ")))
+		}
+	}
+	stack = append(stack, abs)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if len(stack) > 1 {
+			return vpnerr.Class(vpnerr.ErrConfig, fmt.Errorf("include %q (from %q): %w", path, stack[len(stack)-2], err))
+		}
+		return vpnerr.Class(vpnerr.ErrConfig, fmt.Errorf("read config %q: %w", path, err))
+	}
+
+	var head struct {
+		Include includeList `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(b, &head); err != nil {
+		return vpnerr.Class(vpnerr.ErrConfig, fmt.Errorf("parse yaml %q: %w", path, err))
+	}
+	for _, inc := range head.Include {
+		inc = ExpandPath(strings.TrimSpace(inc))
+		if !filepath.IsAbs(inc) {
+			inc = filepath.Join(filepath.Dir(abs), inc)
+		}
+		if err := decodeFile(inc, c, stack); err != nil {
+			return err
+		}
+	}
+
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return vpnerr.Class(vpnerr.ErrConfig, fmt.Errorf("parse yaml %q: %w", path, err))
+	}
+	return nil
+}
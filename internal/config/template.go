@@ -0,0 +1,187 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Template is the commented starter config written by "vpnrd init".
+// Values shown match applyDefaults; script paths are placeholders to edit.
+const Template = `# vpnrd configuration
+# Durations use Go syntax: 500ms, 10s, 5m.
+
+# Shared settings (optional): these files are read first, in order, and this
+# file overrides them; later files override earlier ones, lists are replaced.
+# Relative paths are relative to this file.
+# include: ["base.yaml"]
+
+# Interfaces (optional; if empty, parsed from the setup script's "WAN: x  LAN: y" line)
+# wan_if: en0
+# lan_if: en8
+# wan_auto_detect: true # WAN = default route's interface; the watchdog re-applies pf when it changes (wan_if is the fallback)
+
+# Router scripts (required; must exist and be executable)
+# Path settings (scripts, singbox_*path/_file, singbox_configs, status/log/metrics
+# files, debug_dump_dir, state_dir) expand $VAR, ${VAR} and a leading ~/.
+vpn_router_setup_path: "/path/to/vpn_router_setup.sh"
+vpn_router_pf_apply_path: "/path/to/vpn_router_pf_apply.sh"
+vpn_router_down_path: "/path/to/vpn_router_down.sh"
+# Run as a normal user: the scripts and pfctl go through "sudo -n", which needs
+# a NOPASSWD sudoers entry for them (sing-box start/stop still needs root
+# unless it is managed externally, see sing_box_manage_mode).
+# use_sudo: true
+# sudo_path: /usr/bin/sudo
+
+# Native pf management (optional): load the rules into a pf anchor instead of
+# running vpn_router_pf_apply_path; /etc/pf.conf needs nat-anchor "vpnrd" and anchor "vpnrd".
+# pf_managed: true
+# pf_anchor: vpnrd
+# pf_rules_template: "/path/to/vpnrd.pf.tmpl" # Go text/template; empty = built-in rules
+# lan_cidr: 192.168.50.0/24
+
+# Watchdog health probe
+health_check_url: "https://api.ipify.org?format=text" # or tcp://host:port, unix:///path.sock, file:///path?max_age=30s&contains=ok
+health_follow_redirects: false # a redirect (e.g. captive portal login) fails the check
+# captive_portal_url: "http://connectivitycheck.gstatic.com/generate_204" # must return 204
+# min_tls_version: "1.2" # reject older HTTPS handshakes for the health probe
+# health_tls_pins: ["sha256/..."] # SPKI pins for health_check_url; a mismatch fails the check
+# health_check_proxy: "socks5://127.0.0.1:2080" # probe through sing-box's inbound instead of the default route
+# health_check_source: "utun9" # bind the probe to this interface or source IP (pair with tun_interface_name)
+# health_check_retries: 1 # retry a failed probe after 200ms (within health_timeout); 0 = no retry
+# health_max_body: 4096 # bytes of the response body kept (longer bodies are marked truncated)
+# health_check_max_body: 4096 # deprecated name of health_max_body; set only one
+# health_check_full_body: false # debugging: keep up to 1 MiB of the body
+# health_expect_content_type: "text/plain" # other content types (and HTML bodies) fail the check
+# health_check_expect_header: { name: "X-Served-By", value: "exit-ams-1" } # missing or other value fails; value "" = any
+# health_user_agent: "" # some echo-IP services block Go's default User-Agent
+# health_fresh_connection: false # true: new connection per probe (a lingering keep-alive can hide a tunnel drop)
+# health_check_ip_family: v4 # v4, v6 or both (both must pass): dual-stack egress can leak the real IPv6 address
+# health_check_ipv6_url: "https://api6.ipify.org?format=text" # IPv6-only echo endpoint, dialed over tcp6
+# health_expect_substring: "OK" # HTTP body must contain this (a portal's 200 splash page fails); combines with vpn_server_ips
+check_interval: 10s
+health_timeout: 5s # per probe; must be < check_interval
+command_timeout: 20s # per script run
+# up_timeout: 40s # setup script; these three default to command_timeout
+# down_timeout: 20s
+# pf_apply_timeout: 10s
+# pf_apply_timeout_retries: 1 # re-run pf_apply after a timeout (never after a script error)
+failure_threshold: 3
+recover_cooldown: 5s
+max_recoveries: 5
+# startup_grace_period: 5s # no health checks this long after "vpnrd run" starts and after each recovery
+# startup_check_interval: 2s # the first few checks after the grace period run this often
+# health_breaker_threshold: 0 # N unreachable-endpoint probes (network up) open the breaker; 0 = off
+# health_breaker_interval: 60s # probe spacing while the breaker is open
+# max_consecutive_recovery_failures: 0 # give up and exit non-zero after N failed recoveries in a row (0 = never)
+# give_up_down: false # true: also run the down script when giving up (drops the kill switch!)
+# recover_verify_timeout: 5s # egress check right after pf_apply; failure rolls back (default: health_timeout)
+# recover_verify_down: false # true: rollback also runs the down script (drops the kill switch!)
+# max_recoveries_per_window: 5 # safety net: at most this many recoveries per recovery_window
+# recovery_window: 10m
+down_on_exit: false # true: stop sing-box + run the down script when "vpnrd run" is stopped
+history_size: 20 # recent health results kept for the status file / status --json
+log_dedup: false # true: log repeated identical health failures as "last message repeated N times"
+
+# sing-box control
+singbox_auto_start: false
+sing_box_manage_mode: adopt_or_own # own | adopt_only (launchd runs sing-box) | adopt_or_own
+singbox_path: "/usr/local/bin/sing-box"
+# singbox_config_path: "/usr/local/etc/sing-box/config.json"
+singbox_start_timeout: 8s
+singbox_stop_timeout: 8s # grace after each of stop_signals before SIGKILL
+# singbox_stop_sigkill: true # false: never hard-kill sing-box (stop fails instead)
+# stop_signal_scope: pgid # pgid: signal sing-box's process group (its helpers too); pid: sing-box only
+# stop_signals: [TERM] # e.g. [INT, TERM]: sent in order, singbox_stop_timeout apart, before the final KILL
+tun_ready_stable: 1s # utun IPv4 must stay unchanged this long before pf is applied
+# sing_box_max_lifetime: 24h # proactively restart an owned sing-box once its utun is this old (during a healthy check)
+# max_tunnel_age: 12h # same setting under another name; set only one
+# singbox_pid_file: /var/run/vpnrd/singbox.pid # must be under /var/run, /run or /tmp (cleared at boot)
+# singbox_log_file: ""
+watch_singbox_config: false # true: restart owned sing-box when its config changes (validated with "sing-box check")
+# tun_interface_name: utun99 # trust this interface absolutely (must match sing-box interface_name)
+# tun_wait_all: false # several tun inbounds: true waits for all of their interfaces, not just one
+# ignore_utuns: ["utun5", "utun0-3"] # never select these (Tailscale, other VPNs)
+
+# Failover between endpoints (optional)
+# singbox_configs: ["/usr/local/etc/sing-box/a.json", "/usr/local/etc/sing-box/b.json"]
+# vpn_server_ip_groups: [["203.0.113.10"], ["203.0.113.20"]]
+# failover_after: 2
+
+# firewall: pf # or nftables (Linux); empty = pick by OS. Used by status/doctor.
+
+# Kill-switch allowlists; vpn_server_ips is also the expected egress IP set.
+# Entries are IPs or CIDRs, IPv4 or IPv6: an egress inside 203.0.113.0/24 or
+# 2001:db8:42::/48 matches, for providers rotating exits within a block.
+vpn_server_ips: []
+# vpn_server_ips_from_singbox: true # also allow the sing-box config's outbound servers (domains resolved) in pf
+# expected_egress_dns: "egress.example-vpn.net" # its addresses are also accepted as VPN egress
+# expected_egress_dns_refresh: 5m
+# expected_egress_ipv6: ["2001:db8:42::/48"] # VPN egress for the IPv6 probe; empty = any IPv6 answer passes
+wan_dns_ips: []
+allow_wan_ntp: false
+
+# Kill-switch verification in the watchdog (optional; needs vpn_server_ips)
+# killswitch_check: true
+# killswitch_interval: 5m
+
+# Throughput probe (optional; run by the watchdog, or by "vpnrd status --probe")
+# throughput_check_url: "https://speed.cloudflare.com/__down?bytes=1000000"
+# throughput_min_bps: 100000
+# throughput_interval: 5m
+# throughput_timeout: 15s
+
+# sing-box Clash API (optional; needs experimental.clash_api in the sing-box config):
+# traffic/connection stats in status, and a failed check when nothing flows
+# (no connections, no new traffic) for clash_api_idle_window
+# clash_api_addr: "127.0.0.1:9090"
+# clash_api_secret: ""
+# clash_api_idle_window: 10m # 0 = stats only
+
+# Lifecycle hooks (optional): run via /bin/sh -c with VPNRD_EVENT, VPNRD_UTUN,
+# VPNRD_EGRESS_IP, VPNRD_WAN_IF, VPNRD_LAN_IF ... in the environment
+# hooks:
+#   post_up: ["dscacheutil -flushcache; killall -HUP mDNSResponder"]
+#   post_down: []
+#   on_recover: []
+#   on_health_fail: []
+#   on_give_up: [] # watchdog is about to exit (max_consecutive_recovery_failures)
+#   on_tunnel_down: [] # sing-box exited (VPNRD_SINGBOX_PID, VPNRD_OWNED)
+#   on_recovered: [] # healthy again after on_health_fail (VPNRD_FAILURES)
+#   on_wan_changed: [] # wan_auto_detect moved (VPNRD_PREVIOUS_WAN_IF, VPNRD_WAN_IF)
+#   fatal: false # true: a failing hook aborts up/down
+
+# Observability (optional)
+# metrics_textfile: "/usr/local/var/node_exporter/textfile/vpnrd.prom"
+# status_file_path: "/usr/local/var/run/vpnrd/status.json"
+# vpnrd_log_file: "/usr/local/var/log/vpnrd.log" # vpnrd's stderr (launchd StandardErrorPath); read by "vpnrd logs"
+# state_dir: "/usr/local/var/db/vpnrd" # keep watchdog counters and recovery backoff across restarts
+# state_max_age: 10m # ignore saved state older than this
+# admin_listen: "127.0.0.1:8787" # HTTP admin API: GET /status /metrics, POST /recover /restart /pause /resume
+# admin_token: "change-me-to-a-long-random-string" # sent as "Authorization: Bearer <token>"
+
+# log_level: info # error, warn, info or debug (script runs, healthy checks); --log-level overrides
+
+# Debug dumps, only with --debug or VPNRD_DEBUG=1 (written here as JSON files; empty = no dumps)
+# debug_dump_dir: ""
+# debug_dump_max: 50
+`
+
+// ErrExists is returned by WriteTemplate when the file exists and force is false.
+var ErrExists = errors.New("config file already exists")
+
+// WriteTemplate creates path's directory and writes Template to path.
+// An existing file is only replaced when force is true.
+func WriteTemplate(path string, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%q: %w (use --force to overwrite)", path, ErrExists)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create config dir: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(Template), 0o644); err != nil {
+		return fmt.Errorf("write config %q: %w", path, err)
+	}
+	return nil
+}
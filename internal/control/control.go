@@ -4,27 +4,80 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"log"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/revolver-sys/vpn-router-daemon/internal/debugdump"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
 )
 
+var dryRun bool
+
+// SetDryRun makes RunScript log what it would execute and return a synthetic success.
+func SetDryRun(v bool) { dryRun = v }
+
+// DryRun reports whether dry-run mode is on.
+func DryRun() bool { return dryRun }
+
 type Result struct {
 	ExitCode int
 	Stdout   string
 	Stderr   string
+
+	// TimedOut is set when the script was killed at its timeout (ExitCode is then -1).
+	TimedOut bool
+	// Err is the underlying run error (exit status, signal, exec failure); nil on success.
+	Err error
+}
+
+// ExitError is returned by RunScript when the command fails or times out.
+// It carries the Result so callers can use errors.As to inspect output.
+type ExitError struct {
+	Path     string
+	Timeout  time.Duration
+	TimedOut bool
+	Result   *Result
+}
+
+func (e *ExitError) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("command timed out after %s: %s", e.Timeout, e.Path)
+	}
+	return fmt.Sprintf("command failed (exit=%d): %s", e.Result.ExitCode, e.Path)
+}
+
+// Unwrap lets a timeout match vpnerr.ErrTimeout.
+func (e *ExitError) Unwrap() error {
+	if e.TimedOut {
+		return vpnerr.ErrTimeout
+	}
+	return nil
 }
 
 func RunScript(ctx context.Context, path string, timeout time.Duration, args ...string) (*Result, error) {
+	return RunScriptEnv(ctx, path, timeout, nil, args...)
+}
+
+// RunScriptEnv is RunScript with extra "KEY=value" environment entries
+// appended to vpnrd's own environment.
+func RunScriptEnv(ctx context.Context, path string, timeout time.Duration, env []string, args ...string) (*Result, error) {
 	// 'args ...string' is a slice of strings → “zero or more string arguments”
+	if dryRun {
+		logx.Infof("[dry-run] would run: %s (timeout=%s)", CommandLine(path, args...), timeout)
+		return &Result{ExitCode: 0}, nil
+	}
+
 	cctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	cmd := exec.CommandContext(cctx, path, args...)
 	// 'args...' means unpack the slice back into arguments → “expand a slice into arguments"
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
 
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -36,23 +89,27 @@ func RunScript(ctx context.Context, path string, timeout time.Duration, args ...
 		ExitCode: exitCode(err),
 		Stdout:   strings.TrimSpace(stdout.String()),
 		Stderr:   strings.TrimSpace(stderr.String()),
+		TimedOut: cctx.Err() == context.DeadlineExceeded,
+		Err:      err,
 	}
 
-	// Log everything in one place (useful for debugging).
-	// log.Printf("run %q exit=%d\nstdout:\n%s\nstderr:\n%s", path, res.ExitCode, res.Stdout, res.Stderr)
-
-	log.Printf("run %q exit=%d", path, res.ExitCode)
+	// Every run at debug; a failing one is worth a warning with its output.
+	if err != nil {
+		logx.Warnf("run %q exit=%d timed_out=%t stderr=%q", path, res.ExitCode, res.TimedOut, res.Stderr)
+	} else {
+		logx.Debugf("run %q exit=%d", path, res.ExitCode)
+	}
 
 	if debugdump.Enabled() {
 		debugdump.Dump("script_stdout", res.Stdout)
 		debugdump.Dump("script_stderr", res.Stderr)
 	}
 
-	if cctx.Err() == context.DeadlineExceeded {
-		return res, fmt.Errorf("command timed out after %s: %s", timeout, path)
+	if res.TimedOut {
+		return res, &ExitError{Path: path, Timeout: timeout, TimedOut: true, Result: res}
 	}
 	if err != nil {
-		return res, fmt.Errorf("command failed (exit=%d): %s", res.ExitCode, path)
+		return res, &ExitError{Path: path, Timeout: timeout, Result: res}
 	}
 	return res, nil
 }
@@ -67,3 +124,20 @@ func exitCode(err error) int {
 	// For errors like "file not found", "permission denied", etc.
 	return -1
 }
+
+// CommandLine renders path + args as a shell-like, quoted command line for logs.
+func CommandLine(path string, args ...string) string {
+	parts := make([]string, 0, len(args)+1)
+	parts = append(parts, quoteArg(path))
+	for _, a := range args {
+		parts = append(parts, quoteArg(a))
+	}
+	return strings.Join(parts, " ")
+}
+
+func quoteArg(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`") {
+		return s
+	}
+	return fmt.Sprintf("%q", s)
+}
@@ -0,0 +1,82 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+// script writes an executable shell script with body to a temp dir.
+func script(t *testing.T, body string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(p, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRunScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		timeout  time.Duration
+		exitCode int
+		timedOut bool
+		wantErr  bool
+	}{
+		{name: "success", path: script(t, "echo ok"), timeout: 5 * time.Second},
+		{name: "failure", path: script(t, "echo boom >&2; exit 3"), timeout: 5 * time.Second, exitCode: 3, wantErr: true},
+		// exec: a plain child of sh would keep the output pipes open past the kill.
+		{name: "timeout", path: script(t, "exec sleep 30"), timeout: 200 * time.Millisecond, exitCode: -1, timedOut: true, wantErr: true},
+		{name: "not executable", path: filepath.Join(t.TempDir(), "missing.sh"), timeout: 5 * time.Second, exitCode: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start := time.Now()
+			res, err := RunScript(context.Background(), tt.path, tt.timeout)
+			if time.Since(start) > 5*time.Second {
+				t.Fatalf("took %s", time.Since(start))
+			}
+			if res.ExitCode != tt.exitCode || res.TimedOut != tt.timedOut {
+				t.Fatalf("exit=%d timed_out=%t, want exit=%d timed_out=%t", res.ExitCode, res.TimedOut, tt.exitCode, tt.timedOut)
+			}
+			if (err != nil) != tt.wantErr || (res.Err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, Result.Err = %v, wantErr %v", err, res.Err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				return
+			}
+			var ee *ExitError
+			if !errors.As(err, &ee) || ee.Result != res || ee.TimedOut != tt.timedOut {
+				t.Fatalf("err = %#v, want an *ExitError carrying the result", err)
+			}
+			if errors.Is(err, vpnerr.ErrTimeout) != tt.timedOut {
+				t.Fatalf("errors.Is(%v, ErrTimeout) = %t", err, !tt.timedOut)
+			}
+		})
+	}
+}
+
+func TestRunScriptOutput(t *testing.T) {
+	p := script(t, `echo "  $1 $VPNRD_TEST  "; echo warn >&2; exit 1`)
+	res, _ := RunScriptEnv(context.Background(), p, 5*time.Second, []string{"VPNRD_TEST=env"}, "arg")
+	if res.Stdout != "arg env" || res.Stderr != "warn" {
+		t.Fatalf("stdout=%q stderr=%q", res.Stdout, res.Stderr)
+	}
+}
+
+func TestRunScriptDryRun(t *testing.T) {
+	SetDryRun(true)
+	t.Cleanup(func() { SetDryRun(false) })
+	p := script(t, "exit 7")
+	res, err := RunScript(context.Background(), p, time.Second)
+	if err != nil || res.ExitCode != 0 {
+		t.Fatalf("dry run: exit=%d err=%v, want a synthetic success", res.ExitCode, err)
+	}
+}
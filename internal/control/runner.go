@@ -0,0 +1,39 @@
+package control
+
+import (
+	"context"
+	"os/exec"
+)
+
+// Runner runs an external command and returns its stdout. On failure the
+// error is the command's (an *exec.ExitError carries stderr in Stderr).
+type Runner interface {
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// ExecRunner runs commands on the host via exec.CommandContext.
+type ExecRunner struct{}
+
+func (ExecRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).Output()
+}
+
+var runner Runner = ExecRunner{}
+
+// SetRunner replaces the Runner behind Output (tests stub pgrep, ps, route,
+// pfctl, ... with it) and returns the previous one. A nil r restores ExecRunner.
+func SetRunner(r Runner) Runner {
+	prev := runner
+	if r == nil {
+		r = ExecRunner{}
+	}
+	runner = r
+	return prev
+}
+
+// Output runs a read-only query command (pgrep, ps, route, pfctl -s, ...)
+// through the current Runner. Scripts and commands that change the system go
+// through RunScript or exec directly.
+func Output(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return runner.Run(ctx, name, args...)
+}
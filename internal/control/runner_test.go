@@ -0,0 +1,33 @@
+package control
+
+import (
+	"context"
+	"testing"
+)
+
+type stubRunner struct{ out string }
+
+func (s stubRunner) Run(context.Context, string, ...string) ([]byte, error) {
+	return []byte(s.out), nil
+}
+
+func TestSetRunner(t *testing.T) {
+	prev := SetRunner(stubRunner{out: "stubbed"})
+	t.Cleanup(func() { SetRunner(prev) })
+	if _, ok := prev.(ExecRunner); !ok {
+		t.Fatalf("default runner = %T, want ExecRunner", prev)
+	}
+	if out, err := Output(context.Background(), "pgrep", "sing-box"); err != nil || string(out) != "stubbed" {
+		t.Fatalf("Output = %q, %v; want the stub's", out, err)
+	}
+
+	if got := SetRunner(nil); got == nil {
+		t.Fatal("SetRunner returned nil for the stub")
+	}
+	if _, ok := runner.(ExecRunner); !ok {
+		t.Fatalf("after SetRunner(nil) runner = %T, want ExecRunner", runner)
+	}
+	if out, err := Output(context.Background(), "echo", "real"); err != nil || string(out) != "real\n" {
+		t.Fatalf("Output after reset = %q, %v", out, err)
+	}
+}
@@ -0,0 +1,52 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+var sudoPath string
+
+// SetSudo makes privileged commands (RunPrivileged, Privileged) run via
+// "sudo -n" at path (use_sudo); "" runs them directly.
+func SetSudo(path string) { sudoPath = path }
+
+// Sudo reports whether privileged commands go through sudo.
+func Sudo() bool { return sudoPath != "" }
+
+// Privileged returns the command that runs name with args as root: unchanged,
+// or wrapped in "sudo -n --" after SetSudo.
+func Privileged(name string, args ...string) (string, []string) {
+	if sudoPath == "" {
+		return name, args
+	}
+	return sudoPath, append([]string{"-n", "--", name}, args...)
+}
+
+// RunPrivileged is RunScript for scripts that need root (the router scripts).
+// Under sudo, -n makes sudo fail instead of prompting; that failure is
+// returned as ErrPermission naming the missing NOPASSWD sudoers entry.
+func RunPrivileged(ctx context.Context, path string, timeout time.Duration, args ...string) (*Result, error) {
+	name, args := Privileged(path, args...)
+	res, err := RunScript(ctx, name, timeout, args...)
+	if err != nil && res != nil && sudoPath != "" && SudoRefused(res.Stderr) {
+		return res, vpnerr.Class(vpnerr.ErrPermission,
+			fmt.Errorf("sudo refused to run %s without a password (%s); use_sudo needs a NOPASSWD sudoers entry for it", path, res.Stderr))
+	}
+	return res, err
+}
+
+// SudoRefused reports whether sudo's stderr says it would not run the command
+// non-interactively (password or terminal required, or not permitted).
+func SudoRefused(stderr string) bool {
+	for _, s := range []string{"a password is required", "a terminal is required", "is not allowed to execute", "is not in the sudoers file"} {
+		if strings.Contains(stderr, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -4,44 +4,126 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-var enabled bool
+// DefaultMaxFiles is how many dump files are kept per directory when no cap is configured.
+const DefaultMaxFiles = 50
+
+var (
+	mu       sync.Mutex
+	enabled  bool
+	dumpDir  string
+	maxFiles = DefaultMaxFiles
+)
 
 // Enable turns on debug dumping.
-func Enable() { enabled = true }
+func Enable() {
+	mu.Lock()
+	enabled = true
+	mu.Unlock()
+}
 
 // Enabled reports whether dumps are enabled.
-func Enabled() bool { return enabled }
+func Enabled() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return enabled
+}
 
 // EnableFromEnv enables dumps if VPNRD_DEBUG is set to a non-empty value.
 func EnableFromEnv() {
 	if os.Getenv("VPNRD_DEBUG") != "" {
-		enabled = true
+		Enable()
 	}
 }
 
-// Dump prints a readable dump of any value.
-// Uses JSON when possible; falls back to type + fmt.
-func Dump(name string, v any) {
-	if !enabled {
-		return
+// Configure sets the dump directory and retention cap. Dumps need both: enabled
+// (--debug / VPNRD_DEBUG) and a non-empty dir, where they go to timestamped
+// JSON files. max <= 0 falls back to DefaultMaxFiles.
+func Configure(dir string, max int) {
+	mu.Lock()
+	defer mu.Unlock()
+	dumpDir = strings.TrimSpace(dir)
+	if max <= 0 {
+		max = DefaultMaxFiles
 	}
+	maxFiles = max
+}
+
+// Dir returns the configured dump directory ("" means dumps are off).
+func Dir() string {
+	mu.Lock()
+	defer mu.Unlock()
+	return dumpDir
+}
 
-	// Try JSON first (nice for structs, maps, slices).
-	if b, err := json.MarshalIndent(v, "", "  "); err == nil {
-		fmt.Fprintf(os.Stderr, "\n[DUMP] %s (%s)\n%s\n", name, typeOf(v), string(b))
+// Dump writes a timestamped JSON file of any value into the dump directory.
+// It is a no-op unless dumps are enabled and a directory is configured.
+func Dump(tag string, v any) {
+	mu.Lock()
+	on, dir := enabled, dumpDir
+	mu.Unlock()
+	if !on || dir == "" {
 		return
 	}
 
-	// Fallback (for channels, funcs, complex types).
-	fmt.Fprintf(os.Stderr, "\n[DUMP] %s (%s)\n%#v\n", name, typeOf(v), v)
+	if _, err := DumpTo(dir, tag, v); err != nil {
+		fmt.Fprintf(os.Stderr, "\n[DUMP] %s: write to %s failed: %v\n", tag, dir, err)
+	}
 }
 
-// DumpJSON forces JSON dump (and reports marshal error if it fails).
+// DumpTo writes v as indented JSON into dir/<timestamp>_<tag>.json and prunes
+// the oldest dump files so at most the configured cap remain.
+// It returns the path of the written file. Unlike Dump it ignores the enabled flag.
+func DumpTo(dir, tag string, v any) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create dump dir %q: %w", dir, err)
+	}
+
+	doc := struct {
+		Tag   string `json:"tag"`
+		Time  string `json:"time"`
+		Type  string `json:"type"`
+		Value any    `json:"value"`
+	}{
+		Tag:   tag,
+		Time:  time.Now().UTC().Format(time.RFC3339Nano),
+		Type:  typeOf(v),
+		Value: v,
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		// Keep something useful even for values JSON can't represent.
+		doc.Value = fmt.Sprintf("%#v", v)
+		if b, err = json.MarshalIndent(doc, "", "  "); err != nil {
+			return "", fmt.Errorf("marshal dump %q: %w", tag, err)
+		}
+	}
+
+	name := fmt.Sprintf("%s_%s.json", time.Now().UTC().Format(nameTimeLayout), safeTag(tag))
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return "", fmt.Errorf("write dump %q: %w", path, err)
+	}
+
+	mu.Lock()
+	max := maxFiles
+	mu.Unlock()
+	prune(dir, max)
+
+	return path, nil
+}
+
+// DumpJSON is Dump that reports a JSON marshal error instead of falling back to %#v.
 func DumpJSON(name string, v any) {
-	if !enabled {
+	if !Enabled() || Dir() == "" {
 		return
 	}
 	b, err := json.MarshalIndent(v, "", "  ")
@@ -49,7 +131,47 @@ func DumpJSON(name string, v any) {
 		fmt.Fprintf(os.Stderr, "\n[DUMP] %s (%s) json marshal error: %v\n", name, typeOf(v), err)
 		return
 	}
-	fmt.Fprintf(os.Stderr, "\n[DUMP] %s (%s)\n%s\n", name, typeOf(v), string(b))
+	Dump(name, json.RawMessage(b))
+}
+
+// nameTimeLayout starts every dump file name; prune only touches names made with it.
+const nameTimeLayout = "20060102T150405.000000000Z"
+
+var (
+	reUnsafe   = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+	reDumpName = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}Z_[A-Za-z0-9._-]+\.json$`)
+)
+
+func safeTag(tag string) string {
+	s := reUnsafe.ReplaceAllString(tag, "_")
+	if s == "" {
+		return "dump"
+	}
+	return s
+}
+
+// prune removes the oldest dumps in dir beyond max. Only files named like
+// DumpTo's <timestamp>_<tag>.json count: the directory may hold other JSON
+// (a sing-box config, say) that must survive. The UTC timestamp prefix makes
+// lexical order chronological.
+func prune(dir string, max int) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var matches []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && reDumpName.MatchString(e.Name()) {
+			matches = append(matches, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(matches) <= max {
+		return
+	}
+	sort.Strings(matches)
+	for _, p := range matches[:len(matches)-max] {
+		_ = os.Remove(p)
+	}
 }
 
 func typeOf(v any) string {
@@ -0,0 +1,98 @@
+package debugdump
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// reset restores the package state after a test.
+func reset(t *testing.T) {
+	t.Cleanup(func() {
+		mu.Lock()
+		enabled, dumpDir, maxFiles = false, "", DefaultMaxFiles
+		mu.Unlock()
+	})
+}
+
+func dumpFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, e := range entries {
+		if reDumpName.MatchString(e.Name()) {
+			out = append(out, e.Name())
+		}
+	}
+	return out
+}
+
+func TestDumpNeedsDebug(t *testing.T) {
+	reset(t)
+	dir := t.TempDir()
+	Configure(dir, 10)
+
+	Dump("health", map[string]int{"n": 1})
+	if got := dumpFiles(t, dir); len(got) != 0 {
+		t.Fatalf("dumped without --debug: %v", got)
+	}
+
+	Enable()
+	Dump("health", map[string]int{"n": 1})
+	if got := dumpFiles(t, dir); len(got) != 1 {
+		t.Fatalf("dump files = %v, want one", got)
+	}
+}
+
+func TestDumpNeedsDir(t *testing.T) {
+	reset(t)
+	Enable()
+	Configure("", 10)
+
+	// Nothing may go to stderr either: without a dir, dumps are off.
+	f, err := os.CreateTemp(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = f
+	t.Cleanup(func() { os.Stderr = stderr })
+
+	Dump("health", map[string]int{"n": 1})
+	DumpJSON("health", map[string]int{"n": 1})
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 0 {
+		t.Fatalf("dumped %d bytes to stderr without debug_dump_dir", fi.Size())
+	}
+}
+
+func TestPruneKeepsOtherJSON(t *testing.T) {
+	reset(t)
+	dir := t.TempDir()
+	Configure(dir, 2)
+	for _, name := range []string{"config.json", "00000000_state.json"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range 5 {
+		if _, err := DumpTo(dir, "tick", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := dumpFiles(t, dir); len(got) != 2 {
+		t.Fatalf("dump files = %v, want 2 after pruning", got)
+	}
+	for _, name := range []string{"config.json", "00000000_state.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("%s was pruned: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Package firewall reads the state of the host firewall that carries the router's
+// NAT and kill-switch rules: pf on macOS, nftables on Linux. Rule changes go
+// through the pf_apply/setup/down scripts, or internal/pf with pf_managed.
+package firewall
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+)
+
+// Info is a best-effort view of the firewall.
+type Info struct {
+	Backend string `json:"backend"` // "pf" or "nftables"
+	Enabled bool   `json:"enabled"`
+	Info    string `json:"info"` // raw tool output
+	Err     string `json:"err"`
+
+	// NAT rule counters; only nftables reports them.
+	NATPackets int64 `json:"nat_packets,omitempty"`
+	NATBytes   int64 `json:"nat_bytes,omitempty"`
+}
+
+// Firewall is one backend.
+type Firewall interface {
+	Name() string
+	Binary() string // control tool looked up in PATH, e.g. "pfctl"
+	Info(ctx context.Context) Info
+}
+
+// Backend names accepted by For (config "firewall").
+const (
+	PF       = "pf"
+	NFTables = "nftables"
+)
+
+// For returns the named backend; "" picks by runtime GOOS (nftables on Linux, pf elsewhere).
+func For(name string) (Firewall, error) {
+	if name == "" {
+		name = PF
+		if runtime.GOOS == "linux" {
+			name = NFTables
+		}
+	}
+	switch name {
+	case PF:
+		return pf{}, nil
+	case NFTables:
+		return nftables{}, nil
+	}
+	return nil, fmt.Errorf("unknown firewall %q (want %s or %s)", name, PF, NFTables)
+}
+
+// run executes a root-only firewall tool; errStr is set when it can't be queried.
+func run(ctx context.Context, name string, args ...string) (out string, errStr string) {
+	// pfctl needs /dev/pf and nft needs CAP_NET_ADMIN; don't report an opaque tool error.
+	if os.Geteuid() != 0 {
+		return "", "requires root"
+	}
+
+	stdout, err := control.Output(ctx, name, args...)
+	out = strings.TrimSpace(string(stdout))
+	if err != nil {
+		var ee *exec.ExitError
+		if errors.As(err, &ee) {
+			errStr = strings.TrimSpace(string(ee.Stderr))
+		}
+		if errStr == "" {
+			errStr = err.Error()
+		}
+	}
+	return out, errStr
+}
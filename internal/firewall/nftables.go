@@ -0,0 +1,39 @@
+package firewall
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// nftables is the Linux firewall, queried with "nft list ruleset".
+type nftables struct{}
+
+func (nftables) Name() string   { return NFTables }
+func (nftables) Binary() string { return "nft" }
+
+var reNFTCounter = regexp.MustCompile(`counter packets (\d+) bytes (\d+)`)
+
+// Info reports NAT as enabled when any masquerade/snat rule is loaded and sums
+// the counters of those rules (rules without a "counter" statement add nothing).
+func (nftables) Info(ctx context.Context) Info {
+	out, errStr := run(ctx, "nft", "list", "ruleset")
+	res := Info{Backend: NFTables, Info: out, Err: errStr}
+	if errStr != "" {
+		return res
+	}
+	for _, line := range strings.Split(out, "\n") {
+		if !strings.Contains(line, "masquerade") && !strings.Contains(line, "snat") {
+			continue
+		}
+		res.Enabled = true
+		if m := reNFTCounter.FindStringSubmatch(line); m != nil {
+			p, _ := strconv.ParseInt(m[1], 10, 64)
+			b, _ := strconv.ParseInt(m[2], 10, 64)
+			res.NATPackets += p
+			res.NATBytes += b
+		}
+	}
+	return res
+}
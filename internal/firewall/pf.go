@@ -0,0 +1,23 @@
+package firewall
+
+import (
+	"context"
+	"strings"
+)
+
+// pf is the macOS packet filter, queried with "pfctl -s info".
+type pf struct{}
+
+func (pf) Name() string   { return PF }
+func (pf) Binary() string { return "pfctl" }
+
+func (pf) Info(ctx context.Context) Info {
+	out, errStr := run(ctx, "pfctl", "-s", "info")
+	res := Info{Backend: PF, Info: out, Err: errStr}
+	if errStr != "" {
+		return res
+	}
+	// "Status: Enabled" appears on macOS
+	res.Enabled = strings.Contains(out, "Status: Enabled") || strings.Contains(out, "Enabled")
+	return res
+}
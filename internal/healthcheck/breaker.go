@@ -0,0 +1,89 @@
+package healthcheck
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Breaker is a circuit breaker for the health endpoint itself. Endpoint errors
+// (the health URL unreachable while the network answers, see NetworkUp) say
+// nothing about the tunnel, so they are kept apart from tunnel failures: after
+// Threshold of them in a row the breaker opens and the endpoint is probed only
+// every Interval until it answers again. It is not safe for concurrent use.
+type Breaker struct {
+	Threshold int           // consecutive endpoint errors that open the breaker; <= 0 never opens
+	Interval  time.Duration // probe spacing while open
+
+	failures  int
+	open      bool
+	nextProbe time.Time
+}
+
+// BreakerState is a Breaker's view for status output.
+type BreakerState struct {
+	Open      bool      `json:"open"`
+	Failures  int       `json:"failures"`             // consecutive endpoint errors
+	NextProbe time.Time `json:"next_probe,omitempty"` // while open
+}
+
+// Allow reports whether the endpoint should be probed at now.
+func (b *Breaker) Allow(now time.Time) bool {
+	return !b.open || !now.Before(b.nextProbe)
+}
+
+// Record notes one probe's outcome: endpointErr for an endpoint error, false
+// for anything else (success, or a failure that is the tunnel's). It reports
+// whether the breaker changed state.
+func (b *Breaker) Record(now time.Time, endpointErr bool) bool {
+	if !endpointErr {
+		b.failures = 0
+		if b.open {
+			b.open = false
+			return true
+		}
+		return false
+	}
+	b.failures++
+	if b.open {
+		b.nextProbe = now.Add(b.Interval)
+		return false
+	}
+	if b.Threshold > 0 && b.failures >= b.Threshold {
+		b.open = true
+		b.nextProbe = now.Add(b.Interval)
+		return true
+	}
+	return false
+}
+
+// State returns the breaker's current state.
+func (b *Breaker) State() BreakerState {
+	s := BreakerState{Open: b.open, Failures: b.failures}
+	if b.open {
+		s.NextProbe = b.nextProbe
+	}
+	return s
+}
+
+// NetworkUp reports whether the captive-portal URL (Options.CaptivePortalURL)
+// answers at all, any status. A health probe that could not connect while this
+// succeeds points at the health endpoint rather than the tunnel.
+func NetworkUp(ctx context.Context, timeout time.Duration) bool {
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(cctx, http.MethodGet, captivePortalURL(), nil)
+	if err != nil {
+		return false
+	}
+	client := &http.Client{
+		Timeout:       timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
@@ -0,0 +1,79 @@
+package healthcheck
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := &Breaker{Threshold: 3, Interval: time.Minute}
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Tunnel failures and successes never open it.
+	for range 5 {
+		if b.Record(t0, false) {
+			t.Fatal("a non-endpoint result changed the state")
+		}
+	}
+	b.Record(t0, true)
+	b.Record(t0, true)
+	if b.State().Open || !b.Allow(t0) {
+		t.Fatalf("open after 2 endpoint errors: %+v", b.State())
+	}
+	if !b.Record(t0, true) {
+		t.Fatal("third endpoint error did not open the breaker")
+	}
+	if s := b.State(); !s.Open || s.Failures != 3 || !s.NextProbe.Equal(t0.Add(time.Minute)) {
+		t.Fatalf("state = %+v", s)
+	}
+
+	// Open: probes are spaced by Interval.
+	if b.Allow(t0.Add(30 * time.Second)) {
+		t.Fatal("allowed a probe before the interval")
+	}
+	t1 := t0.Add(time.Minute)
+	if !b.Allow(t1) {
+		t.Fatal("no probe after the interval")
+	}
+	if b.Record(t1, true) {
+		t.Fatal("a failed probe while open changed the state")
+	}
+	if b.Allow(t1.Add(59*time.Second)) || !b.Allow(t1.Add(time.Minute)) {
+		t.Fatalf("a failed probe did not push the next one back: %+v", b.State())
+	}
+
+	// The endpoint answers again: closed, streak reset.
+	if !b.Record(t1.Add(time.Minute), false) {
+		t.Fatal("success did not close the breaker")
+	}
+	if s := b.State(); s.Open || s.Failures != 0 || !s.NextProbe.IsZero() || !b.Allow(t1) {
+		t.Fatalf("state after success = %+v", s)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	b := &Breaker{Interval: time.Minute}
+	now := time.Now()
+	for range 100 {
+		if b.Record(now, true) || !b.Allow(now) {
+			t.Fatal("a breaker with Threshold 0 opened")
+		}
+	}
+}
+
+func TestNetworkUp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusServiceUnavailable) // any answer counts
+	}))
+	withOptions(t, Options{CaptivePortalURL: srv.URL})
+	if !NetworkUp(context.Background(), 2*time.Second) {
+		t.Fatal("NetworkUp = false with the portal URL answering")
+	}
+	srv.Close()
+	if NetworkUp(context.Background(), 2*time.Second) {
+		t.Fatal("NetworkUp = true with the portal URL down")
+	}
+}
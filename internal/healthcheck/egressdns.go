@@ -0,0 +1,80 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+)
+
+// EgressResolver builds the expected egress set from a DNS name (config
+// expected_egress_dns) for providers that rotate addresses behind a hostname.
+// Results are cached for refresh; a failed lookup keeps the last good set.
+// It is safe for concurrent use.
+type EgressResolver struct {
+	host    string
+	refresh time.Duration
+	lookup  func(ctx context.Context, host string) ([]string, error)
+
+	mu      sync.Mutex
+	ips     []string
+	fetched time.Time
+}
+
+// NewEgressResolver returns a resolver for host, re-resolved at most every refresh.
+// An empty host yields a resolver that adds nothing.
+func NewEgressResolver(host string, refresh time.Duration) *EgressResolver {
+	return &EgressResolver{host: host, refresh: refresh, lookup: net.DefaultResolver.LookupHost}
+}
+
+// Expected returns static plus the host's current addresses (deduplicated).
+func (r *EgressResolver) Expected(ctx context.Context, static []string) []string {
+	if r == nil || r.host == "" {
+		return static
+	}
+	resolved := r.resolve(ctx)
+	if len(resolved) == 0 {
+		return static
+	}
+	seen := make(map[string]bool, len(static)+len(resolved))
+	out := make([]string, 0, len(static)+len(resolved))
+	for _, ip := range append(append([]string(nil), static...), resolved...) {
+		if !seen[ip] {
+			seen[ip] = true
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+func (r *EgressResolver) resolve(ctx context.Context) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.fetched.IsZero() && time.Since(r.fetched) < r.refresh {
+		return r.ips
+	}
+
+	ips, err := r.lookup(ctx, r.host)
+	if err != nil || len(ips) == 0 {
+		if err == nil {
+			err = errNoAddrs
+		}
+		// Retry on the next call; keep validating against the last known set meanwhile.
+		logx.Warnf("expected_egress_dns %s: %v (keeping last known %v)", r.host, err, r.ips)
+		return r.ips
+	}
+	sort.Strings(ips)
+	if !slices.Equal(ips, r.ips) {
+		logx.Debugf("expected_egress_dns %s resolved to %v", r.host, ips)
+	}
+	r.ips, r.fetched = ips, time.Now()
+	return r.ips
+}
+
+var errNoAddrs = errors.New("no addresses")
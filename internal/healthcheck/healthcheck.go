@@ -2,13 +2,163 @@ package healthcheck
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	neturl "net/url"
 	"strings"
 	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+// Options are process-wide probe settings, applied to every check (see Configure).
+type Options struct {
+	// FollowRedirects follows 3xx responses (chain recorded in Result.Redirects).
+	// When false a redirect fails the check: captive portals typically 302 to a login page.
+	FollowRedirects bool
+
+	// CaptivePortalURL must answer 204 with an empty body (see DetectCaptivePortal).
+	// Empty uses DefaultCaptivePortalURL.
+	CaptivePortalURL string
+
+	// ProxyURL routes Check through a proxy (socks5://host:port or http://host:port),
+	// e.g. sing-box's mixed/socks inbound. Empty = direct via the routing table.
+	// CheckFrom, the captive-portal and throughput probes never use it.
+	ProxyURL string
+
+	// SourceAddr binds Check's HTTP probe to a local IP, or to an interface's
+	// address when it names one (e.g. the pinned utun), so the probe tests that
+	// path rather than the default route. Mutually exclusive with ProxyURL.
+	SourceAddr string
+
+	// MinTLSVersion (tls.VersionTLS12, ...) rejects older HTTPS handshakes; 0 = Go's default.
+	MinTLSVersion uint16
+	// TLSPins are "sha256/<base64>" SPKI hashes; when set, the server chain must
+	// contain one of them (a MITM'ing portal fails with ErrCertPinMismatch).
+	TLSPins []string
+
+	// Retries re-runs a failed Check up to this many times, RetryDelay apart, to ride
+	// out transient resets (e.g. tunnel renegotiation). The timeout covers all attempts.
+	Retries int
+
+	// MaxBody caps how much of an HTTP response body is read into Result.Body
+	// (0 = DefaultMaxBody). FullBody raises the cap to FullBodyMax for debugging.
+	MaxBody  int
+	FullBody bool
+
+	// ExpectSubstring, when set, must appear in an HTTP probe's body for it to be
+	// OK. A captive portal answering 200 with its splash page fails it.
+	ExpectSubstring string
+
+	// ExpectContentType, when set, is the media type (e.g. "text/plain") an HTTP
+	// probe's Content-Type must have; parameters such as charset are ignored.
+	// A non-HTML type also rejects a body that is plainly an HTML page.
+	ExpectContentType string
+
+	// ExpectHeader, when set, is a header an HTTP response must carry, with
+	// ExpectHeaderValue as its value unless that is empty. Endpoints naming the
+	// backend that answered (e.g. X-Served-By) catch a tunnel to the wrong exit.
+	ExpectHeader      string
+	ExpectHeaderValue string
+
+	// UserAgent is sent by HTTP probes; empty keeps Go's default.
+	UserAgent string
+
+	// FreshConnection disables keep-alive so every HTTP probe dials anew: a
+	// connection kept warm from before a tunnel drop could still answer.
+	FreshConnection bool
+
+	// IPFamily is the stack CheckExpected verifies: FamilyV4 (the default),
+	// FamilyV6, or FamilyBoth (both must pass). A dual-stack host can leak
+	// its real IPv6 address while the IPv4 check passes.
+	IPFamily string
+	// IPv6URL is the endpoint of the IPv6 probe (see CheckIPv6); empty uses
+	// DefaultIPv6URL. Its egress must match ExpectedIPv6 when that is set.
+	IPv6URL      string
+	ExpectedIPv6 []string
+}
+
+// Response body caps for HTTP probes (see Options.MaxBody).
+const (
+	DefaultMaxBody = 4 * 1024
+	FullBodyMax    = 1 << 20
 )
 
+// bodyLimit is the body cap in effect for HTTP probes.
+func bodyLimit() int {
+	switch {
+	case opts.FullBody:
+		return FullBodyMax
+	case opts.MaxBody > 0:
+		return opts.MaxBody
+	}
+	return DefaultMaxBody
+}
+
+// RetryDelay is the pause between Check attempts.
+const RetryDelay = 200 * time.Millisecond
+
+// TLSVersions maps config spellings to crypto/tls versions.
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ErrCertPinMismatch: no certificate in the server's chain matches Options.TLSPins.
+var ErrCertPinMismatch = errors.New("certificate pin mismatch")
+
+// SPKIPin returns the "sha256/<base64>" pin of a certificate's public key.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// tlsConfig builds the probe's TLS settings; nil keeps net/http's defaults.
+func tlsConfig() *tls.Config {
+	if opts.MinTLSVersion == 0 && len(opts.TLSPins) == 0 {
+		return nil
+	}
+	tc := &tls.Config{MinVersion: opts.MinTLSVersion}
+	if len(opts.TLSPins) > 0 {
+		pins := opts.TLSPins
+		// Runs after normal chain verification, so pins add to (not replace) CA checks.
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, c := range cs.PeerCertificates {
+				for _, p := range pins {
+					if SPKIPin(c) == p {
+						return nil
+					}
+				}
+			}
+			got := ""
+			if len(cs.PeerCertificates) > 0 {
+				got = SPKIPin(cs.PeerCertificates[0])
+			}
+			return fmt.Errorf("%w: %s presented %s", ErrCertPinMismatch, cs.ServerName, got)
+		}
+	}
+	return tc
+}
+
+// DefaultCaptivePortalURL is a well-known "no content" endpoint.
+const DefaultCaptivePortalURL = "http://connectivitycheck.gstatic.com/generate_204"
+
+var opts Options
+
+// Configure sets the Options used by all subsequent checks.
+func Configure(o Options) { opts = o }
+
 type Result struct {
 	OK         bool          `json:"ok"`
 	URL        string        `json:"url"`
@@ -16,45 +166,190 @@ type Result struct {
 	Body       string        `json:"body"`
 	Latency    time.Duration `json:"latency"`
 	Err        string        `json:"err"`
+	Redirects  []string      `json:"redirects,omitempty"` // Location chain, in order
+	Attempts   int           `json:"attempts,omitempty"`  // probes Check ran (Options.Retries)
+	Truncated  bool          `json:"truncated,omitempty"` // Body was cut at the body cap
+	// ContentType is the HTTP response's Content-Type header, as sent.
+	ContentType string `json:"content_type,omitempty"`
+	// Headers holds the Options.ExpectHeader response header, if present.
+	Headers map[string]string `json:"headers,omitempty"`
+	// RemoteAddr is the address the HTTP probe last connected to (the proxy's
+	// with health_check_proxy). With a wrong egress IP it tells a DNS answer
+	// pointing elsewhere from traffic misrouted around the tunnel.
+	RemoteAddr string `json:"remote_addr,omitempty"`
+	// Unreachable: no HTTP response at all (DNS, connect or TLS failure).
+	Unreachable bool `json:"unreachable,omitempty"`
+	// EndpointErr: Unreachable while the network answered (see NetworkUp), i.e. the
+	// health endpoint is down rather than the tunnel. Not counted as a tunnel failure.
+	EndpointErr bool `json:"endpoint_err,omitempty"`
+	// IPv6 is the IPv6 probe run alongside this one with IPFamily FamilyBoth.
+	IPv6 *Result `json:"ipv6,omitempty"`
+}
+
+// Failure is nil for an OK result, else an error matching vpnerr.ErrHealthFailed.
+func (r Result) Failure() error {
+	if r.OK {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", vpnerr.ErrHealthFailed, r.Err)
 }
 
+// maxRedirects matches net/http's default limit.
+const maxRedirects = 10
+
+// Check runs the Prober registered for url's scheme (http, https, tcp, unix, file),
+// retrying a failure Options.Retries times while timeout allows.
 func Check(ctx context.Context, url string, timeout time.Duration) Result {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return Result{URL: url, Err: fmt.Sprintf("parse url: %v", err)}
+	}
+	p, ok := probers[u.Scheme]
+	if !ok {
+		return Result{URL: url, Err: fmt.Sprintf("unsupported health check scheme %q", u.Scheme)}
+	}
+	return retry(ctx, p, u, timeout)
+}
+
+// retry runs p until it passes, Options.Retries is used up or timeout runs out.
+func retry(ctx context.Context, p Prober, u *neturl.URL, timeout time.Duration) Result {
+	deadline := time.Now().Add(timeout)
+	for attempt := 1; ; attempt++ {
+		res := p.Probe(ctx, u, time.Until(deadline))
+		res.Attempts = attempt
+		if res.OK || attempt > opts.Retries || time.Until(deadline) <= RetryDelay {
+			return res
+		}
+		t := time.NewTimer(RetryDelay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return res
+		case <-t.C:
+		}
+	}
+}
+
+// CheckFrom is Check with the TCP connection bound to localIP (nil = let the
+// routing table pick, i.e. the default route). It always connects directly,
+// ignoring Options.ProxyURL, so it tests the route rather than the proxy.
+func CheckFrom(ctx context.Context, url string, timeout time.Duration, localIP net.IP) Result {
+	return check(ctx, url, timeout, localIP, "", "")
+}
+
+// check runs one HTTP probe. network ("tcp4", "tcp6") pins the address family
+// of direct connections; "" lets the dialer pick.
+func check(ctx context.Context, url string, timeout time.Duration, localIP net.IP, proxy, network string) Result {
 	res := Result{URL: url}
 
 	start := time.Now()
 	cctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(cctx, http.MethodGet, url, nil)
+	// Every connection made (redirects included) reports in; the last one served the response.
+	trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) {
+		res.RemoteAddr = info.Conn.RemoteAddr().String()
+	}}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(cctx, trace), http.MethodGet, url, nil)
 	if err != nil {
 		res.Err = fmt.Sprintf("new request: %v", err)
 		return res
 	}
+	if opts.UserAgent != "" {
+		req.Header.Set("User-Agent", opts.UserAgent)
+	}
 
 	client := &http.Client{
 		Timeout: timeout, // secondary safety net (ctx is primary)
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			res.Redirects = append(res.Redirects, req.URL.String())
+			if !opts.FollowRedirects {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+	tc := tlsConfig()
+	dialer := &net.Dialer{}
+	dial := dialer.DialContext
+	if network != "" {
+		dial = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	switch {
+	case localIP != nil:
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP}
+		client.Transport = &http.Transport{
+			Proxy:             nil,
+			DialContext:       dial,
+			DisableKeepAlives: true,
+			TLSClientConfig:   tc,
+		}
+	case proxy != "":
+		pu, err := neturl.Parse(proxy)
+		if err != nil {
+			res.Err = fmt.Sprintf("proxy url: %v", err)
+			return res
+		}
+		// net/http dials socks5:// proxies itself; no extra dependency needed.
+		client.Transport = &http.Transport{
+			Proxy:             http.ProxyURL(pu),
+			DisableKeepAlives: true,
+			TLSClientConfig:   tc,
+		}
+	case tc != nil || opts.FreshConnection || network != "":
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tc
+		t.DisableKeepAlives = opts.FreshConnection
+		if network != "" {
+			t.DialContext = dial
+		}
+		client.Transport = t
 	}
 
 	resp, err := client.Do(req)
 	res.Latency = time.Since(start)
 
 	if err != nil {
+		if errors.Is(err, ErrCertPinMismatch) {
+			// Distinct from a plain network error: something is intercepting TLS.
+			res.Err = fmt.Sprintf("tls: %v", err)
+			return res
+		}
 		res.Err = fmt.Sprintf("http do: %v", err)
+		res.Unreachable = true
 		return res
 	}
 	defer resp.Body.Close()
 
 	res.StatusCode = resp.StatusCode
+	res.ContentType = resp.Header.Get("Content-Type")
+	if opts.ExpectHeader != "" {
+		if v := resp.Header.Values(opts.ExpectHeader); len(v) > 0 {
+			res.Headers = map[string]string{http.CanonicalHeaderKey(opts.ExpectHeader): strings.Join(v, ", ")}
+		}
+	}
 
-	// Read only a limited amount to avoid huge bodies.
-	const max = 4 * 1024
-	b, _ := io.ReadAll(io.LimitReader(resp.Body, max))
+	// Read only a limited amount to avoid huge bodies; one extra byte detects truncation.
+	max := bodyLimit()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, int64(max)+1))
+	if len(b) > max {
+		b, res.Truncated = b[:max], true
+	}
 	res.Body = strings.TrimSpace(string(b))
 
 	// Define “OK”: HTTP 200 and non-empty body (simple + practical).
 	if resp.StatusCode == 200 && res.Body != "" {
 		res.OK = true
 	}
+	if !opts.FollowRedirects && len(res.Redirects) > 0 {
+		res.OK = false
+		res.Err = fmt.Sprintf("redirected to %s (possible captive portal; health_follow_redirects=false)", res.Redirects[0])
+	}
 
 	return res
 }
@@ -62,22 +357,214 @@ func Check(ctx context.Context, url string, timeout time.Duration) Result {
 // CheckExpected runs the same HTTP probe as Check, but only reports OK if the
 // response body matches one of expectedIPs (when expectedIPs is non-empty).
 // This is used for "tunnel alive" semantics: ipify/ifconfig must return the VPN egress IP.
+// Expected entries may be plain IPs or CIDRs; the body may be a bare IP or a JSON
+// object with an "ip" field (e.g. ipify's ?format=json).
+//
+// Options.IPFamily swaps in, or adds, the IPv6 probe (see CheckIPv6); with
+// FamilyBoth the result fails when either stack does.
 func CheckExpected(ctx context.Context, url string, timeout time.Duration, expectedIPs []string) Result {
-	res := Check(ctx, url, timeout)
+	switch opts.IPFamily {
+	case FamilyV6:
+		return CheckIPv6(ctx, ipv6URL(), timeout, opts.ExpectedIPv6)
+	case FamilyBoth:
+		v6 := make(chan Result, 1)
+		go func() { v6 <- CheckIPv6(ctx, ipv6URL(), timeout, opts.ExpectedIPv6) }()
+		res := Check(ctx, url, timeout)
+		return withIPv6(matchExpected(res, url, expectedIPs), <-v6)
+	}
+	return matchExpected(Check(ctx, url, timeout), url, expectedIPs)
+}
+
+// matchExpected fails an OK result whose egress is not one of expectedIPs.
+func matchExpected(res Result, url string, expectedIPs []string) Result {
 	if !res.OK {
 		return res
 	}
-	if len(expectedIPs) == 0 {
+	// Only HTTP probes report an egress IP; tcp/unix/file signals can't be compared.
+	if len(expectedIPs) == 0 || !isHTTP(url) {
 		return res
 	}
 	body := strings.TrimSpace(res.Body)
-	for _, ip := range expectedIPs {
-		if strings.TrimSpace(ip) == body {
-			return res
-		}
+	if MatchEgress(body, expectedIPs) {
+		return res
 	}
 	// HTTP is reachable but egress is not one of expected IPs => treat as FAIL.
 	res.OK = false
-	res.Err = fmt.Sprintf("unexpected egress ip %q (expected one of %v)", body, expectedIPs)
+	res.Err = fmt.Sprintf("unexpected egress ip %q (expected one of %v; health url served by %s)", EgressIP(body), expectedIPs, res.RemoteAddr)
+	return res
+}
+
+// EgressIP extracts the reported address from a health response body: a bare
+// IP, the "ip" field of a JSON object, or the first word that is an IP (e.g.
+// "OK 203.0.113.7"). IPv6 may be bracketed ("[2001:db8::1]"). Otherwise the
+// trimmed body is returned.
+func EgressIP(body string) string {
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "{") {
+		var obj struct {
+			IP string `json:"ip"`
+		}
+		if err := json.Unmarshal([]byte(body), &obj); err == nil && obj.IP != "" {
+			return unbracket(strings.TrimSpace(obj.IP))
+		}
+	}
+	if ip := unbracket(body); net.ParseIP(ip) != nil {
+		return ip
+	}
+	for _, f := range strings.Fields(body) {
+		if ip := unbracket(f); net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	return body
+}
+
+// unbracket strips the brackets of an IPv6 literal ("[2001:db8::1]").
+func unbracket(s string) string {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// MatchEgress reports whether the IP in body equals, or lies inside, one of expected
+// (plain IPs or CIDRs, IPv4 or IPv6; an entry with a "/" is a CIDR). Non-IP bodies
+// fall back to exact string comparison.
+func MatchEgress(body string, expected []string) bool {
+	got := EgressIP(body)
+	ip := net.ParseIP(got)
+	for _, e := range expected {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if ip == nil {
+			if e == got {
+				return true
+			}
+			continue
+		}
+		if strings.Contains(e, "/") {
+			if _, n, err := net.ParseCIDR(e); err == nil && n.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if eip := net.ParseIP(e); eip != nil && eip.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// captivePortalURL is Options.CaptivePortalURL or the default.
+func captivePortalURL() string {
+	if opts.CaptivePortalURL != "" {
+		return opts.CaptivePortalURL
+	}
+	return DefaultCaptivePortalURL
+}
+
+// DetectCaptivePortal probes a "no content" URL (Options.CaptivePortalURL) that must
+// answer 204 with an empty body. A redirect or any other response with content means
+// something on the network is intercepting HTTP: a captive portal. Network errors are
+// not a portal (the tunnel or link is simply down). The string explains the verdict.
+func DetectCaptivePortal(ctx context.Context, timeout time.Duration) (bool, string) {
+	url := captivePortalURL()
+
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(cctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, fmt.Sprintf("new request: %v", err)
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		// Never follow: the redirect itself is the signal.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Sprintf("probe %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		return true, fmt.Sprintf("%s redirected (%d) to %s", url, resp.StatusCode, resp.Header.Get("Location"))
+	case resp.StatusCode == http.StatusNoContent && len(strings.TrimSpace(string(b))) == 0:
+		return false, fmt.Sprintf("%s returned 204", url)
+	default:
+		return true, fmt.Sprintf("%s returned %d %s instead of 204", url, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+// ThroughputResult is the outcome of a download-rate probe.
+type ThroughputResult struct {
+	OK          bool          `json:"ok"`
+	URL         string        `json:"url"`
+	StatusCode  int           `json:"status_code"`
+	Bytes       int64         `json:"bytes"`
+	Duration    time.Duration `json:"duration"`
+	BytesPerSec int64         `json:"bytes_per_sec"`
+	MinBPS      int64         `json:"min_bps"`
+	Err         string        `json:"err"`
+}
+
+// maxThroughputBytes caps how much a throughput probe downloads;
+// the payload size itself is chosen by the URL (e.g. ...?bytes=1000000).
+const maxThroughputBytes = 64 << 20
+
+// CheckThroughput downloads url and reports OK if the measured rate is at least
+// minBytesPerSec. If timeout cuts the download short, the rate is computed from
+// what arrived so far; a slow tunnel therefore fails on rate, not on the timeout.
+func CheckThroughput(ctx context.Context, url string, minBytesPerSec int64, timeout time.Duration) ThroughputResult {
+	res := ThroughputResult{URL: url, MinBPS: minBytesPerSec}
+
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(cctx, http.MethodGet, url, nil)
+	if err != nil {
+		res.Err = fmt.Sprintf("new request: %v", err)
+		return res
+	}
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		res.Duration = time.Since(start)
+		res.Err = fmt.Sprintf("http do: %v", err)
+		return res
+	}
+	defer resp.Body.Close()
+
+	res.StatusCode = resp.StatusCode
+	if resp.StatusCode != 200 {
+		res.Duration = time.Since(start)
+		res.Err = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		return res
+	}
+
+	n, copyErr := io.Copy(io.Discard, io.LimitReader(resp.Body, maxThroughputBytes))
+	res.Bytes = n
+	res.Duration = time.Since(start)
+	if res.Duration > 0 {
+		res.BytesPerSec = int64(float64(n) / res.Duration.Seconds())
+	}
+
+	switch {
+	case n == 0:
+		res.Err = "empty body"
+	case res.BytesPerSec < minBytesPerSec:
+		res.Err = fmt.Sprintf("throughput %d B/s below minimum %d B/s", res.BytesPerSec, minBytesPerSec)
+	case copyErr != nil && cctx.Err() == nil:
+		// A read error that isn't our own timeout means the transfer broke.
+		res.Err = fmt.Sprintf("read body: %v", copyErr)
+	default:
+		res.OK = true
+	}
 	return res
 }
@@ -0,0 +1,271 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withOptions applies o for the duration of the test.
+func withOptions(t *testing.T, o Options) {
+	t.Helper()
+	prev := opts
+	Configure(o)
+	t.Cleanup(func() { Configure(prev) })
+}
+
+func TestMatchEgress(t *testing.T) {
+	expected := []string{"89.40.206.121", "203.0.113.0/24", "2001:db8::7", "2001:db8:42::/48"}
+	tests := []struct {
+		body string
+		want bool
+	}{
+		{"89.40.206.121", true},
+		{"89.40.206.122", false},
+		{"203.0.113.200", true},
+		{"203.0.114.1", false},
+		{"2001:db8::7", true},
+		{"2001:0db8:0000::0007", true}, // same address, other spelling
+		{"2001:db8:42:beef::1", true},
+		{"2001:db8:43::1", false},
+		{"[2001:db8:42::9]", true},
+		{"::ffff:203.0.113.5", true}, // IPv4-mapped
+	}
+	for _, tt := range tests {
+		if got := MatchEgress(tt.body, expected); got != tt.want {
+			t.Errorf("MatchEgress(%q) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestMatchEgressEntries(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+		want     bool
+	}{
+		{"v4 /32", "1.2.3.4", []string{"1.2.3.4/32"}, true},
+		{"v6 /128", "2001:db8::1", []string{"2001:db8::1/128"}, true},
+		{"v4 body, v6 prefix", "1.2.3.4", []string{"2001:db8::/32"}, false},
+		{"v6 body, v4 prefix", "2001:db8::1", []string{"0.0.0.0/0"}, false},
+		{"padded entry", "1.2.3.4", []string{" 1.2.3.0/24 "}, true},
+		{"empty entries", "1.2.3.4", []string{"", " "}, false},
+		{"bad cidr", "1.2.3.4", []string{"1.2.3.4/99"}, false},
+		{"non-IP body compares as text", "vpn-exit-1", []string{"vpn-exit-1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchEgress(tt.body, tt.expected); got != tt.want {
+				t.Errorf("MatchEgress(%q, %q) = %v, want %v", tt.body, tt.expected, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	var got atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.UserAgent())
+		fmt.Fprint(w, "203.0.113.7")
+	}))
+	defer srv.Close()
+
+	for _, ua := range []string{"", "vpnrd-test/1.0"} {
+		withOptions(t, Options{UserAgent: ua})
+		if res := Check(context.Background(), srv.URL, 2*time.Second); !res.OK {
+			t.Fatalf("check failed: %s", res.Err)
+		}
+		want := ua
+		if want == "" {
+			want = "Go-http-client/1.1"
+		}
+		if got.Load() != want {
+			t.Errorf("User-Agent = %q, want %q", got.Load(), want)
+		}
+	}
+}
+
+func TestFreshConnection(t *testing.T) {
+	for _, fresh := range []bool{false, true} {
+		t.Run(fmt.Sprint("fresh=", fresh), func(t *testing.T) {
+			var conns atomic.Int32
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, "ok")
+			}))
+			srv.Config.ConnState = func(_ net.Conn, s http.ConnState) {
+				if s == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			srv.Start()
+			defer srv.Close()
+
+			withOptions(t, Options{FreshConnection: fresh})
+			for range 3 {
+				if res := Check(context.Background(), srv.URL, 2*time.Second); !res.OK {
+					t.Fatalf("check failed: %s", res.Err)
+				}
+			}
+			want := int32(1)
+			if fresh {
+				want = 3
+			}
+			if n := conns.Load(); n != want {
+				t.Errorf("%d connections for 3 probes, want %d", n, want)
+			}
+		})
+	}
+}
+
+// echoServer answers every request with body.
+func echoServer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCheckExpected(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+		want     bool
+	}{
+		{"plain IP", "1.2.3.4", []string{"1.2.3.4"}, true},
+		{"trailing newline", "1.2.3.4\n", []string{"1.2.3.4"}, true},
+		{"CIDR /32", "1.2.3.4", []string{"1.2.3.4/32"}, true},
+		{"CIDR /24", "1.2.3.77", []string{"1.2.3.0/24"}, true},
+		{"outside CIDR", "1.2.4.1", []string{"1.2.3.0/24"}, false},
+		{"JSON ip field", `{"ip":"1.2.3.4"}`, []string{"1.2.3.0/24"}, true},
+		{"JSON with padding", " {\"ip\": \" 1.2.3.4 \", \"country\": \"NL\"}\n", []string{"1.2.3.4"}, true},
+		{"JSON other ip", `{"ip":"5.6.7.8"}`, []string{"1.2.3.4"}, false},
+		{"marker and IP", "OK 1.2.3.4", []string{"1.2.3.4"}, true},
+		{"no expectation", "anything", nil, true},
+	}
+	withOptions(t, Options{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := echoServer(t, tt.body)
+			res := CheckExpected(context.Background(), srv.URL, 2*time.Second, tt.expected)
+			if res.OK != tt.want {
+				t.Fatalf("OK = %v (err %q), want %v", res.OK, res.Err, tt.want)
+			}
+			if !tt.want && !strings.Contains(res.Err, "unexpected egress ip") {
+				t.Fatalf("err = %q, want an egress mismatch", res.Err)
+			}
+		})
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/portal", http.StatusFound)
+	})
+	mux.HandleFunc("/portal", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>Welcome to Coffee Shop WiFi</html>")
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	t.Run("rejected by default", func(t *testing.T) {
+		withOptions(t, Options{})
+		res := Check(context.Background(), srv.URL+"/check", 2*time.Second)
+		if res.OK || !strings.Contains(res.Err, "captive portal") {
+			t.Fatalf("OK = %v err = %q; a redirect must fail the check", res.OK, res.Err)
+		}
+		if len(res.Redirects) != 1 || !strings.HasSuffix(res.Redirects[0], "/portal") {
+			t.Fatalf("redirects = %v", res.Redirects)
+		}
+	})
+	t.Run("followed", func(t *testing.T) {
+		withOptions(t, Options{FollowRedirects: true})
+		res := Check(context.Background(), srv.URL+"/check", 2*time.Second)
+		if !res.OK || !strings.Contains(res.Body, "Coffee Shop") {
+			t.Fatalf("OK = %v body = %q err = %q", res.OK, res.Body, res.Err)
+		}
+		if len(res.Redirects) != 1 {
+			t.Fatalf("redirect chain = %v, want one hop", res.Redirects)
+		}
+	})
+	t.Run("loop stops", func(t *testing.T) {
+		withOptions(t, Options{FollowRedirects: true})
+		res := Check(context.Background(), srv.URL+"/loop", 2*time.Second)
+		if res.OK || len(res.Redirects) != maxRedirects {
+			t.Fatalf("OK = %v after %d redirects, want a failure after %d", res.OK, len(res.Redirects), maxRedirects)
+		}
+	})
+}
+
+func TestBodyCap(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      Options
+		size      int
+		wantLen   int
+		truncated bool
+	}{
+		{name: "default cap, fits", size: DefaultMaxBody, wantLen: DefaultMaxBody},
+		{name: "default cap, one byte over", size: DefaultMaxBody + 1, wantLen: DefaultMaxBody, truncated: true},
+		{name: "max_body", opts: Options{MaxBody: 10}, size: 64, wantLen: 10, truncated: true},
+		{name: "full body beats max_body", opts: Options{MaxBody: 10, FullBody: true}, size: 100 << 10, wantLen: 100 << 10},
+		{name: "full body is bounded too", opts: Options{FullBody: true}, size: FullBodyMax + 1, wantLen: FullBodyMax, truncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withOptions(t, tt.opts)
+			srv := echoServer(t, strings.Repeat("x", tt.size))
+			res := Check(context.Background(), srv.URL, 5*time.Second)
+			if !res.OK {
+				t.Fatalf("check failed: %s", res.Err)
+			}
+			if len(res.Body) != tt.wantLen || res.Truncated != tt.truncated {
+				t.Fatalf("body %d bytes truncated=%t, want %d truncated=%t", len(res.Body), res.Truncated, tt.wantLen, tt.truncated)
+			}
+		})
+	}
+}
+
+func TestExpectSubstring(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected []string
+		want     string // substring of Err; "" = OK
+	}{
+		{name: "marker present", body: "OK 203.0.113.7"},
+		{name: "portal page", body: "<html>Please log in</html>", want: `body does not contain "OK"`},
+		{name: "marker and expected IP", body: "OK 203.0.113.7", expected: []string{"203.0.113.0/24"}},
+		{name: "marker, wrong IP", body: "OK 198.51.100.1", expected: []string{"203.0.113.0/24"}, want: "unexpected egress ip"},
+		{name: "right IP, no marker", body: "203.0.113.7", expected: []string{"203.0.113.7"}, want: `body does not contain "OK"`},
+	}
+	withOptions(t, Options{ExpectSubstring: "OK"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := echoServer(t, tt.body)
+			res := CheckExpected(context.Background(), srv.URL, 2*time.Second, tt.expected)
+			if tt.want == "" {
+				if !res.OK {
+					t.Fatalf("check failed: %s", res.Err)
+				}
+				return
+			}
+			if res.OK || !strings.Contains(res.Err, tt.want) {
+				t.Fatalf("OK = %v err = %q, want a failure with %q", res.OK, res.Err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package healthcheck
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// HistoryEntry is one recorded check.
+type HistoryEntry struct {
+	Time   time.Time `json:"time"`
+	Result Result    `json:"result"`
+}
+
+// History is a fixed-size, in-memory ring buffer of recent check results.
+// It is safe for concurrent use.
+type History struct {
+	mu   sync.Mutex
+	buf  []HistoryEntry
+	next int
+	full bool
+}
+
+// NewHistory returns a History keeping the last n results (n < 1 is treated as 1).
+func NewHistory(n int) *History {
+	if n < 1 {
+		n = 1
+	}
+	return &History{buf: make([]HistoryEntry, n)}
+}
+
+// Add records r at time t, evicting the oldest entry when full.
+func (h *History) Add(t time.Time, r Result) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.buf[h.next] = HistoryEntry{Time: t, Result: r}
+	h.next = (h.next + 1) % len(h.buf)
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Entries returns a copy of the recorded results, oldest first.
+func (h *History) Entries() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]HistoryEntry(nil), h.buf[:h.next]...)
+	}
+	out := make([]HistoryEntry, 0, len(h.buf))
+	out = append(out, h.buf[h.next:]...)
+	return append(out, h.buf[:h.next]...)
+}
+
+// Failures returns how many recorded results failed, and how many are recorded.
+func (h *History) Failures() (failed, total int) {
+	for _, e := range h.Entries() {
+		if !e.Result.OK {
+			failed++
+		}
+		total++
+	}
+	return failed, total
+}
+
+// HistoryStats summarizes the recorded window.
+type HistoryStats struct {
+	Total       int     `json:"total"`
+	Failed      int     `json:"failed"`
+	SuccessRate float64 `json:"success_rate"` // 0..1; 0 when nothing is recorded
+	// Latency percentiles over successful checks only: a failed probe's latency
+	// is mostly its timeout and says nothing about the tunnel's speed.
+	LatencyP50 time.Duration `json:"latency_p50"`
+	LatencyP95 time.Duration `json:"latency_p95"`
+}
+
+// Stats returns the success rate and p50/p95 latency of the recorded results.
+func (h *History) Stats() HistoryStats {
+	var st HistoryStats
+	var lat []time.Duration
+	for _, e := range h.Entries() {
+		st.Total++
+		if !e.Result.OK {
+			st.Failed++
+			continue
+		}
+		lat = append(lat, e.Result.Latency)
+	}
+	if st.Total > 0 {
+		st.SuccessRate = float64(st.Total-st.Failed) / float64(st.Total)
+	}
+	if len(lat) > 0 {
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		st.LatencyP50 = percentile(lat, 50)
+		st.LatencyP95 = percentile(lat, 95)
+	}
+	return st
+}
+
+// percentile is the nearest-rank percentile of sorted (non-empty) values.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	i := (p*len(sorted)+99)/100 - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,77 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	neturl "net/url"
+	"time"
+)
+
+// IP families CheckExpected verifies (Options.IPFamily).
+const (
+	FamilyV4   = "v4"
+	FamilyV6   = "v6"
+	FamilyBoth = "both"
+)
+
+// DefaultIPv6URL is an IPv6-only echo endpoint (it has no A record).
+const DefaultIPv6URL = "https://api6.ipify.org?format=text"
+
+// ipv6URL is Options.IPv6URL or the default.
+func ipv6URL() string {
+	if opts.IPv6URL != "" {
+		return opts.IPv6URL
+	}
+	return DefaultIPv6URL
+}
+
+// CheckIPv6 is CheckExpected over IPv6 only: direct connections are dialed as
+// tcp6, so a host without IPv6 egress fails instead of falling back to IPv4.
+// Through Options.ProxyURL the proxy picks the family, which is why url
+// should be IPv6-only as well. Only http(s) urls are supported.
+func CheckIPv6(ctx context.Context, url string, timeout time.Duration, expectedIPs []string) Result {
+	u, err := neturl.Parse(url)
+	if err != nil {
+		return Result{URL: url, Err: fmt.Sprintf("parse url: %v", err)}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Result{URL: url, Err: fmt.Sprintf("ipv6 check needs an http(s) url, not %q", u.Scheme)}
+	}
+	return matchExpected(retry(ctx, httpProber{network: "tcp6"}, u, timeout), url, expectedIPs)
+}
+
+// withIPv6 attaches the IPv6 probe to res and fails res when v6 failed.
+func withIPv6(res, v6 Result) Result {
+	res.IPv6 = &v6
+	if res.OK && !v6.OK {
+		res.OK = false
+		res.Err = "ipv6: " + v6.Err
+	}
+	return res
+}
+
+// sourceIPv6 is SourceIP for the IPv6 probe: an address must be IPv6, an
+// interface yields its first global IPv6 address.
+func sourceIPv6(spec string) (net.IP, error) {
+	if ip := net.ParseIP(spec); ip != nil {
+		if ip.To4() != nil {
+			return nil, fmt.Errorf("%s is not an IPv6 address (health_check_ip_family needs one)", spec)
+		}
+		return SourceIP(spec)
+	}
+	ifi, err := net.InterfaceByName(spec)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s not found (tunnel not up?)", spec)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", spec, err)
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() == nil && ipnet.IP.IsGlobalUnicast() {
+			return ipnet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("interface %s has no global IPv6 address (no IPv6 through the tunnel?)", spec)
+}
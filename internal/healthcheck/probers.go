@@ -0,0 +1,241 @@
+package healthcheck
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Prober runs one health probe for a parsed health_check_url.
+type Prober interface {
+	Probe(ctx context.Context, u *neturl.URL, timeout time.Duration) Result
+}
+
+// probers maps URL schemes to implementations:
+//
+//	http(s)://host/path                 HTTP GET (see CheckFrom)
+//	tcp://host:port[?contains=s]        TCP connect (and optionally read until s)
+//	unix:///path/to.sock[?contains=s]   unix socket connect (same)
+//	file:///path[?max_age=30s&contains=s]  file exists, is fresh, has s
+var probers = map[string]Prober{
+	"http":  httpProber{},
+	"https": httpProber{},
+	"tcp":   dialProber{network: "tcp"},
+	"unix":  dialProber{network: "unix"},
+	"file":  fileProber{},
+}
+
+// isHTTP reports whether url is probed over HTTP (the only prober with an egress IP).
+func isHTTP(url string) bool {
+	u, err := neturl.Parse(url)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// httpProber runs check; network "tcp6" makes it the IPv6 probe.
+type httpProber struct{ network string }
+
+func (p httpProber) Probe(ctx context.Context, u *neturl.URL, timeout time.Duration) Result {
+	var res Result
+	if opts.SourceAddr != "" {
+		source := SourceIP
+		if p.network == "tcp6" {
+			source = sourceIPv6
+		}
+		ip, err := source(opts.SourceAddr)
+		if err != nil {
+			return Result{URL: u.String(), Err: fmt.Sprintf("health_check_source: %v", err)}
+		}
+		res = check(ctx, u.String(), timeout, ip, "", p.network)
+	} else {
+		res = check(ctx, u.String(), timeout, nil, opts.ProxyURL, p.network)
+	}
+	if !res.OK {
+		return res
+	}
+	if err := expectResponse(res); err != "" {
+		res.OK = false
+		res.Err = err
+	}
+	return res
+}
+
+// expectResponse applies Options.ExpectHeader, Options.ExpectContentType and
+// Options.ExpectSubstring to an otherwise OK HTTP response. The last two catch
+// a portal answering 200 with its own page. It returns the failure reason, or "".
+func expectResponse(res Result) string {
+	if name := opts.ExpectHeader; name != "" {
+		got, ok := res.Headers[http.CanonicalHeaderKey(name)]
+		switch {
+		case !ok:
+			return fmt.Sprintf("header %s missing (wrong backend or service?)", name)
+		case opts.ExpectHeaderValue != "" && got != opts.ExpectHeaderValue:
+			return fmt.Sprintf("header %s is %q, expected %q (wrong backend?)", name, got, opts.ExpectHeaderValue)
+		}
+	}
+	if want := opts.ExpectContentType; want != "" {
+		got, _, _ := mime.ParseMediaType(res.ContentType)
+		if !strings.EqualFold(got, want) {
+			return fmt.Sprintf("content type %q, expected %s (captive portal or wrong service?)", res.ContentType, want)
+		}
+		if got != "text/html" && looksLikeHTML(res.Body) {
+			return fmt.Sprintf("HTML body served as %s (captive portal?)", got)
+		}
+	}
+	if opts.ExpectSubstring != "" && !strings.Contains(res.Body, opts.ExpectSubstring) {
+		return fmt.Sprintf("body does not contain %q (captive portal or wrong service?)", opts.ExpectSubstring)
+	}
+	return ""
+}
+
+// SourceIP resolves a probe source: an IP must be assigned to a local
+// interface; an interface name yields its IPv4 address, else its first global
+// IPv6 one. Either is missing while the tunnel is down, which is reported as such.
+func SourceIP(spec string) (net.IP, error) {
+	if ip := net.ParseIP(spec); ip != nil {
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return ip, nil
+			}
+		}
+		return nil, fmt.Errorf("%s is not assigned to any interface (tunnel not up?)", spec)
+	}
+	ifi, err := net.InterfaceByName(spec)
+	if err != nil {
+		return nil, fmt.Errorf("interface %s not found (tunnel not up?)", spec)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("interface %s: %w", spec, err)
+	}
+	var v6 net.IP
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+		if v6 == nil && ipnet.IP.IsGlobalUnicast() {
+			v6 = ipnet.IP
+		}
+	}
+	if v6 == nil {
+		return nil, fmt.Errorf("interface %s has no usable address (tunnel not up?)", spec)
+	}
+	return v6, nil
+}
+
+// looksLikeHTML reports whether body starts like an HTML document.
+func looksLikeHTML(body string) bool {
+	b := strings.ToLower(strings.TrimSpace(body))
+	return strings.HasPrefix(b, "<!doctype html") || strings.HasPrefix(b, "<html") || strings.HasPrefix(b, "<head")
+}
+
+// dialProber is OK when a connection opens; with ?contains= the peer must also
+// send that text before timeout (e.g. a status socket writing "ok").
+type dialProber struct{ network string }
+
+func (p dialProber) Probe(ctx context.Context, u *neturl.URL, timeout time.Duration) Result {
+	res := Result{URL: u.String()}
+	addr := u.Host
+	if p.network == "unix" {
+		addr = u.Path
+	}
+
+	start := time.Now()
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(cctx, p.network, addr)
+	if err != nil {
+		res.Latency = time.Since(start)
+		res.Err = fmt.Sprintf("dial %s %s: %v", p.network, addr, err)
+		return res
+	}
+	defer conn.Close()
+	res.Latency = time.Since(start)
+
+	want := u.Query().Get("contains")
+	if want == "" {
+		res.OK = true
+		return res
+	}
+	if dl, ok := cctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(dl)
+	}
+	var buf bytes.Buffer
+	chunk := make([]byte, 512)
+	for buf.Len() < 4*1024 {
+		n, err := conn.Read(chunk)
+		buf.Write(chunk[:n])
+		if strings.Contains(buf.String(), want) || err != nil {
+			break
+		}
+	}
+	res.Body = strings.TrimSpace(buf.String())
+	if strings.Contains(res.Body, want) {
+		res.OK = true
+	} else {
+		res.Err = fmt.Sprintf("response does not contain %q", want)
+	}
+	return res
+}
+
+// fileProber checks a file written by another process: it must exist, be
+// modified within ?max_age= (if given) and contain ?contains= (if given).
+type fileProber struct{}
+
+func (fileProber) Probe(_ context.Context, u *neturl.URL, _ time.Duration) (res Result) {
+	res.URL = u.String()
+	start := time.Now()
+	defer func() { res.Latency = time.Since(start) }()
+
+	q := u.Query()
+	var maxAge time.Duration
+	if v := q.Get("max_age"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			res.Err = fmt.Sprintf("max_age: %v", err)
+			return res
+		}
+		maxAge = d
+	}
+
+	f, err := os.Open(u.Path)
+	if err != nil {
+		res.Err = err.Error()
+		return res
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		res.Err = err.Error()
+		return res
+	}
+	if age := time.Since(fi.ModTime()); maxAge > 0 && age > maxAge {
+		res.Err = fmt.Sprintf("%s is stale: modified %s ago (max_age %s)", u.Path, age.Round(time.Second), maxAge)
+		return res
+	}
+	b, _ := io.ReadAll(io.LimitReader(f, 4*1024))
+	res.Body = strings.TrimSpace(string(b))
+	if want := q.Get("contains"); want != "" && !strings.Contains(res.Body, want) {
+		res.Err = fmt.Sprintf("%s does not contain %q", u.Path, want)
+		return res
+	}
+	res.OK = true
+	return res
+}
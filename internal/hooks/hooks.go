@@ -0,0 +1,91 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+)
+
+// Event names a lifecycle point; it matches the yaml key under "hooks:".
+type Event string
+
+const (
+	PreUp        Event = "pre_up"
+	PostUp       Event = "post_up"
+	PreDown      Event = "pre_down"
+	PostDown     Event = "post_down"
+	OnRecover    Event = "on_recover"
+	OnHealthFail Event = "on_health_fail"
+	OnGiveUp     Event = "on_give_up"
+	OnTunnelDown Event = "on_tunnel_down"
+	OnRecovered  Event = "on_recovered"
+	OnWANChanged Event = "on_wan_changed"
+)
+
+// Vars are exported to hook commands as VPNRD_<KEY> environment variables
+// (e.g. "utun" -> VPNRD_UTUN). VPNRD_EVENT is always set.
+type Vars map[string]string
+
+func commands(cfg *config.Config, ev Event) []string {
+	h := cfg.Hooks
+	switch ev {
+	case PreUp:
+		return h.PreUp
+	case PostUp:
+		return h.PostUp
+	case PreDown:
+		return h.PreDown
+	case PostDown:
+		return h.PostDown
+	case OnRecover:
+		return h.OnRecover
+	case OnHealthFail:
+		return h.OnHealthFail
+	case OnGiveUp:
+		return h.OnGiveUp
+	case OnTunnelDown:
+		return h.OnTunnelDown
+	case OnRecovered:
+		return h.OnRecovered
+	case OnWANChanged:
+		return h.OnWANChanged
+	}
+	return nil
+}
+
+// Run executes the hook commands for ev in order via /bin/sh -c, with
+// cfg.CommandTimeout each. Failures are logged and, unless hooks.fatal is set,
+// swallowed; with hooks.fatal the first failure stops the list and is returned.
+func Run(ctx context.Context, cfg *config.Config, ev Event, vars Vars) error {
+	cmds := commands(cfg, ev)
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	env := []string{"VPNRD_EVENT=" + string(ev)}
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, "VPNRD_"+strings.ToUpper(k)+"="+vars[k])
+	}
+
+	for i, c := range cmds {
+		_, err := control.RunScriptEnv(ctx, "/bin/sh", cfg.CommandTimeout, env, "-c", c)
+		if err == nil {
+			continue
+		}
+		logx.Warnf("[vpnrd] hook %s[%d] %q failed: %v", ev, i, c, err)
+		if cfg.Hooks.Fatal {
+			return fmt.Errorf("hook %s[%d]: %w", ev, i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package killswitch
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+)
+
+// Result of a kill-switch verification.
+// OK means no probe egressed with an address outside the expected VPN set.
+type Result struct {
+	OK      bool                `json:"ok"`
+	Default healthcheck.Result  `json:"default"`       // unbound probe (default route)
+	WAN     *healthcheck.Result `json:"wan,omitempty"` // probe bound to the WAN address
+	WANIP   string              `json:"wan_ip,omitempty"`
+	Leak    string              `json:"leak,omitempty"` // which probe leaked, and the IP it saw
+	Err     string              `json:"err,omitempty"`
+}
+
+// Verify checks that traffic cannot egress outside the tunnel:
+//   - an unbound probe (default route) must fail or report one of expected;
+//   - a probe bound to wanIF's IPv4 address (if wanIF is set) must fail or report one of expected.
+//
+// A probe that succeeds with any other egress IP is a leak.
+// expected must be non-empty, otherwise VPN egress can't be told apart from WAN.
+func Verify(ctx context.Context, url string, timeout time.Duration, wanIF string, expected []string) Result {
+	var res Result
+	if len(expected) == 0 {
+		res.Err = "vpn_server_ips is empty; cannot tell VPN egress from WAN egress"
+		return res
+	}
+
+	// CheckFrom, not Check: a health_check_proxy must not hide what the default route does.
+	res.Default = healthcheck.CheckFrom(ctx, url, timeout, nil)
+	if leaked(res.Default, expected) {
+		res.Leak = fmt.Sprintf("default route egressed as %s", healthcheck.EgressIP(res.Default.Body))
+		return res
+	}
+
+	if strings.TrimSpace(wanIF) != "" {
+		ip, err := ifaceIPv4(wanIF)
+		if err != nil {
+			res.Err = fmt.Sprintf("wan %s: %v (WAN-bound probe skipped)", wanIF, err)
+		} else {
+			res.WANIP = ip.String()
+			w := healthcheck.CheckFrom(ctx, url, timeout, ip)
+			res.WAN = &w
+			if leaked(w, expected) {
+				res.Leak = fmt.Sprintf("WAN-bound probe (%s via %s) egressed as %s", ip, wanIF, healthcheck.EgressIP(w.Body))
+				return res
+			}
+		}
+	}
+
+	res.OK = true
+	return res
+}
+
+// leaked: the request got through and reported an egress IP outside expected.
+func leaked(h healthcheck.Result, expected []string) bool {
+	return h.OK && !healthcheck.MatchEgress(h.Body, expected)
+}
+
+func ifaceIPv4(name string) (net.IP, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+	}
+	return nil, fmt.Errorf("no IPv4 address")
+}
@@ -0,0 +1,87 @@
+// Package logdedup collapses runs of identical log lines into periodic
+// "last message repeated N times" summaries.
+package logdedup
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+)
+
+// Defaults used by the watchdog.
+const (
+	DefaultEvery    = 10
+	DefaultInterval = 5 * time.Minute
+)
+
+// Logger writes through to the standard logger. When enabled, a message whose
+// key equals the previous one is suppressed; a summary is written every Every
+// repeats or once Interval has passed, and when a different message (or Flush)
+// ends the run.
+type Logger struct {
+	Enabled  bool
+	Every    int
+	Interval time.Duration
+
+	mu        sync.Mutex
+	key       string
+	last      string    // last message text (suppressed or not)
+	repeats   int       // suppressed since the last line written
+	lastWrite time.Time // when the run last produced output
+}
+
+// New returns a Logger with the default summary cadence.
+func New(enabled bool) *Logger {
+	return &Logger{Enabled: enabled, Every: DefaultEvery, Interval: DefaultInterval}
+}
+
+// Printf logs the message unless it repeats the previous one. Messages with the
+// same key count as identical even if their text differs (e.g. a failure counter
+// or a latency); an empty key uses the formatted text.
+func (l *Logger) Printf(key, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if !l.Enabled {
+		logx.Warnf("%s", msg)
+		return
+	}
+	if key == "" {
+		key = msg
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if key == l.key && !l.lastWrite.IsZero() {
+		l.repeats++
+		l.last = msg
+		if l.repeats >= l.Every || now.Sub(l.lastWrite) >= l.Interval {
+			l.flushLocked(now)
+		}
+		return
+	}
+
+	l.flushLocked(now)
+	l.key, l.last = key, msg
+	l.lastWrite = now
+	logx.Warnf("%s", msg)
+}
+
+// Flush writes the pending repeat summary, if any, and ends the current run.
+func (l *Logger) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flushLocked(time.Now())
+	l.key, l.last, l.lastWrite = "", "", time.Time{}
+}
+
+func (l *Logger) flushLocked(now time.Time) {
+	if l.repeats == 0 {
+		return
+	}
+	logx.Warnf("last message repeated %d times (latest: %s)", l.repeats, l.last)
+	l.repeats = 0
+	l.lastWrite = now
+}
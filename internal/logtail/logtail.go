@@ -0,0 +1,119 @@
+// Package logtail reads the end of log files and follows them as they grow.
+package logtail
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// maxTailBytes bounds how much of the log end is read; long lines just yield fewer of them.
+const maxTailBytes = 64 * 1024
+
+// Lines returns up to n last lines of path, reading at most maxTailBytes
+// from its end. A missing or empty file yields nil.
+func Lines(path string, n int) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	if size == 0 {
+		return nil, nil
+	}
+	off := size - maxTailBytes
+	if off < 0 {
+		off = 0
+	}
+	buf := make([]byte, size-off)
+	if _, err := f.ReadAt(buf, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if off > 0 {
+		// Drop the partial first line.
+		if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+			buf = buf[i+1:]
+		}
+	}
+
+	lines := strings.Split(strings.TrimRight(string(buf), "\r\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, "\r")
+	}
+	return lines, nil
+}
+
+// Follower returns lines appended to a file since the previous Poll, like tail -f.
+type Follower struct {
+	path    string
+	off     int64
+	partial []byte // trailing bytes of an unfinished line
+}
+
+// NewFollower starts following path at its current end (0 if it doesn't exist yet).
+func NewFollower(path string) *Follower {
+	fl := &Follower{path: path}
+	if fi, err := os.Stat(path); err == nil {
+		fl.off = fi.Size()
+	}
+	return fl
+}
+
+// Poll returns the complete lines written since the last call. A file that
+// shrank (truncated or rotated) is read again from the start; a missing file
+// yields no lines.
+func (fl *Follower) Poll() ([]string, error) {
+	f, err := os.Open(fl.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if fi.Size() < fl.off {
+		fl.off, fl.partial = 0, nil
+	}
+	if fi.Size() == fl.off {
+		return nil, nil
+	}
+
+	buf := make([]byte, fi.Size()-fl.off)
+	n, err := f.ReadAt(buf, fl.off)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	fl.off += int64(n)
+
+	data := append(fl.partial, buf[:n]...)
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		fl.partial = data
+		return nil, nil
+	}
+	fl.partial = append([]byte(nil), data[end+1:]...)
+
+	lines := strings.Split(string(data[:end]), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, "\r")
+	}
+	return lines, nil
+}
@@ -0,0 +1,63 @@
+// Package logx adds levels on top of the standard logger. Lines keep the
+// stdlib format (vpnrd logs parses it); warnings and errors are tagged.
+package logx
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Level orders verbosity: a logger at Info writes Error, Warn and Info.
+type Level int
+
+const (
+	Error Level = iota
+	Warn
+	Info
+	Debug
+)
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+func (l Level) String() string {
+	if l < Error || l > Debug {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel accepts error, warn (warning), info or debug.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "error":
+		return Error, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "info", "":
+		return Info, nil
+	case "debug":
+		return Debug, nil
+	}
+	return Info, fmt.Errorf("unknown log level %q (want error, warn, info or debug)", s)
+}
+
+var level = Info
+
+// SetLevel sets the most verbose level written.
+func SetLevel(l Level) { level = l }
+
+// Enabled reports whether messages at l are written.
+func Enabled(l Level) bool { return l <= level }
+
+func output(l Level, tag, format string, args ...any) {
+	if !Enabled(l) {
+		return
+	}
+	_ = log.Output(3, tag+fmt.Sprintf(format, args...))
+}
+
+func Errorf(format string, args ...any) { output(Error, "ERROR ", format, args...) }
+func Warnf(format string, args ...any)  { output(Warn, "WARN ", format, args...) }
+func Infof(format string, args ...any)  { output(Info, "", format, args...) }
+func Debugf(format string, args ...any) { output(Debug, "debug: ", format, args...) }
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/atomicfile"
+)
+
+// Gauges is the watchdog state exported to Prometheus.
+type Gauges struct {
+	HealthOK            bool
+	HealthLatency       time.Duration
+	SingBoxRunning      bool
+	ConsecutiveFailures int
+	RecoveryTotal       int
+
+	// Over the watchdog's history window (history_size checks).
+	HealthSuccessRatio float64
+	HealthLatencyP95   time.Duration
+
+	// Rate of the last throughput probe; only exported when HasThroughput
+	// (throughput_check_url is set and the probe has run).
+	ThroughputBPS int64
+	HasThroughput bool
+}
+
+// Format renders g in the Prometheus text exposition format.
+func Format(g Gauges) []byte {
+	var b bytes.Buffer
+	write := func(name, typ, help string, v float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, v)
+	}
+	write("vpnrd_health_ok", "gauge", "1 if the last health check passed.", boolFloat(g.HealthOK))
+	write("vpnrd_health_latency_seconds", "gauge", "Latency of the last health check.", g.HealthLatency.Seconds())
+	write("vpnrd_singbox_running", "gauge", "1 if sing-box (owned or adopted) is running.", boolFloat(g.SingBoxRunning))
+	write("vpnrd_consecutive_failures", "gauge", "Consecutive failed health checks.", float64(g.ConsecutiveFailures))
+	write("vpnrd_recovery_total", "counter", "Recovery attempts since the watchdog started.", float64(g.RecoveryTotal))
+	write("vpnrd_health_success_ratio", "gauge", "Share of passed health checks in the history window.", g.HealthSuccessRatio)
+	write("vpnrd_health_latency_p95_seconds", "gauge", "95th percentile latency of passed health checks in the history window.", g.HealthLatencyP95.Seconds())
+	if g.HasThroughput {
+		write("vpnrd_throughput_bytes_per_second", "gauge", "Download rate measured by the last throughput probe.", float64(g.ThroughputBPS))
+	}
+	return b.Bytes()
+}
+
+// WriteTextfile atomically writes g to path for node_exporter's textfile collector.
+func WriteTextfile(path string, g Gauges) error {
+	return atomicfile.Write(path, Format(g), 0o644)
+}
+
+func boolFloat(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
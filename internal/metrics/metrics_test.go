@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	out := string(Format(Gauges{HealthOK: true, HealthLatency: 250 * time.Millisecond, RecoveryTotal: 3}))
+	for _, want := range []string{
+		"# TYPE vpnrd_health_ok gauge\nvpnrd_health_ok 1\n",
+		"vpnrd_health_latency_seconds 0.25\n",
+		"# TYPE vpnrd_recovery_total counter\nvpnrd_recovery_total 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "vpnrd_throughput_bytes_per_second") {
+		t.Error("throughput exported before any probe ran")
+	}
+
+	out = string(Format(Gauges{HasThroughput: true, ThroughputBPS: 1250000}))
+	if !strings.Contains(out, "# TYPE vpnrd_throughput_bytes_per_second gauge\nvpnrd_throughput_bytes_per_second 1.25e+06\n") {
+		t.Errorf("throughput gauge missing:\n%s", out)
+	}
+}
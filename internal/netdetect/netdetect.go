@@ -0,0 +1,96 @@
+// Package netdetect derives host network facts the router config would
+// otherwise hardcode, such as which NIC currently carries the default route.
+package netdetect
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+)
+
+// Route is the host's IPv4 default route.
+type Route struct {
+	Interface string `json:"interface"`
+	Gateway   string `json:"gateway,omitempty"`
+}
+
+// DefaultRoute reads the default route: "route -n get default" on macOS,
+// "ip route show default" on Linux.
+func DefaultRoute(ctx context.Context) (Route, error) {
+	if runtime.GOOS == "linux" {
+		out, err := control.Output(ctx, "ip", "route", "show", "default")
+		if err != nil {
+			return Route{}, fmt.Errorf("ip route show default: %w", err)
+		}
+		return parseIPRoute(string(out))
+	}
+	out, err := control.Output(ctx, "route", "-n", "get", "default")
+	if err != nil {
+		return Route{}, fmt.Errorf("route -n get default: %w", err)
+	}
+	return parseRouteGet(string(out))
+}
+
+// DefaultWAN is the default route's interface. A tunnel interface (utun/tun)
+// there means the VPN owns the default route, which says nothing about the
+// physical WAN, so it is reported as an error.
+func DefaultWAN(ctx context.Context) (string, error) {
+	r, err := DefaultRoute(ctx)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(r.Interface, "utun") || strings.HasPrefix(r.Interface, "tun") {
+		return "", fmt.Errorf("default route is via tunnel %s", r.Interface)
+	}
+	return r.Interface, nil
+}
+
+// parseRouteGet parses macOS "route -n get default":
+//
+//	  gateway: 192.168.1.1
+//	interface: en0
+func parseRouteGet(out string) (Route, error) {
+	var r Route
+	sc := bufio.NewScanner(strings.NewReader(out))
+	for sc.Scan() {
+		k, v, ok := strings.Cut(sc.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(k) {
+		case "interface":
+			r.Interface = strings.TrimSpace(v)
+		case "gateway":
+			r.Gateway = strings.TrimSpace(v)
+		}
+	}
+	if r.Interface == "" {
+		return Route{}, fmt.Errorf("no default route")
+	}
+	return r, nil
+}
+
+// parseIPRoute parses the first line of Linux "ip route show default":
+//
+//	default via 192.168.1.1 dev eth0 proto dhcp metric 100
+func parseIPRoute(out string) (Route, error) {
+	line, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	f := strings.Fields(line)
+	var r Route
+	for i := 0; i+1 < len(f); i++ {
+		switch f[i] {
+		case "dev":
+			r.Interface = f[i+1]
+		case "via":
+			r.Gateway = f[i+1]
+		}
+	}
+	if r.Interface == "" {
+		return Route{}, fmt.Errorf("no default route")
+	}
+	return r, nil
+}
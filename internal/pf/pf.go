@@ -0,0 +1,77 @@
+// Package pf manages vpnrd's own pf anchor (pf_managed): the NAT and
+// kill-switch rules are rendered from a template and loaded with pfctl,
+// replacing the pf_apply script. The main ruleset (/etc/pf.conf) must
+// reference the anchor:
+//
+//	nat-anchor "vpnrd"
+//	anchor "vpnrd"
+package pf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+var dryRun bool
+
+// SetDryRun makes the package log the rules instead of loading them.
+func SetDryRun(v bool) { dryRun = v }
+
+// LoadAnchor replaces the rules of anchor name with rules ("pfctl -a name -f -").
+// pfctl parses the whole ruleset before loading it, so a syntax error leaves
+// the anchor's previous rules in place.
+func LoadAnchor(ctx context.Context, name, rules string) error {
+	if dryRun {
+		logx.Infof("[vpnrd] dry-run: pfctl -a %s -f -\n%s", name, rules)
+		return nil
+	}
+	if err := pfctl(ctx, strings.NewReader(rules), "-a", name, "-f", "-"); err != nil {
+		return fmt.Errorf("load pf anchor %s: %w", name, err)
+	}
+	return nil
+}
+
+// FlushAnchor removes all rules, NAT rules and tables from anchor name
+// ("pfctl -a name -F all").
+func FlushAnchor(ctx context.Context, name string) error {
+	if dryRun {
+		logx.Infof("[vpnrd] dry-run: pfctl -a %s -F all", name)
+		return nil
+	}
+	if err := pfctl(ctx, nil, "-a", name, "-F", "all"); err != nil {
+		return fmt.Errorf("flush pf anchor %s: %w", name, err)
+	}
+	return nil
+}
+
+// pfctl runs pfctl and turns a failure into an error carrying its stderr.
+func pfctl(ctx context.Context, stdin io.Reader, args ...string) error {
+	name, args := control.Privileged("pfctl", args...)
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	msg := strings.TrimSpace(stderr.String())
+	if ctx.Err() != nil {
+		return vpnerr.Class(vpnerr.ErrTimeout, fmt.Errorf("pfctl: %w", ctx.Err()))
+	}
+	if strings.Contains(msg, "Permission denied") || control.SudoRefused(msg) {
+		return vpnerr.Class(vpnerr.ErrPermission, fmt.Errorf("pfctl: %s", msg))
+	}
+	if msg == "" {
+		return fmt.Errorf("pfctl: %w", err)
+	}
+	return fmt.Errorf("pfctl: %w: %s", err, msg)
+}
@@ -0,0 +1,87 @@
+package pf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// Vars are the values a rules template can use, e.g. {{.Utun}} or
+// {{join .VPNServersV4}} (space-separated, as pf lists want).
+type Vars struct {
+	Utun, WAN, LAN string
+	LANCIDR        string
+	TunIP, TunIP6  string
+	TunCIDR        string
+	VPNServersV4   []string
+	VPNServersV6   []string
+	WANDNSV4       []string
+	WANDNSV6       []string
+	AllowNTP       bool
+}
+
+// DefaultRules is the built-in template, equivalent to vpn_router_pf_apply.sh:
+// LAN traffic is NATed into the tunnel and this host may reach the WAN only
+// for the VPN servers (plus optional DNS and NTP).
+const DefaultRules = `# vpnrd managed anchor: utun={{.Utun}} wan={{.WAN}} lan={{.LAN}}
+{{- if .VPNServersV4}}
+table <vpnrd_vpn_servers> persist { {{join .VPNServersV4}} }
+{{- end}}
+{{- if .WANDNSV4}}
+table <vpnrd_wan_dns> persist { {{join .WANDNSV4}} }
+{{- end}}
+
+nat on {{.Utun}} from {{.LANCIDR}} to any -> ({{.Utun}})
+
+pass in  quick on {{.LAN}} inet from {{.LANCIDR}} to any keep state
+pass out quick on {{.Utun}} inet from {{.LANCIDR}} to any keep state
+{{- if .VPNServersV4}}
+pass out quick on {{.WAN}} inet from ({{.WAN}}) to <vpnrd_vpn_servers> keep state
+{{- end}}
+{{- if .VPNServersV6}}
+pass out quick on {{.WAN}} inet6 from ({{.WAN}}) to { {{join .VPNServersV6}} } keep state
+{{- end}}
+{{- if .WANDNSV4}}
+pass out quick on {{.WAN}} inet proto { udp, tcp } from ({{.WAN}}) to <vpnrd_wan_dns> port 53 keep state
+{{- end}}
+{{- if .WANDNSV6}}
+pass out quick on {{.WAN}} inet6 proto { udp, tcp } from ({{.WAN}}) to { {{join .WANDNSV6}} } port 53 keep state
+{{- end}}
+{{- if .AllowNTP}}
+pass out quick on {{.WAN}} inet proto udp from ({{.WAN}}) to any port 123 keep state
+{{- end}}
+`
+
+// ParseTemplate parses a rules template; path "" selects DefaultRules.
+func ParseTemplate(path string) (*template.Template, error) {
+	text := DefaultRules
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("pf rules template: %w", err)
+		}
+		text = string(b)
+	}
+	t, err := template.New("pf").Funcs(template.FuncMap{
+		"join": func(xs []string) string { return strings.Join(xs, " ") },
+	}).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("pf rules template: %w", err)
+	}
+	return t, nil
+}
+
+// Render fills the template at path ("" = DefaultRules) with v.
+func Render(path string, v Vars) (string, error) {
+	t, err := ParseTemplate(path)
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := t.Execute(&b, v); err != nil {
+		return "", fmt.Errorf("pf rules template: %w", err)
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,92 @@
+package router
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+)
+
+const (
+	configPollInterval = 1 * time.Second
+	// configSettle is how long the file must stay unchanged after a write; editors
+	// and config generators often write in several steps (truncate, write, rename).
+	configSettle = 2 * time.Second
+)
+
+// configWatch polls one file's mtime/size (no fsnotify dependency) and reports a
+// change only once it has settled.
+type configWatch struct {
+	path    string
+	mtime   time.Time
+	size    int64
+	pending time.Time // zero = no unsettled change
+}
+
+func newConfigWatch(path string) *configWatch {
+	cw := &configWatch{}
+	cw.reset(path)
+	return cw
+}
+
+// reset starts watching path, taking its current state as the baseline.
+func (cw *configWatch) reset(path string) {
+	cw.path = path
+	cw.pending = time.Time{}
+	cw.mtime, cw.size = time.Time{}, 0
+	if fi, err := os.Stat(path); err == nil {
+		cw.mtime, cw.size = fi.ModTime(), fi.Size()
+	}
+}
+
+// poll reports true once per settled change. A missing file is treated as
+// mid-write and never reported.
+func (cw *configWatch) poll(now time.Time) bool {
+	fi, err := os.Stat(cw.path)
+	if err != nil {
+		return false
+	}
+	if !fi.ModTime().Equal(cw.mtime) || fi.Size() != cw.size {
+		cw.mtime, cw.size = fi.ModTime(), fi.Size()
+		cw.pending = now
+		return false
+	}
+	if cw.pending.IsZero() || now.Sub(cw.pending) < configSettle {
+		return false
+	}
+	cw.pending = time.Time{}
+	return true
+}
+
+// checkSingBoxConfig restarts the owned sing-box after its config changed, but
+// only if "sing-box check" accepts the new file; a broken config keeps the
+// running instance untouched.
+func (w *Watchdog) checkSingBoxConfig(ctx context.Context) {
+	cfg := w.cfg
+
+	// Failover switched endpoints: it already restarted on the new file.
+	if w.configWatch.path != cfg.SingBoxConfigPath {
+		w.configWatch.reset(cfg.SingBoxConfigPath)
+		return
+	}
+	if !w.configWatch.poll(time.Now()) {
+		return
+	}
+
+	path := cfg.SingBoxConfigPath
+	if err := singboxctl.CheckConfig(ctx, cfg, path); err != nil {
+		logx.Warnf("[vpnrd] event=singbox_config_invalid path=%q not restarting: %v", path, err)
+		return
+	}
+
+	sb0, _ := singboxctl.Inspect(cfg)
+	if sb0 == nil || !sb0.OwnedByUs || cfg.AdoptOnly() {
+		logx.Infof("[vpnrd] event=singbox_config_changed path=%q sing-box not owned by vpnrd; not restarting", path)
+		return
+	}
+
+	logx.Infof("[vpnrd] event=singbox_config_changed path=%q restarting owned sing-box", path)
+	w.restartOwned(ctx, "config change")
+}
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"context"
+	"os"
+	"strconv"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/hooks"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+)
+
+// singBoxOwner is who held the tunnel at the last reconcile.
+type singBoxOwner struct {
+	known bool // false until the first reconcile, and after vpnrd itself restarted sing-box
+	pid   int  // 0 = no sing-box running
+	owned bool // pid came from our pidfile
+}
+
+// reconcileOwner notices sing-box being replaced behind vpnrd's back, e.g. an
+// external tool killing the owned instance and starting its own. The change is
+// logged; an external replacement is re-adopted when sing_box_manage_mode
+// allows it (its stale pidfile is dropped so later steps treat it as external).
+func (w *Watchdog) reconcileOwner(ctx context.Context) {
+	cfg := w.cfg
+	var cur singBoxOwner
+	cur.known = true
+	if sb, _ := singboxctl.Inspect(cfg); sb != nil && sb.Running {
+		cur.pid, cur.owned = sb.PID, true
+	} else if ext, _ := singboxctl.InspectExternal(ctx, cfg); ext != nil && ext.Running {
+		cur.pid = ext.PID
+	}
+
+	prev := w.owner
+	w.owner = cur
+	if !prev.known || prev == cur {
+		return
+	}
+
+	switch {
+	case prev.owned && cur.pid != 0 && !cur.owned:
+		logx.Warnf("[vpnrd] event=singbox_ownership_lost owned_pid=%d external_pid=%d lost ownership of sing-box; external pid=%d now holds tunnel",
+			prev.pid, cur.pid, cur.pid)
+		if cfg.AdoptExternal() {
+			if !control.DryRun() {
+				_ = os.Remove(cfg.SingBoxPidFile)
+			}
+			logx.Infof("[vpnrd] event=singbox_adopted pid=%d (sing_box_manage_mode=%s)", cur.pid, cfg.SingBoxManageMode)
+		} else {
+			logx.Infof("[vpnrd] sing_box_manage_mode=%s: not adopting; the next recovery starts an owned sing-box", cfg.SingBoxManageMode)
+		}
+	case prev.owned && cur.owned:
+		logx.Infof("[vpnrd] event=singbox_pid_changed from=%d to=%d owned sing-box restarted outside vpnrd", prev.pid, cur.pid)
+	case prev.pid != 0 && cur.pid == 0:
+		logx.Warnf("[vpnrd] event=singbox_gone pid=%d owned=%t sing-box is no longer running", prev.pid, prev.owned)
+		_ = hooks.Run(ctx, cfg, hooks.OnTunnelDown, hooks.Vars{
+			"singbox_pid": strconv.Itoa(prev.pid),
+			"owned":       strconv.FormatBool(prev.owned),
+			"wan_if":      w.wan,
+			"lan_if":      w.lan,
+		})
+	case cur.pid != 0 && !cur.owned:
+		logx.Infof("[vpnrd] event=singbox_external pid=%d external sing-box now holds the tunnel", cur.pid)
+	}
+}
@@ -0,0 +1,41 @@
+package router
+
+import "time"
+
+// slidingWindow allows at most max events in any window-long span (max <= 0 = unlimited).
+type slidingWindow struct {
+	max    int
+	window time.Duration
+	times  []time.Time // allowed events inside the current window, oldest first
+}
+
+// allow records an event at now and reports true, or reports false (recording
+// nothing) if the window is already full.
+func (sw *slidingWindow) allow(now time.Time) bool {
+	if sw.max <= 0 {
+		return true
+	}
+	sw.expire(now)
+	if len(sw.times) >= sw.max {
+		return false
+	}
+	sw.times = append(sw.times, now)
+	return true
+}
+
+// retryAt is when the next event will be allowed (zero if one is allowed now).
+func (sw *slidingWindow) retryAt(now time.Time) time.Time {
+	sw.expire(now)
+	if sw.max <= 0 || len(sw.times) < sw.max {
+		return time.Time{}
+	}
+	return sw.times[0].Add(sw.window)
+}
+
+func (sw *slidingWindow) expire(now time.Time) {
+	i := 0
+	for i < len(sw.times) && now.Sub(sw.times[i]) >= sw.window {
+		i++
+	}
+	sw.times = sw.times[i:]
+}
@@ -0,0 +1,51 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	t0 := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	sw := slidingWindow{max: 2, window: 10 * time.Minute}
+
+	steps := []struct {
+		at      time.Duration // since t0
+		allowed bool
+		retryAt time.Duration // after the step; -1 = allowed now
+	}{
+		{at: 0, allowed: true, retryAt: -1},
+		{at: time.Minute, allowed: true, retryAt: 10 * time.Minute},
+		{at: 2 * time.Minute, allowed: false, retryAt: 10 * time.Minute},
+		{at: 9*time.Minute + 59*time.Second, allowed: false, retryAt: 10 * time.Minute},
+		{at: 10 * time.Minute, allowed: true, retryAt: 11 * time.Minute}, // the first one expired
+		{at: 10*time.Minute + time.Second, allowed: false, retryAt: 11 * time.Minute},
+		{at: 30 * time.Minute, allowed: true, retryAt: -1},
+	}
+	for _, s := range steps {
+		now := t0.Add(s.at)
+		if got := sw.allow(now); got != s.allowed {
+			t.Fatalf("t+%s: allow = %v, want %v", s.at, got, s.allowed)
+		}
+		want := time.Time{}
+		if s.retryAt >= 0 {
+			want = t0.Add(s.retryAt)
+		}
+		if got := sw.retryAt(now); !got.Equal(want) {
+			t.Fatalf("t+%s: retryAt = %s, want %s", s.at, got, want)
+		}
+	}
+}
+
+func TestSlidingWindowUnlimited(t *testing.T) {
+	sw := slidingWindow{max: 0, window: time.Minute}
+	now := time.Now()
+	for range 100 {
+		if !sw.allow(now) {
+			t.Fatal("max 0 must never limit")
+		}
+	}
+	if !sw.retryAt(now).IsZero() {
+		t.Fatal("retryAt must be zero without a limit")
+	}
+}
@@ -0,0 +1,227 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/debugdump"
+	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/pf"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+)
+
+// verifyFunc probes the tunnel right after pf_apply (nil = no post-check).
+type verifyFunc func(ctx context.Context, timeout time.Duration) healthcheck.Result
+
+// doRecovery restarts an owned sing-box (re-adopts an external one), re-applies
+// pf for its utun and, with verify, checks the egress. It returns the sing-box
+// it ended up on (a Planned one in dry-run mode).
+func doRecovery(ctx context.Context, cfg *config.Config, effectiveWAN, effectiveLAN string, verify verifyFunc) (*singboxctl.Status, error) {
+	sb0, _ := singboxctl.Inspect(cfg)
+	debugdump.Dump("singbox_before_recover", sb0)
+
+	// Only restart if we own it. Never kill an external sing-box.
+	// In adopt_only mode sing-box is someone else's: just re-adopt it and reapply pf.
+	if sb0 != nil && sb0.OwnedByUs && !cfg.AdoptOnly() {
+		if err := singboxctl.StopIfOwned(ctx, cfg); err != nil {
+			return nil, fmt.Errorf("stop sing-box (owned): %w", err)
+		}
+	}
+
+	sb, err := singboxctl.EnsureRunning(ctx, cfg, cfg.SingBoxStartTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("ensure sing-box: %w", err)
+	}
+	debugdump.Dump("singbox_after_ensure", sb)
+	if sb == nil || !sb.Running || sb.NewUTUN == "" {
+		return nil, singboxctl.ErrNoTunnel
+	}
+
+	if err := applyPF(ctx, cfg, sb, effectiveWAN, effectiveLAN); err != nil {
+		return nil, err
+	}
+	if verify == nil || control.DryRun() {
+		return sb, nil
+	}
+
+	// "Recovered" must mean traffic egresses correctly through the new utun.
+	h := verify(ctx, cfg.RecoverVerifyTimeout)
+	debugdump.Dump("health_recover_verify", h)
+	if err := ctx.Err(); err != nil {
+		// Cut short by shutdown: no verdict on the new tunnel, so no rollback.
+		return sb, err
+	}
+	if h.OK {
+		return sb, nil
+	}
+	rollbackRecovery(ctx, cfg, sb)
+	return nil, fmt.Errorf("post-recovery check on %s: %w", sb.NewUTUN, h.Failure())
+}
+
+// rollbackRecovery undoes an unverified recovery: the owned sing-box is stopped
+// (an adopted one is left alone) and, with recover_verify_down, the down script runs.
+func rollbackRecovery(ctx context.Context, cfg *config.Config, sb *singboxctl.Status) {
+	if sb.OwnedByUs && !cfg.AdoptOnly() {
+		if err := singboxctl.StopIfOwned(ctx, cfg); err != nil {
+			logx.Warnf("rollback: stop sing-box: %v", err)
+		}
+	}
+	if cfg.RecoverVerifyDown {
+		if _, err := control.RunPrivileged(ctx, cfg.VPNRouterDownPath, cfg.DownTimeout); err != nil {
+			logx.Warnf("rollback: down: %v", err)
+		}
+	}
+}
+
+// applyPF re-runs the pf apply script for the utun sing-box just came up on.
+func applyPF(ctx context.Context, cfg *config.Config, sb *singboxctl.Status, effectiveWAN, effectiveLAN string) error {
+	if cfg.PFManaged {
+		return loadAnchor(ctx, cfg, sb, effectiveWAN, effectiveLAN)
+	}
+	args := pfApplyArgs(ctx, cfg, sb, effectiveWAN, effectiveLAN)
+	attempts := 1 + cfg.PFApplyRetries()
+	for i := 1; ; i++ {
+		res, err := control.RunPrivileged(ctx, cfg.VPNRouterPFApplyPath, cfg.PFApplyTimeout, args...)
+		if err == nil {
+			return nil
+		}
+		if res != nil && !res.TimedOut && res.Stderr != "" {
+			// The script ran and rejected its input; its stderr says why. Retrying won't help.
+			return fmt.Errorf("pf_apply: %w: %s", err, res.Stderr)
+		}
+		if res == nil || !res.TimedOut || i >= attempts || ctx.Err() != nil {
+			return fmt.Errorf("pf_apply: %w", err)
+		}
+		logx.Warnf("[vpnrd] event=pf_apply_timeout attempt=%d/%d timeout=%s retrying", i, attempts, cfg.PFApplyTimeout)
+	}
+}
+
+// loadAnchor is applyPF for pf_managed: the rules template is rendered for the
+// new utun and loaded into pf_anchor.
+func loadAnchor(ctx context.Context, cfg *config.Config, sb *singboxctl.Status, effectiveWAN, effectiveLAN string) error {
+	servers := pfServerIPs(ctx, cfg)
+	if len(servers) == 0 {
+		// Without an allowlist the kill switch would also block the tunnel itself.
+		return fmt.Errorf("pf anchor %s: vpn server list is empty (vpn_server_ips)", cfg.PFAnchor)
+	}
+	v := pf.Vars{
+		Utun:     sb.NewUTUN,
+		WAN:      strings.TrimSpace(effectiveWAN),
+		LAN:      strings.TrimSpace(effectiveLAN),
+		LANCIDR:  cfg.LANCIDR,
+		TunIP:    sb.TunIPv4,
+		TunIP6:   sb.TunIPv6,
+		TunCIDR:  sb.TunCIDR,
+		AllowNTP: cfg.AllowWANNTP,
+	}
+	v.VPNServersV4, v.VPNServersV6 = splitFamilies(servers)
+	v.WANDNSV4, v.WANDNSV6 = splitFamilies(cfg.WANDNSIPs)
+	rules, err := pf.Render(cfg.PFRulesTemplate, v)
+	if err != nil {
+		return err
+	}
+	logx.Debugf("[vpnrd] pf anchor %s rules:\n%s", cfg.PFAnchor, rules)
+	pctx, cancel := context.WithTimeout(ctx, cfg.PFApplyTimeout)
+	defer cancel()
+	return pf.LoadAnchor(pctx, cfg.PFAnchor, rules)
+}
+
+// pfApplyArgs builds the key=value arguments for the pf apply script. Newer
+// arguments (tun_* addresses, per-family lists) are appended so scripts reading
+// positional arguments keep working.
+func pfApplyArgs(ctx context.Context, cfg *config.Config, sb *singboxctl.Status, effectiveWAN, effectiveLAN string) []string {
+	servers := pfServerIPs(ctx, cfg)
+	vpn4, vpn6 := splitFamilies(servers)
+	dns4, dns6 := splitFamilies(cfg.WANDNSIPs)
+	return []string{
+		fmt.Sprintf("utun=%s", sb.NewUTUN),
+		fmt.Sprintf("wan=%s", strings.TrimSpace(effectiveWAN)),
+		fmt.Sprintf("lan=%s", strings.TrimSpace(effectiveLAN)),
+		fmt.Sprintf("vpn_server_ips=%q", strings.Join(servers, ",")),
+		fmt.Sprintf("wan_dns=%q", strings.Join(cfg.WANDNSIPs, ",")),
+		fmt.Sprintf("allow_ntp=%t", cfg.AllowWANNTP),
+		fmt.Sprintf("tun_ip=%s", sb.TunIPv4),
+		fmt.Sprintf("tun_ip6=%s", sb.TunIPv6),
+		fmt.Sprintf("tun_cidr=%s", sb.TunCIDR),
+		fmt.Sprintf("vpn_server_ips_v4=%q", strings.Join(vpn4, ",")),
+		fmt.Sprintf("vpn_server_ips_v6=%q", strings.Join(vpn6, ",")),
+		fmt.Sprintf("wan_dns_v4=%q", strings.Join(dns4, ",")),
+		fmt.Sprintf("wan_dns_v6=%q", strings.Join(dns6, ",")),
+	}
+}
+
+// pfServerIPs is vpn_server_ips plus, with vpn_server_ips_from_singbox, the
+// active sing-box config's outbound servers. A server that fails to resolve is
+// logged and skipped; the configured list is always kept.
+func pfServerIPs(ctx context.Context, cfg *config.Config) []string {
+	if !cfg.VPNServerIPsFromSingBox {
+		return cfg.VPNServerIPs
+	}
+	rctx, cancel := context.WithTimeout(ctx, cfg.CommandTimeout)
+	defer cancel()
+	derived, err := singboxctl.ServerIPs(rctx, cfg.SingBoxConfigPath)
+	if err != nil {
+		logx.Warnf("[vpnrd] event=singbox_servers_partial path=%q err=%v", cfg.SingBoxConfigPath, err)
+	}
+	out := append([]string(nil), cfg.VPNServerIPs...)
+	for _, ip := range derived {
+		if !slices.Contains(out, ip) {
+			out = append(out, ip)
+		}
+	}
+	return out
+}
+
+// splitFamilies separates IPs (or CIDRs) into IPv4 and IPv6; pf rules differ by
+// family (inet vs inet6). Unparseable entries are dropped here; the combined
+// lists still carry them verbatim.
+func splitFamilies(addrs []string) (v4, v6 []string) {
+	for _, a := range addrs {
+		a = strings.TrimSpace(a)
+		ip := net.ParseIP(a)
+		if ip == nil {
+			var err error
+			if ip, _, err = net.ParseCIDR(a); err != nil {
+				continue
+			}
+		}
+		if ip.To4() != nil {
+			v4 = append(v4, a)
+		} else {
+			v6 = append(v6, a)
+		}
+	}
+	return v4, v6
+}
+
+// doFailover switches to the next sing-box endpoint (singbox_configs) and recovers on it.
+// The owned sing-box is stopped first; otherwise EnsureRunning would keep the old endpoint.
+func doFailover(ctx context.Context, cfg *config.Config, effectiveWAN, effectiveLAN string, verify verifyFunc) (*singboxctl.Status, error) {
+	if cfg.AdoptOnly() {
+		logx.Warnf("failover skipped: sing_box_manage_mode is adopt_only (vpnrd can't switch endpoints)")
+		return doRecovery(ctx, cfg, effectiveWAN, effectiveLAN, verify)
+	}
+	from := cfg.SingBoxConfigPath
+	if err := singboxctl.StopIfOwned(ctx, cfg); err != nil {
+		return nil, fmt.Errorf("stop sing-box (owned) for failover: %w", err)
+	}
+
+	cfg.UseEndpoint(cfg.Endpoint() + 1)
+	logx.Infof("[vpnrd] event=failover endpoint=%d/%d from=%q to=%q expected_ips=%v",
+		cfg.Endpoint()+1, cfg.Endpoints(), from, cfg.SingBoxConfigPath, cfg.VPNServerIPs)
+	debugdump.Dump("failover", map[string]any{
+		"from":         from,
+		"to":           cfg.SingBoxConfigPath,
+		"endpoint":     cfg.Endpoint(),
+		"expected_ips": cfg.VPNServerIPs,
+	})
+
+	return doRecovery(ctx, cfg, effectiveWAN, effectiveLAN, verify)
+}
@@ -0,0 +1,254 @@
+// Package router brings the VPN router up and down and runs the watchdog that
+// keeps it healthy. It is the engine behind the vpnrd commands and the public
+// pkg/vpnrouter API; neither prints anything itself beyond the log.
+package router
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+	"github.com/revolver-sys/vpn-router-daemon/internal/hooks"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/netdetect"
+	"github.com/revolver-sys/vpn-router-daemon/internal/pf"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+	"github.com/revolver-sys/vpn-router-daemon/internal/utun"
+)
+
+// Options are the per-run values the CLI may override on top of the config.
+type Options struct {
+	ConfigPath    string // reported in status snapshots
+	WAN, LAN      string // interfaces; "" lets Up parse them from the setup script's output
+	HealthURL     string
+	HealthTimeout time.Duration
+}
+
+// DefaultOptions takes every option from cfg, detecting the WAN interface when
+// wan_auto_detect is set.
+func DefaultOptions(ctx context.Context, cfg *config.Config) Options {
+	wan := cfg.WANIF
+	if cfg.WANAutoDetect {
+		wan = DetectWAN(ctx, cfg)
+	}
+	return Options{
+		ConfigPath:    cfg.Path,
+		WAN:           wan,
+		LAN:           cfg.LANIF,
+		HealthURL:     cfg.HealthCheckURL,
+		HealthTimeout: cfg.HealthTimeout,
+	}
+}
+
+// Configure applies the process-wide settings derived from cfg (health probe
+// options, ignored utuns, use_sudo). Call it once after loading the config.
+func Configure(cfg *config.Config) {
+	healthcheck.Configure(HealthOptions(cfg))
+	if cfg.UseSudo {
+		control.SetSudo(cfg.SudoPath)
+	}
+	if ig, err := utun.ParseIgnore(cfg.IgnoreUTUNs); err == nil {
+		singboxctl.SetIgnoreUTUNs(ig)
+	}
+}
+
+// HealthOptions maps config to the process-wide health probe options.
+func HealthOptions(cfg *config.Config) healthcheck.Options {
+	return healthcheck.Options{
+		FollowRedirects:   cfg.HealthFollowRedirects,
+		CaptivePortalURL:  cfg.CaptivePortalURL,
+		ProxyURL:          cfg.HealthCheckProxy,
+		SourceAddr:        strings.TrimSpace(cfg.HealthCheckSource),
+		MinTLSVersion:     healthcheck.TLSVersions[cfg.HealthMinTLSVersion],
+		TLSPins:           cfg.HealthTLSPins,
+		Retries:           cfg.HealthRetries(),
+		MaxBody:           cfg.HealthMaxBody,
+		FullBody:          cfg.HealthCheckFullBody,
+		ExpectSubstring:   cfg.HealthExpectSubstring,
+		ExpectContentType: cfg.HealthExpectContentType,
+		UserAgent:         cfg.HealthUserAgent,
+		FreshConnection:   cfg.HealthFreshConnection,
+		ExpectHeader:      cfg.HealthExpectHeader.Name,
+		ExpectHeaderValue: cfg.HealthExpectHeader.Value,
+		IPFamily:          cfg.HealthIPFamily,
+		IPv6URL:           cfg.HealthCheckIPv6URL,
+		ExpectedIPv6:      cfg.ExpectedEgressIPv6,
+	}
+}
+
+// DetectWAN returns the default route's interface (wan_auto_detect), or wan_if
+// when detection fails.
+func DetectWAN(ctx context.Context, cfg *config.Config) string {
+	ctx, cancel := context.WithTimeout(ctx, cfg.CommandTimeout)
+	defer cancel()
+	wan, err := netdetect.DefaultWAN(ctx)
+	if err != nil {
+		logx.Warnf("[vpnrd] event=wan_detect_failed fallback=%q err=%v", cfg.WANIF, err)
+		return cfg.WANIF
+	}
+	logx.Infof("[vpnrd] event=wan_detected wan_if=%s configured=%q", wan, cfg.WANIF)
+	return wan
+}
+
+// UpResult is what Up did: the script runs and the sing-box it ended up on.
+type UpResult struct {
+	Setup, PFApply *control.Result // PFApply is nil with pf_managed
+	SingBox        *singboxctl.Status
+	WAN, LAN       string // effective interfaces
+}
+
+// Up runs setup, makes sure sing-box is running and applies pf for its utun.
+// On a script failure the error carries the script's output.
+func Up(ctx context.Context, cfg *config.Config, opts Options) (*UpResult, error) {
+	if err := hooks.Run(ctx, cfg, hooks.PreUp, hooks.Vars{"wan_if": opts.WAN, "lan_if": opts.LAN}); err != nil {
+		return nil, err
+	}
+	up := &UpResult{}
+
+	// 0) Setup LAN + dnsmasq + pf anchors (slow). This script may have its own WAN/LAN defaults.
+	setupRes, err := control.RunPrivileged(ctx, cfg.VPNRouterSetupPath, cfg.UpTimeout)
+	up.Setup = setupRes
+	if err != nil {
+		return up, formatScriptFailure("setup", setupRes, err)
+	}
+
+	// If WAN/LAN were not provided (config.yaml commented out), try to parse them from setup stdout.
+	effectiveWAN := strings.TrimSpace(opts.WAN)
+	effectiveLAN := strings.TrimSpace(opts.LAN)
+	if effectiveWAN == "" || effectiveLAN == "" {
+		// Example line: "WAN: en5  LAN: en8"
+		re := regexp.MustCompile(`(?m)^WAN:\s*(\S+)\s+LAN:\s*(\S+)\s*$`)
+		if m := re.FindStringSubmatch(setupRes.Stdout); len(m) == 3 {
+			if effectiveWAN == "" {
+				effectiveWAN = m[1]
+			}
+			if effectiveLAN == "" {
+				effectiveLAN = m[2]
+			}
+		}
+	}
+	if control.DryRun() && (effectiveWAN == "" || effectiveLAN == "") {
+		// setup did not actually run, so there is no stdout to parse.
+		if effectiveWAN == "" {
+			effectiveWAN = "<wan_if>"
+		}
+		if effectiveLAN == "" {
+			effectiveLAN = "<lan_if>"
+		}
+	}
+	if effectiveWAN == "" || effectiveLAN == "" {
+		return up, fmt.Errorf("wan_if/lan_if not set (config %q). Set them in config.yaml or pass --wan/--lan", opts.ConfigPath)
+	}
+	up.WAN, up.LAN = effectiveWAN, effectiveLAN
+
+	// 1) Ensure sing-box is running (Policy B adoption supported) and get the tunnel interface.
+	st := &singboxctl.Status{}
+	if cfg.SingBoxAutoStart {
+		st, err = singboxctl.EnsureRunning(ctx, cfg, cfg.SingBoxStartTimeout)
+		if err != nil {
+			return up, fmt.Errorf("sing-box ensure running: %w", err)
+		}
+		logx.Infof("[vpnrd] sing-box status: pid=%d owned=%t utun=%s", st.PID, st.OwnedByUs, st.TunLabel())
+	}
+	up.SingBox = st
+	tun := st.NewUTUN
+	if tun == "" {
+		return up, fmt.Errorf("%w (sing-box auto-start disabled or failed)", singboxctl.ErrNoTunnel)
+	}
+
+	// 2) Apply pf NAT + kill-switch rules (fast).
+	if cfg.PFManaged {
+		if err := loadAnchor(ctx, cfg, st, effectiveWAN, effectiveLAN); err != nil {
+			return up, err
+		}
+	} else {
+		args := pfApplyArgs(ctx, cfg, st, effectiveWAN, effectiveLAN)
+		logx.Debugf("[vpnrd] pf_apply args: %s", strings.Join(args, " "))
+		res, err := control.RunPrivileged(ctx, cfg.VPNRouterPFApplyPath, cfg.PFApplyTimeout, args...)
+		up.PFApply = res
+		if err != nil {
+			return up, formatScriptFailure("pf_apply", res, err)
+		}
+	}
+
+	logx.Infof("[vpnrd] router UP; utun=%s", st.TunLabel())
+
+	return up, hooks.Run(ctx, cfg, hooks.PostUp, hooks.Vars{
+		"utun": tun, "wan_if": effectiveWAN, "lan_if": effectiveLAN,
+	})
+}
+
+// Down stops an owned sing-box and runs the down script to restore the
+// router state. It returns the down script's result.
+func Down(ctx context.Context, cfg *config.Config) (*control.Result, error) {
+	if err := hooks.Run(ctx, cfg, hooks.PreDown, nil); err != nil {
+		return nil, err
+	}
+
+	// 0) Stop sing-box if vpnrd owns it (never in adopt_only mode)
+	if !cfg.AdoptOnly() {
+		if err := singboxctl.StopIfOwned(ctx, cfg); err != nil {
+			return nil, fmt.Errorf("sing-box stop: %w", err)
+		}
+	}
+
+	// 1) Drop the managed rules; the down script restores the rest.
+	if cfg.PFManaged {
+		if err := pf.FlushAnchor(ctx, cfg.PFAnchor); err != nil {
+			return nil, err
+		}
+	}
+
+	// 2) Restore router state
+	res, err := control.RunPrivileged(ctx, cfg.VPNRouterDownPath, cfg.DownTimeout)
+	if err != nil {
+		return res, formatScriptFailure("down", res, err)
+	}
+
+	return res, hooks.Run(ctx, cfg, hooks.PostDown, nil)
+}
+
+// Recover runs one watchdog recovery: restart an owned sing-box (re-adopt
+// an external one) and re-apply pf for its utun. Unlike the watchdog it does
+// not verify the egress afterwards; it returns the sing-box it ended up on
+// (in dry-run mode, the Planned one).
+func Recover(ctx context.Context, cfg *config.Config, opts Options) (*singboxctl.Status, error) {
+	planned, err := doRecovery(ctx, cfg, opts.WAN, opts.LAN, nil)
+	if err != nil {
+		return nil, err
+	}
+	if planned.Planned {
+		return planned, nil
+	}
+	sb, _ := singboxctl.Inspect(cfg)
+	if sb == nil || !sb.Running {
+		sb, _ = singboxctl.InspectExternal(ctx, cfg)
+	}
+	singboxctl.ResolveTun(cfg, sb)
+	return sb, nil
+}
+
+func formatScriptFailure(tag string, res *control.Result, err error) error {
+	// Build a rich error message that includes captured outputs.
+	if res == nil {
+		return fmt.Errorf("%s: %w", tag, err)
+	}
+
+	// %w keeps the control.ExitError reachable via errors.As.
+	detail := fmt.Sprintf(" (exit=%d)", res.ExitCode)
+	if res.TimedOut {
+		detail = " (killed at timeout; raise the script's *_timeout if it is just slow)"
+	}
+	if res.Stdout != "" {
+		detail += "\nstdout:\n" + res.Stdout
+	}
+	if res.Stderr != "" {
+		detail += "\nstderr:\n" + res.Stderr
+	}
+	return fmt.Errorf("%s failed: %w%s", tag, err, detail)
+}
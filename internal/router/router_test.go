@@ -0,0 +1,87 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/pf"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+)
+
+// slowScript writes a script that sleeps far longer than any timeout under test.
+func slowScript(t *testing.T) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "slow.sh")
+	if err := os.WriteFile(p, []byte("#!/bin/sh\nexec sleep 30\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// The per-script timeout, not command_timeout, bounds each script.
+func TestScriptTimeoutsReachRunScript(t *testing.T) {
+	script := slowScript(t)
+	cfg := &config.Config{
+		VPNRouterSetupPath: script,
+		VPNRouterDownPath:  script,
+		SingBoxPidFile:     filepath.Join(t.TempDir(), "singbox.pid"),
+		CommandTimeout:     time.Minute,
+		UpTimeout:          200 * time.Millisecond,
+		DownTimeout:        300 * time.Millisecond,
+	}
+
+	start := time.Now()
+	up, err := Up(context.Background(), cfg, Options{WAN: "en0", LAN: "en8"})
+	if err == nil || up == nil || up.Setup == nil || !up.Setup.TimedOut {
+		t.Fatalf("Up: err = %v, result %+v; want the setup script timed out", err, up)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("Up took %s; up_timeout (200ms) was not applied", took)
+	}
+
+	start = time.Now()
+	res, err := Down(context.Background(), cfg)
+	if err == nil || res == nil || !res.TimedOut {
+		t.Fatalf("Down: err = %v, result %+v; want the down script timed out", err, res)
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("Down took %s; down_timeout (300ms) was not applied", took)
+	}
+}
+
+// A dry-run restart plans the sing-box start instead of failing on ErrNoTunnel.
+func TestRecoverDryRun(t *testing.T) {
+	control.SetDryRun(true)
+	singboxctl.SetDryRun(true)
+	pf.SetDryRun(true)
+	t.Cleanup(func() {
+		control.SetDryRun(false)
+		singboxctl.SetDryRun(false)
+		pf.SetDryRun(false)
+	})
+
+	missing := filepath.Join(t.TempDir(), "must-not-run.sh")
+	cfg := &config.Config{
+		VPNRouterPFApplyPath: missing,
+		SingBoxPath:          missing,
+		SingBoxConfigPath:    filepath.Join(t.TempDir(), "sb.json"),
+		SingBoxPidFile:       filepath.Join(t.TempDir(), "singbox.pid"),
+		CommandTimeout:       time.Second,
+		PFApplyTimeout:       time.Second,
+	}
+	sb, err := Recover(context.Background(), cfg, Options{WAN: "en0", LAN: "en8"})
+	if err != nil {
+		t.Fatalf("dry-run recover: %v", err)
+	}
+	if !sb.Planned || !sb.Running || sb.NewUTUN == "" || sb.PID != 0 {
+		t.Fatalf("status = %+v, want a planned sing-box", sb)
+	}
+	if _, err := os.Stat(cfg.SingBoxPidFile); !os.IsNotExist(err) {
+		t.Fatalf("dry run wrote the pidfile (stat err %v)", err)
+	}
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/atomicfile"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+)
+
+// stateFileName is the watchdog state file inside state_dir.
+const stateFileName = "watchdog.json"
+
+// errStateStale: the saved state is older than state_max_age.
+var errStateStale = errors.New("watchdog state is stale")
+
+// watchdogState is the part of a Watchdog that survives a restart (state_dir):
+// the failure counters and the recovery rate-limit window, so a restarted
+// daemon picks up an in-progress backoff instead of retrying a known-down
+// server right away.
+type watchdogState struct {
+	SavedAt           time.Time   `json:"saved_at"`
+	SingBoxConfigPath string      `json:"singbox_config_path"` // endpoint the counters refer to
+	ConsecutiveFails  int         `json:"consecutive_failures"`
+	Recoveries        int         `json:"recoveries"`
+	FailedRecoveries  int         `json:"failed_recoveries"`
+	RecoveryFailures  int         `json:"recovery_failures"`
+	RecoveryTimes     []time.Time `json:"recovery_times,omitempty"` // max_recoveries_per_window
+	LastEgressIP      string      `json:"last_egress_ip,omitempty"`
+}
+
+// saveState writes s to path atomically.
+func saveState(path string, s watchdogState) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal watchdog state: %w", err)
+	}
+	return atomicfile.Write(path, append(b, '\n'), 0o644)
+}
+
+// loadState reads the state saved at path; state saved more than maxAge ago
+// is reported as errStateStale.
+func loadState(path string, maxAge time.Duration) (watchdogState, error) {
+	var s watchdogState
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("parse watchdog state %q: %w", path, err)
+	}
+	if age := time.Since(s.SavedAt); age > maxAge {
+		return s, fmt.Errorf("%w (saved %s ago, state_max_age=%s)", errStateStale, age.Round(time.Second), maxAge)
+	}
+	return s, nil
+}
+
+func (w *Watchdog) statePath() string {
+	if w.cfg.StateDir == "" {
+		return ""
+	}
+	return filepath.Join(w.cfg.StateDir, stateFileName)
+}
+
+// state captures what saveState persists.
+func (w *Watchdog) state() watchdogState {
+	return watchdogState{
+		SavedAt:           time.Now(),
+		SingBoxConfigPath: w.cfg.SingBoxConfigPath,
+		ConsecutiveFails:  w.consecutiveFails,
+		Recoveries:        w.recoveries,
+		FailedRecoveries:  w.failedRecoveries,
+		RecoveryFailures:  w.recoveryFailures,
+		RecoveryTimes:     w.recoveryLimit.times,
+		LastEgressIP:      w.lastEgressIP,
+	}
+}
+
+// saveState persists the watchdog state (state_dir); failures are only logged.
+func (w *Watchdog) saveState() {
+	path := w.statePath()
+	if path == "" {
+		return
+	}
+	if err := saveState(path, w.state()); err != nil {
+		logx.Warnf("watchdog state: %v", err)
+	}
+}
+
+// restoreState loads the state a previous run saved (state_dir). Missing,
+// unreadable or stale state leaves the watchdog fresh.
+func (w *Watchdog) restoreState() {
+	path := w.statePath()
+	if path == "" {
+		return
+	}
+	s, err := loadState(path, w.cfg.StateMaxAge)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return
+	case err != nil:
+		logx.Infof("[vpnrd] event=state_ignored path=%s reason=%v", path, err)
+		return
+	}
+	if i := slices.Index(w.cfg.SingBoxConfigs, s.SingBoxConfigPath); i >= 0 && i != w.cfg.Endpoint() {
+		// A failover happened before the restart: stay on the endpoint that
+		// worked (and that the running sing-box uses), not the config's first.
+		w.cfg.UseEndpoint(i)
+		logx.Infof("[vpnrd] event=endpoint_restored endpoint=%d/%d singbox_config=%q", i+1, w.cfg.Endpoints(), w.cfg.SingBoxConfigPath)
+	}
+	if s.SingBoxConfigPath != w.cfg.SingBoxConfigPath {
+		// Counters for another endpoint say nothing about this one.
+		s.ConsecutiveFails, s.FailedRecoveries = 0, 0
+	}
+	w.consecutiveFails = s.ConsecutiveFails
+	w.recoveries = s.Recoveries
+	w.failedRecoveries = s.FailedRecoveries
+	w.recoveryFailures = s.RecoveryFailures
+	w.recoveryLimit.times = s.RecoveryTimes
+	w.recoveryLimit.expire(time.Now())
+	w.lastEgressIP = s.LastEgressIP
+	logx.Infof("[vpnrd] event=state_restored path=%s saved=%s consecutive_failures=%d recoveries=%d recovery_failures=%d recent_recoveries=%d last_egress_ip=%s",
+		path, s.SavedAt.Format(time.RFC3339), w.consecutiveFails, w.recoveries, w.recoveryFailures,
+		len(w.recoveryLimit.times), cmp.Or(w.lastEgressIP, "none"))
+}
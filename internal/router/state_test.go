@@ -0,0 +1,143 @@
+package router
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), stateFileName)
+	now := time.Now().Truncate(time.Second)
+	want := watchdogState{
+		SavedAt:           now,
+		SingBoxConfigPath: "/etc/sing-box/a.json",
+		ConsecutiveFails:  3,
+		Recoveries:        7,
+		FailedRecoveries:  2,
+		RecoveryFailures:  4,
+		RecoveryTimes:     []time.Time{now.Add(-time.Minute), now},
+		LastEgressIP:      "203.0.113.7",
+	}
+	if err := saveState(path, want); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadState(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.SavedAt.Equal(want.SavedAt) || got.SingBoxConfigPath != want.SingBoxConfigPath ||
+		got.ConsecutiveFails != want.ConsecutiveFails || got.Recoveries != want.Recoveries ||
+		got.FailedRecoveries != want.FailedRecoveries || got.RecoveryFailures != want.RecoveryFailures ||
+		got.LastEgressIP != want.LastEgressIP || len(got.RecoveryTimes) != len(want.RecoveryTimes) {
+		t.Fatalf("loaded %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadState(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "stale.json")
+	if err := saveState(stale, watchdogState{SavedAt: time.Now().Add(-2 * time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	garbage := filepath.Join(dir, "garbage.json")
+	if err := os.WriteFile(garbage, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadState(stale, time.Hour); !errors.Is(err, errStateStale) {
+		t.Errorf("stale state: err = %v, want errStateStale", err)
+	}
+	if _, err := loadState(garbage, time.Hour); err == nil {
+		t.Error("garbage state: no error")
+	}
+	if _, err := loadState(filepath.Join(dir, "missing.json"), time.Hour); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing state: err = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestRestoreState(t *testing.T) {
+	newWatchdog := func(dir, sbConfig string) *Watchdog {
+		return NewWatchdog(&config.Config{
+			StateDir:               dir,
+			StateMaxAge:            time.Hour,
+			SingBoxConfigPath:      sbConfig,
+			MaxRecoveriesPerWindow: 3,
+			RecoveryWindow:         10 * time.Minute,
+		}, Options{})
+	}
+
+	dir := t.TempDir()
+	w := newWatchdog(dir, "a.json")
+	w.consecutiveFails, w.recoveries, w.failedRecoveries, w.recoveryFailures = 3, 5, 2, 2
+	w.lastEgressIP = "203.0.113.7"
+	now := time.Now()
+	// One recovery still inside the window, one long expired.
+	w.recoveryLimit.times = []time.Time{now.Add(-time.Hour), now.Add(-time.Minute)}
+	w.saveState()
+
+	t.Run("same endpoint", func(t *testing.T) {
+		r := newWatchdog(dir, "a.json")
+		r.restoreState()
+		if r.consecutiveFails != 3 || r.recoveries != 5 || r.failedRecoveries != 2 || r.recoveryFailures != 2 {
+			t.Errorf("counters = %d/%d/%d/%d, want 3/5/2/2", r.consecutiveFails, r.recoveries, r.failedRecoveries, r.recoveryFailures)
+		}
+		if r.lastEgressIP != "203.0.113.7" {
+			t.Errorf("last egress = %q", r.lastEgressIP)
+		}
+		if len(r.recoveryLimit.times) != 1 {
+			t.Errorf("recovery window = %v, want only the recent recovery", r.recoveryLimit.times)
+		}
+	})
+	t.Run("other endpoint", func(t *testing.T) {
+		r := newWatchdog(dir, "b.json")
+		r.restoreState()
+		if r.consecutiveFails != 0 || r.failedRecoveries != 0 {
+			t.Errorf("endpoint counters carried over: %d/%d", r.consecutiveFails, r.failedRecoveries)
+		}
+		if r.recoveryFailures != 2 {
+			t.Errorf("recovery failures = %d, want 2 (counted across endpoints)", r.recoveryFailures)
+		}
+	})
+	t.Run("endpoint after a failover", func(t *testing.T) {
+		dir := t.TempDir()
+		newFailover := func() *Watchdog {
+			w := NewWatchdog(&config.Config{
+				StateDir:          dir,
+				StateMaxAge:       time.Hour,
+				SingBoxConfigPath: "a.json",
+				SingBoxConfigs:    []string{"a.json", "b.json"},
+				VPNServerIPGroups: [][]string{{"192.0.2.1"}, {"198.51.100.1"}},
+			}, Options{})
+			w.cfg.UseEndpoint(0) // as a fresh config load does
+			return w
+		}
+		w := newFailover()
+		w.cfg.UseEndpoint(1)
+		w.recoveries = 4
+		w.saveState()
+
+		r := newFailover()
+		r.restoreState()
+		if r.cfg.Endpoint() != 1 || r.cfg.SingBoxConfigPath != "b.json" {
+			t.Fatalf("endpoint = %d (%s), want the failed-over b.json", r.cfg.Endpoint(), r.cfg.SingBoxConfigPath)
+		}
+		if len(r.cfg.VPNServerIPs) != 1 || r.cfg.VPNServerIPs[0] != "198.51.100.1" {
+			t.Errorf("vpn_server_ips = %v, want b.json's group", r.cfg.VPNServerIPs)
+		}
+		if r.recoveries != 4 {
+			t.Errorf("recoveries = %d, want 4", r.recoveries)
+		}
+	})
+	t.Run("no state_dir", func(t *testing.T) {
+		r := newWatchdog("", "a.json")
+		r.restoreState()
+		if r.consecutiveFails != 0 || r.recoveries != 0 {
+			t.Errorf("restored without state_dir")
+		}
+	})
+}
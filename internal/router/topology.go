@@ -0,0 +1,97 @@
+package router
+
+import (
+	"context"
+	"net"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/netdetect"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+)
+
+// Interface is one side of the router as the host sees it now.
+type Interface struct {
+	Name    string   `json:"name"`
+	Addrs   []string `json:"addrs,omitempty"` // CIDRs, e.g. 192.168.50.1/24
+	Gateway string   `json:"gateway,omitempty"`
+	Err     string   `json:"error,omitempty"` // why Addrs is empty (e.g. no such interface)
+}
+
+// TopologyInfo is everything pf is applied with: the WAN and LAN interfaces,
+// the tunnel, the default route and the exact pf_apply arguments.
+type TopologyInfo struct {
+	WAN          Interface        `json:"wan"`
+	LAN          Interface        `json:"lan"`
+	LANCIDR      string           `json:"lan_cidr,omitempty"` // pf_managed NAT source
+	Tunnel       Interface        `json:"tunnel"`
+	SingBoxPID   int              `json:"singbox_pid,omitempty"`
+	SingBoxOwned bool             `json:"singbox_owned,omitempty"`
+	DefaultRoute *netdetect.Route `json:"default_route,omitempty"`
+	RouteErr     string           `json:"default_route_error,omitempty"`
+	PFAnchor     string           `json:"pf_anchor,omitempty"`     // pf_managed
+	PFApplyArgs  []string         `json:"pf_apply_args,omitempty"` // pf_apply script
+}
+
+// Topology collects what Up and recovery would hand to pf right now, for
+// opts' WAN/LAN (detected when wan_auto_detect is set). Nothing is changed.
+func Topology(ctx context.Context, cfg *config.Config, opts Options) TopologyInfo {
+	var t TopologyInfo
+	rctx, cancel := context.WithTimeout(ctx, cfg.CommandTimeout)
+	defer cancel()
+	if r, err := netdetect.DefaultRoute(rctx); err != nil {
+		t.RouteErr = err.Error()
+	} else {
+		t.DefaultRoute = &r
+	}
+
+	t.WAN = ifaceInfo(opts.WAN)
+	if t.DefaultRoute != nil && t.DefaultRoute.Interface == opts.WAN {
+		t.WAN.Gateway = t.DefaultRoute.Gateway
+	}
+	t.LAN = ifaceInfo(opts.LAN)
+
+	sb, _ := singboxctl.Inspect(cfg)
+	if sb == nil || !sb.Running {
+		sb, _ = singboxctl.InspectExternal(ctx, cfg)
+	}
+	singboxctl.ResolveTun(cfg, sb)
+	if sb != nil && sb.Running {
+		t.SingBoxPID, t.SingBoxOwned = sb.PID, sb.OwnedByUs
+	}
+	if sb == nil || sb.NewUTUN == "" {
+		t.Tunnel.Err = singboxctl.ErrNoTunnel.Error()
+		return t
+	}
+	t.Tunnel = ifaceInfo(sb.NewUTUN)
+
+	if cfg.PFManaged {
+		t.PFAnchor, t.LANCIDR = cfg.PFAnchor, cfg.LANCIDR
+	} else {
+		t.PFApplyArgs = pfApplyArgs(ctx, cfg, sb, opts.WAN, opts.LAN)
+	}
+	return t
+}
+
+// ifaceInfo lists name's addresses; an empty name or a missing interface is
+// reported in Err.
+func ifaceInfo(name string) Interface {
+	ifc := Interface{Name: name}
+	if name == "" {
+		ifc.Err = "not configured"
+		return ifc
+	}
+	ni, err := net.InterfaceByName(name)
+	if err != nil {
+		ifc.Err = err.Error()
+		return ifc
+	}
+	addrs, err := ni.Addrs()
+	if err != nil {
+		ifc.Err = err.Error()
+		return ifc
+	}
+	for _, a := range addrs {
+		ifc.Addrs = append(ifc.Addrs, a.String())
+	}
+	return ifc
+}
@@ -0,0 +1,746 @@
+package router
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/admin"
+	"github.com/revolver-sys/vpn-router-daemon/internal/clashapi"
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/debugdump"
+	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+	"github.com/revolver-sys/vpn-router-daemon/internal/hooks"
+	"github.com/revolver-sys/vpn-router-daemon/internal/killswitch"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logdedup"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/metrics"
+	"github.com/revolver-sys/vpn-router-daemon/internal/netdetect"
+	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
+	"github.com/revolver-sys/vpn-router-daemon/internal/status"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+// ErrGaveUp: recovery failed max_consecutive_recovery_failures times in a row
+// and the watchdog stopped.
+var ErrGaveUp = vpnerr.Class(vpnerr.ErrHealthFailed, errors.New("recovery keeps failing; watchdog gave up"))
+
+// Watchdog is the run loop's state; one tick = one health evaluation (+ recovery if needed).
+type Watchdog struct {
+	cfg           *config.Config
+	cfgPath       string
+	healthURL     string
+	healthTimeout time.Duration
+	interval      time.Duration
+	wan, lan      string
+
+	consecutiveFails int
+	recoveries       int
+	failedRecoveries int // consecutive, on the current endpoint
+	recoveryFailures int // consecutive, across endpoints (max_consecutive_recovery_failures)
+	gaveUp           error
+	captivePortal    bool
+	history          *healthcheck.History
+	breaker          healthcheck.Breaker // health_breaker_threshold
+	lastHealth       healthcheck.Result  // republished while the breaker holds probes back
+	recoveryLimit    slidingWindow       // max_recoveries_per_window
+	rateLimited      bool                // last recovery attempt was skipped by recoveryLimit
+	failLog          *logdedup.Logger
+	egress           *healthcheck.EgressResolver // expected_egress_dns
+	lastEgressIP     string                      // from the last healthy check
+	live             status.LiveState            // last published snapshot
+	paused           bool                        // automatic recovery off (admin API)
+	actions          chan admin.Action
+	simulate         admin.Action // injected fault ("vpnrd simulate"); "" = none
+	simulateLeft     int          // checks the fault still applies to
+	configWatch      *configWatch // nil unless watch_singbox_config
+	owner            singBoxOwner
+	graceUntil       time.Time // no health evaluation before this (startup_grace_period)
+	fastChecks       int       // checks left at startup_check_interval
+
+	lastThroughput, lastKillSwitch time.Time
+	throughput                     *healthcheck.ThroughputResult // last throughput probe, published with the status
+
+	clashTotal     int64     // Clash API upload+download at the last look
+	clashIdleSince time.Time // zero while traffic flows (clash_api_idle_window)
+}
+
+// NewWatchdog prepares a watchdog for cfg; nothing runs until Run.
+func NewWatchdog(cfg *config.Config, opts Options) *Watchdog {
+	// Polling interval and per-probe timeout are separate knobs (check_interval vs health_timeout).
+	// A CLI --health-timeout longer than the interval stretches the interval so probes never overlap.
+	interval := cfg.CheckInterval
+	if opts.HealthTimeout > interval {
+		interval = opts.HealthTimeout
+	}
+	return &Watchdog{
+		cfg:           cfg,
+		cfgPath:       opts.ConfigPath,
+		healthURL:     opts.HealthURL,
+		healthTimeout: opts.HealthTimeout,
+		interval:      interval,
+		wan:           opts.WAN,
+		lan:           opts.LAN,
+		history:       healthcheck.NewHistory(cfg.HistorySize),
+		breaker:       healthcheck.Breaker{Threshold: cfg.HealthBreakerThreshold, Interval: cfg.HealthBreakerInterval},
+		recoveryLimit: slidingWindow{max: cfg.MaxRecoveriesPerWindow, window: cfg.RecoveryWindow},
+		failLog:       logdedup.New(cfg.LogDedup),
+		egress:        healthcheck.NewEgressResolver(cfg.ExpectedEgressDNS, cfg.ExpectedEgressDNSRefresh),
+	}
+}
+
+// Run checks health every interval and recovers the tunnel until ctx is
+// canceled, then shuts down (see down_on_exit). A Watchdog runs once.
+func (w *Watchdog) Run(ctx context.Context) error {
+	cfg := w.cfg
+	logx.Infof("watchdog running; interval=%s health_timeout=%s health_url=%s failure_threshold=%d down_on_exit=%t watch_singbox_config=%t",
+		w.interval, w.healthTimeout, w.healthURL, cfg.FailureThreshold, cfg.DownOnExit, cfg.WatchSingBoxConfig)
+
+	// A timer rather than a ticker: the spacing varies (grace period, fast checks).
+	w.startGrace("startup")
+	t := time.NewTimer(w.nextInterval())
+	defer t.Stop()
+
+	// The config poll runs between health ticks; a nil channel never fires.
+	var configPoll <-chan time.Time
+	if cfg.WatchSingBoxConfig && cfg.SingBoxConfigPath != "" {
+		w.configWatch = newConfigWatch(cfg.SingBoxConfigPath)
+		pt := time.NewTicker(configPollInterval)
+		defer pt.Stop()
+		configPoll = pt.C
+	}
+
+	if cfg.AdminListen != "" {
+		w.actions = make(chan admin.Action, 1)
+		srv := &admin.Server{Token: cfg.AdminToken, Live: &w.live, Actions: w.actions}
+		go func() {
+			if err := admin.Serve(ctx, cfg.AdminListen, srv.Handler()); err != nil {
+				logx.Errorf("admin API: %v", err)
+			}
+		}()
+	}
+
+	// Saved every check (publish) and once more on the way out.
+	w.restoreState()
+	defer w.saveState()
+
+	for w.gaveUp == nil {
+		select {
+		case <-ctx.Done():
+			return w.shutdown()
+		case <-configPoll:
+			w.checkSingBoxConfig(ctx)
+		case a := <-w.actions:
+			w.handleAction(ctx, a)
+		case <-t.C:
+			w.tick(ctx)
+			t.Reset(w.nextInterval())
+		}
+	}
+	return w.gaveUp
+}
+
+// startupFastChecks is how many checks after a grace period run at
+// startup_check_interval.
+const startupFastChecks = 3
+
+// startGrace holds off health evaluation for startup_grace_period: right after
+// the tunnel comes up (daemon start, recovery) the first checks tend to fail
+// and would only trigger a pointless recovery.
+func (w *Watchdog) startGrace(reason string) {
+	grace := w.cfg.StartupGracePeriod
+	w.graceUntil = time.Now().Add(grace)
+	w.fastChecks = startupFastChecks
+	logx.Infof("[vpnrd] event=startup_grace after=%s in startup grace period for %s (then %d checks every %s)",
+		reason, grace, startupFastChecks, w.cfg.StartupCheckInterval)
+}
+
+// nextInterval is the wait before the next check: the rest of the grace
+// period, then startup_check_interval for a few checks, then the interval.
+func (w *Watchdog) nextInterval() time.Duration {
+	if d := time.Until(w.graceUntil); d > 0 {
+		return d
+	}
+	if w.fastChecks > 0 {
+		w.fastChecks--
+		return w.cfg.StartupCheckInterval
+	}
+	return w.interval
+}
+
+// Snapshot is the state published after the last check (zero before the first).
+func (w *Watchdog) Snapshot() status.Snapshot {
+	return w.live.Snapshot()
+}
+
+// shutdown runs after a termination signal. The tunnel and pf state are kept
+// unless down_on_exit is set.
+func (w *Watchdog) shutdown() error {
+	if !w.cfg.DownOnExit {
+		logx.Infof("watchdog stopping; leaving tunnel and pf as-is (down_on_exit=false)")
+		return nil
+	}
+	logx.Infof("watchdog stopping; tearing down (down_on_exit=true)")
+	// The run context is already canceled; teardown gets its own.
+	_, err := Down(context.Background(), w.cfg)
+	return err
+}
+
+func (w *Watchdog) tick(ctx context.Context) {
+	cfg := w.cfg
+
+	if time.Now().Before(w.graceUntil) {
+		// A recovery or restart outside the timer (admin API, config change) started one.
+		logx.Debugf("in startup grace period until %s; check skipped", w.graceUntil.Format(time.RFC3339))
+		return
+	}
+	w.checkWAN(ctx)
+	w.reconcileOwner(ctx)
+	if !w.breaker.Allow(time.Now()) {
+		// The health endpoint is down (not the tunnel, as far as we can tell): leave it alone.
+		w.publish(ctx, w.lastHealth)
+		return
+	}
+	h := w.probe(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	w.checkEndpoint(ctx, &h)
+	w.lastHealth = h
+	debugdump.Dump("health", h)
+
+	// Throughput probe runs less often; a slow tunnel counts as a health failure.
+	if h.OK && cfg.ThroughputCheckURL != "" && time.Since(w.lastThroughput) >= cfg.ThroughputInterval {
+		w.lastThroughput = time.Now()
+		tp := healthcheck.CheckThroughput(ctx, cfg.ThroughputCheckURL, cfg.ThroughputMinBPS, cfg.ThroughputTimeout)
+		debugdump.Dump("throughput", tp)
+		w.throughput = &tp
+		if tp.OK {
+			logx.Debugf("throughput ok: %d B/s (%d bytes in %s)", tp.BytesPerSec, tp.Bytes, tp.Duration)
+		} else {
+			h.OK = false
+			h.Err = "throughput: " + tp.Err
+		}
+	}
+	if h.OK {
+		w.checkClashIdle(ctx, &h)
+	}
+
+	w.history.Add(time.Now(), h)
+
+	if h.OK {
+		w.failLog.Flush()
+		if ip := healthcheck.EgressIP(h.Body); ip != "" {
+			w.lastEgressIP = ip
+		}
+		logx.Debugf("health ok: status=%d latency=%s body=%q remote=%s", h.StatusCode, h.Latency, h.Body, h.RemoteAddr)
+		if w.consecutiveFails > 0 {
+			logx.Infof("health recovered after %d fails; body=%q latency=%s", w.consecutiveFails, h.Body, h.Latency)
+		}
+		w.recovered(ctx, h)
+		w.consecutiveFails = 0
+	} else if h.EndpointErr {
+		key := "endpoint|" + h.Err
+		w.failLog.Printf(key, "health endpoint unreachable but network is up (probe infrastructure error; not counted as tunnel failure): err=%q", h.Err)
+	} else {
+		w.consecutiveFails++
+		failed, total := w.history.Failures()
+		// The counter and latency change every tick; the failure itself is what repeats.
+		key := fmt.Sprintf("%d|%s|%s", h.StatusCode, h.Err, h.Body)
+		w.failLog.Printf(key, "health FAIL #%d: status=%d err=%q body=%q remote=%s latency=%s (history: %d/%d failed)",
+			w.consecutiveFails, h.StatusCode, h.Err, h.Body, cmp.Or(h.RemoteAddr, "none"), h.Latency, failed, total)
+
+		// Fired once per failure streak, when the tunnel is declared unhealthy.
+		if w.consecutiveFails == cfg.FailureThreshold {
+			_ = hooks.Run(ctx, cfg, hooks.OnHealthFail, hooks.Vars{
+				"egress_ip":            healthcheck.EgressIP(h.Body),
+				"health_err":           h.Err,
+				"consecutive_failures": strconv.Itoa(w.consecutiveFails),
+				"wan_if":               w.wan,
+				"lan_if":               w.lan,
+			})
+		}
+	}
+
+	// Kill-switch verification: a leak is treated as a failed check so recovery re-applies pf.
+	if cfg.KillSwitchCheck && time.Since(w.lastKillSwitch) >= cfg.KillSwitchInterval {
+		w.lastKillSwitch = time.Now()
+		ks := killswitch.Verify(ctx, w.healthURL, w.healthTimeout, w.wan, w.egress.Expected(ctx, cfg.VPNServerIPs))
+		debugdump.Dump("killswitch", ks)
+		if ks.Leak != "" {
+			logx.Errorf("KILL-SWITCH FAILURE: %s", ks.Leak)
+			if h.OK {
+				w.consecutiveFails++
+			}
+		} else if ks.Err != "" {
+			logx.Warnf("kill-switch check: %s", ks.Err)
+		}
+	}
+
+	// Recovering the tunnel can't get past a captive portal; wait for the user to log in.
+	portal := false
+	if w.consecutiveFails >= cfg.FailureThreshold {
+		var detail string
+		portal, detail = healthcheck.DetectCaptivePortal(ctx, w.healthTimeout)
+		if portal && !w.captivePortal {
+			logx.Infof("[vpnrd] event=captive_portal captive portal detected; recovery suspended: %s", detail)
+		} else if !portal && w.captivePortal {
+			logx.Infof("[vpnrd] event=captive_portal_cleared %s", detail)
+		}
+	}
+	w.captivePortal = portal
+
+	if w.consecutiveFails >= cfg.FailureThreshold && !w.captivePortal && !w.paused && ctx.Err() == nil {
+		w.recover(ctx)
+	} else {
+		w.rateLimited = false
+	}
+	if h.OK && w.consecutiveFails == 0 && ctx.Err() == nil {
+		w.checkLifetime(ctx)
+	}
+
+	w.publish(ctx, h)
+}
+
+// checkEndpoint tells an unreachable health endpoint from a dead tunnel
+// (health_breaker_threshold): if the captive-portal URL still answers, the
+// failure is marked EndpointErr and fed to the breaker.
+func (w *Watchdog) checkEndpoint(ctx context.Context, h *healthcheck.Result) {
+	if w.breaker.Threshold <= 0 {
+		return
+	}
+	h.EndpointErr = h.Unreachable && healthcheck.NetworkUp(ctx, w.healthTimeout)
+	if !w.breaker.Record(time.Now(), h.EndpointErr) {
+		return
+	}
+	if st := w.breaker.State(); st.Open {
+		logx.Warnf("[vpnrd] event=health_breaker_open failures=%d url=%s probing every %s until it answers",
+			st.Failures, w.healthURL, w.breaker.Interval)
+	} else {
+		logx.Infof("[vpnrd] event=health_breaker_closed url=%s", w.healthURL)
+	}
+}
+
+// checkWAN follows the default route (wan_auto_detect). pf's NAT rules name the
+// WAN interface, so after a Wi-Fi <-> Ethernet switch pf is re-applied for the
+// new one; sing-box itself is left running.
+func (w *Watchdog) checkWAN(ctx context.Context) {
+	cfg := w.cfg
+	if !cfg.WANAutoDetect {
+		return
+	}
+	dctx, cancel := context.WithTimeout(ctx, cfg.CommandTimeout)
+	defer cancel()
+	wan, err := netdetect.DefaultWAN(dctx)
+	if err != nil || wan == w.wan {
+		// No default route (link down, mid-switch): keep the last WAN until one appears.
+		return
+	}
+
+	sb, _ := singboxctl.Inspect(cfg)
+	if sb == nil || !sb.Running {
+		sb, _ = singboxctl.InspectExternal(ctx, cfg)
+	}
+	singboxctl.ResolveTun(cfg, sb)
+	if sb == nil || !sb.Running || sb.NewUTUN == "" {
+		logx.Infof("[vpnrd] event=wan_changed from=%s to=%s no tunnel; pf follows on recovery", cmp.Or(w.wan, "none"), wan)
+		w.wanChanged(ctx, wan, "")
+		return
+	}
+
+	logx.Infof("[vpnrd] event=wan_changed from=%s to=%s re-applying pf (utun=%s)", cmp.Or(w.wan, "none"), wan, sb.NewUTUN)
+	if err := applyPF(ctx, cfg, sb, wan, w.lan); err != nil {
+		// Keep the old WAN so the next tick retries.
+		logx.Warnf("[vpnrd] event=wan_change_failed to=%s err=%v", wan, err)
+		return
+	}
+	w.wanChanged(ctx, wan, sb.NewUTUN)
+}
+
+// checkClashIdle fails h when sing-box's Clash API has shown no connections
+// and no new traffic for clash_api_idle_window: a tunnel nobody can use looks
+// the same. An unreachable Clash API (not enabled) is not held against it.
+func (w *Watchdog) checkClashIdle(ctx context.Context, h *healthcheck.Result) {
+	cfg := w.cfg
+	window := cfg.ClashAPIIdle()
+	if cfg.ClashAPIAddr == "" || window == 0 {
+		return
+	}
+	c := clashapi.Client{Addr: cfg.ClashAPIAddr, Secret: cfg.ClashAPISecret, Timeout: w.healthTimeout}
+	st, err := c.Stats(ctx)
+	if err != nil {
+		logx.Debugf("clash api unavailable: %v", err)
+		w.clashIdleSince = time.Time{}
+		return
+	}
+	prev := w.clashTotal
+	w.clashTotal = st.Total()
+	if st.Connections > 0 || st.Total() != prev {
+		w.clashIdleSince = time.Time{}
+		return
+	}
+	now := time.Now()
+	if w.clashIdleSince.IsZero() {
+		w.clashIdleSince = now
+	}
+	if idle := now.Sub(w.clashIdleSince); idle >= window {
+		h.OK = false
+		h.Err = fmt.Sprintf("clash api: no connections and no traffic for %s", idle.Round(time.Second))
+	}
+}
+
+// wanChanged records the new WAN interface and runs the on_wan_changed hooks.
+func (w *Watchdog) wanChanged(ctx context.Context, wan, tun string) {
+	prev := w.wan
+	w.wan = wan
+	_ = hooks.Run(ctx, w.cfg, hooks.OnWANChanged, hooks.Vars{
+		"previous_wan_if": prev,
+		"wan_if":          wan,
+		"lan_if":          w.lan,
+		"utun":            tun,
+	})
+}
+
+// recovered runs the on_recovered hooks when h ends a failure streak that
+// declared the tunnel unhealthy (the one on_health_fail fired for).
+func (w *Watchdog) recovered(ctx context.Context, h healthcheck.Result) {
+	cfg := w.cfg
+	if w.consecutiveFails < cfg.FailureThreshold {
+		return
+	}
+	_ = hooks.Run(ctx, cfg, hooks.OnRecovered, hooks.Vars{
+		"failures":   strconv.Itoa(w.consecutiveFails),
+		"recoveries": strconv.Itoa(w.recoveries),
+		"egress_ip":  healthcheck.EgressIP(h.Body),
+		"wan_if":     w.wan,
+		"lan_if":     w.lan,
+	})
+}
+
+// recover runs one recovery (or failover) attempt and re-checks health after the cooldown.
+func (w *Watchdog) recover(ctx context.Context) {
+	cfg := w.cfg
+
+	if w.recoveries >= cfg.MaxRecoveries {
+		logx.Errorf("recovery budget exhausted (recoveries=%d); manual intervention required", w.recoveries)
+		return
+	}
+	// Safety net against restart loops, independent of the budget above.
+	now := time.Now()
+	if !w.recoveryLimit.allow(now) {
+		if !w.rateLimited {
+			logx.Warnf("[vpnrd] event=recovery_rate_limited max=%d window=%s next_allowed=%s",
+				cfg.MaxRecoveriesPerWindow, cfg.RecoveryWindow, w.recoveryLimit.retryAt(now).Format(time.RFC3339))
+		}
+		w.rateLimited = true
+		return
+	}
+	if w.rateLimited {
+		logx.Infof("[vpnrd] event=recovery_rate_limit_cleared")
+		w.rateLimited = false
+	}
+	w.recoveries++
+	w.owner = singBoxOwner{} // our own restart; re-learn the owner next tick
+	logx.Infof("attempting recovery #%d...", w.recoveries)
+
+	// (Optional) snapshot before recovery
+	snap := status.Collect(ctx, cfg, w.cfgPath, w.healthTimeout)
+	debugdump.Dump("status_before_recover", snap)
+
+	var recErr error
+	if cfg.Endpoints() > 1 && cfg.FailoverAfter > 0 && w.failedRecoveries >= cfg.FailoverAfter {
+		logx.Infof("%d consecutive failed recoveries on %q; failing over", w.failedRecoveries, cfg.SingBoxConfigPath)
+		_, recErr = doFailover(ctx, cfg, w.wan, w.lan, w.verify)
+		w.failedRecoveries = 0
+	} else {
+		_, recErr = doRecovery(ctx, cfg, w.wan, w.lan, w.verify)
+	}
+	if ctx.Err() != nil {
+		logx.Warnf("recovery #%d interrupted by shutdown", w.recoveries)
+		return
+	}
+	cooldown := cfg.RecoverCooldown
+	if recErr != nil {
+		logx.Warnf("recovery #%d failed: %v", w.recoveries, recErr)
+		switch {
+		case errors.Is(recErr, singboxctl.ErrSingBoxExited):
+			// Restarting again won't help if the config itself is broken; say so.
+			if err := singboxctl.CheckConfig(ctx, cfg, cfg.SingBoxConfigPath); err != nil {
+				logx.Errorf("sing-box exited on start; its config is invalid: %v", err)
+			}
+		case errors.Is(recErr, singboxctl.ErrUTUNTimeout):
+			// The tunnel is slow to come up (e.g. flaky uplink); give it longer before re-checking.
+			cooldown *= 2
+		case errors.Is(recErr, vpnerr.ErrPermission):
+			logx.Errorf("recovery needs root; it will keep failing until vpnrd runs as root")
+		}
+	} else {
+		logx.Infof("recovery #%d executed", w.recoveries)
+	}
+
+	if !sleepCtx(ctx, cooldown) {
+		return
+	}
+
+	h2 := healthcheck.CheckExpected(ctx, w.healthURL, w.healthTimeout, w.egress.Expected(ctx, cfg.VPNServerIPs))
+	debugdump.Dump("health_after_recover", h2)
+	w.history.Add(time.Now(), h2)
+	if h2.OK {
+		if recErr == nil {
+			logx.Infof("recovery #%d succeeded; health OK", w.recoveries)
+		} else {
+			logx.Infof("health OK after failed recovery #%d (not counted as recovery success)", w.recoveries)
+		}
+		w.recovered(ctx, h2)
+	} else {
+		logx.Warnf("recovery #%d did not restore health: status=%d err=%q body=%q",
+			w.recoveries, h2.StatusCode, h2.Err, h2.Body)
+	}
+	exhausted := w.countRecovery(h2.OK)
+
+	_ = hooks.Run(ctx, cfg, hooks.OnRecover, hooks.Vars{
+		"recovery":       strconv.Itoa(w.recoveries),
+		"recovery_ok":    strconv.FormatBool(recErr == nil && h2.OK),
+		"egress_ip":      healthcheck.EgressIP(h2.Body),
+		"singbox_config": cfg.SingBoxConfigPath,
+		"wan_if":         w.wan,
+		"lan_if":         w.lan,
+	})
+
+	if exhausted {
+		w.giveUp(ctx, h2)
+		return
+	}
+	w.startGrace(fmt.Sprintf("recovery #%d", w.recoveries))
+}
+
+// countRecovery updates the failure streaks from the health re-check after a
+// recovery and reports whether max_consecutive_recovery_failures is reached.
+func (w *Watchdog) countRecovery(ok bool) bool {
+	if ok {
+		w.consecutiveFails = 0
+		w.failedRecoveries = 0
+		w.recoveryFailures = 0
+		return false
+	}
+	w.failedRecoveries++
+	w.recoveryFailures++
+	max := w.cfg.MaxConsecutiveRecoveryFailures
+	return max > 0 && w.recoveryFailures >= max
+}
+
+// giveUp ends the watchdog after max_consecutive_recovery_failures: retrying
+// forever (e.g. a suspended VPN account) would only hide the outage. Run then
+// returns ErrGaveUp so the service manager records a failed exit.
+func (w *Watchdog) giveUp(ctx context.Context, h healthcheck.Result) {
+	cfg := w.cfg
+	logx.Errorf("[vpnrd] event=recovery_give_up failures=%d last_err=%q giving up; manual intervention required",
+		w.recoveryFailures, h.Err)
+	_ = hooks.Run(ctx, cfg, hooks.OnGiveUp, hooks.Vars{
+		"recovery_failures": strconv.Itoa(w.recoveryFailures),
+		"health_err":        h.Err,
+		"singbox_config":    cfg.SingBoxConfigPath,
+		"wan_if":            w.wan,
+		"lan_if":            w.lan,
+	})
+	if cfg.GiveUpDown {
+		if _, err := Down(ctx, cfg); err != nil {
+			logx.Warnf("give up: down: %v", err)
+		}
+	}
+	w.gaveUp = fmt.Errorf("%w after %d failed recoveries in a row", ErrGaveUp, w.recoveryFailures)
+}
+
+// publish writes the optional status file and metrics textfile for this tick.
+func (w *Watchdog) publish(ctx context.Context, h healthcheck.Result) {
+	cfg := w.cfg
+
+	// The system side (sing-box, utun, firewall) costs exec calls; only collect it
+	// when someone reads it. Health and counters are always current.
+	var snap status.Snapshot
+	if cfg.StatusFilePath != "" || cfg.AdminListen != "" {
+		snap = status.CollectWithHealth(ctx, cfg, w.cfgPath, h)
+	} else {
+		snap = status.Snapshot{TimeUTC: time.Now().UTC().Format(time.RFC3339), ConfigPath: w.cfgPath, Health: h}
+	}
+	snap.Throughput = w.throughput
+	snap.Watchdog = &status.WatchdogState{
+		ConsecutiveFailures: w.consecutiveFails,
+		Recoveries:          w.recoveries,
+		CaptivePortal:       w.captivePortal,
+		RecoveryRateLimited: w.rateLimited,
+		Paused:              w.paused,
+		SingBoxConfigPath:   cfg.SingBoxConfigPath,
+		WAN:                 w.wan,
+		RecoveryFailures:    w.recoveryFailures,
+		History:             w.history.Entries(),
+	}
+	snap.Watchdog.HistoryStats = w.history.Stats()
+	if w.breaker.Threshold > 0 {
+		st := w.breaker.State()
+		snap.Watchdog.HealthBreaker = &st
+	}
+	snap.Watchdog.HistoryFailed = snap.Watchdog.HistoryStats.Failed
+	w.live.Update(snap)
+	w.saveState()
+
+	if cfg.StatusFilePath != "" {
+		if err := status.WriteFile(cfg.StatusFilePath, w.live.Snapshot()); err != nil {
+			logx.Warnf("status file: %v", err)
+		}
+	}
+
+	if cfg.MetricsTextfile != "" {
+		g := metrics.Gauges{
+			HealthOK:            h.OK,
+			HealthLatency:       h.Latency,
+			SingBoxRunning:      singBoxRunning(ctx, cfg),
+			ConsecutiveFailures: w.consecutiveFails,
+			RecoveryTotal:       w.recoveries,
+			HealthSuccessRatio:  snap.Watchdog.HistoryStats.SuccessRate,
+			HealthLatencyP95:    snap.Watchdog.HistoryStats.LatencyP95,
+		}
+		if w.throughput != nil {
+			g.HasThroughput, g.ThroughputBPS = true, w.throughput.BytesPerSec
+		}
+		if err := metrics.WriteTextfile(cfg.MetricsTextfile, g); err != nil {
+			logx.Warnf("metrics textfile: %v", err)
+		}
+	}
+}
+
+// probe runs the regular health check, with any fault injected by "vpnrd simulate" applied.
+func (w *Watchdog) probe(ctx context.Context) healthcheck.Result {
+	cfg := w.cfg
+	sim := w.simulate
+	if w.simulateLeft > 0 {
+		w.simulateLeft--
+	}
+	if w.simulateLeft == 0 {
+		w.simulate = ""
+	}
+
+	timeout := w.healthTimeout
+	var delay time.Duration
+	if sim == admin.SimulateSlowHealth {
+		delay = timeout / 2
+		timeout -= delay
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+	}
+	h := healthcheck.CheckExpected(ctx, w.healthURL, timeout, w.egress.Expected(ctx, cfg.VPNServerIPs))
+	h.Latency += delay
+	if sim == admin.SimulateHealthFail {
+		h.OK = false
+		h.Err = "simulated failure (vpnrd simulate health-fail)"
+	}
+	return h
+}
+
+// verify is the post-recovery check: the regular egress probe with its own timeout.
+func (w *Watchdog) verify(ctx context.Context, timeout time.Duration) healthcheck.Result {
+	return healthcheck.CheckExpected(ctx, w.healthURL, timeout, w.egress.Expected(ctx, w.cfg.VPNServerIPs))
+}
+
+// handleAction runs an admin API request on the watchdog loop.
+func (w *Watchdog) handleAction(ctx context.Context, a admin.Action) {
+	switch a {
+	case admin.Recover:
+		w.recover(ctx)
+	case admin.Restart:
+		if sb, _ := singboxctl.Inspect(w.cfg); sb == nil || !sb.OwnedByUs || w.cfg.AdoptOnly() {
+			logx.Warnf("admin restart ignored: sing-box not owned by vpnrd")
+			return
+		}
+		w.restartOwned(ctx, "admin request")
+	case admin.Pause:
+		w.paused = true
+		logx.Infof("automatic recovery paused (admin API)")
+	case admin.Resume:
+		w.paused = false
+		logx.Infof("automatic recovery resumed (admin API)")
+	case admin.SimulateHealthFail, admin.SimulateSlowHealth:
+		// Enough failed checks to cross failure_threshold once, so recovery runs for real.
+		w.simulate, w.simulateLeft = a, w.cfg.FailureThreshold
+		logx.Infof("[vpnrd] event=simulate scenario=%s checks=%d", strings.TrimPrefix(string(a), "simulate/"), w.simulateLeft)
+	}
+	// Reflect the change right away instead of on the next tick.
+	snap := w.live.Snapshot()
+	if snap.Watchdog != nil {
+		snap.Watchdog.Paused = w.paused
+		snap.Watchdog.Recoveries = w.recoveries
+		w.live.Update(snap)
+	}
+}
+
+// restartOwned restarts the owned sing-box outside of failure recovery (config
+// change, lifetime limit) and re-applies pf for the new utun.
+func (w *Watchdog) restartOwned(ctx context.Context, reason string) {
+	cfg := w.cfg
+	w.owner = singBoxOwner{}
+	sb, err := singboxctl.RestartOwned(ctx, cfg)
+	debugdump.Dump("singbox_after_restart", sb)
+	if err != nil {
+		logx.Warnf("restart after %s: %v", reason, err)
+		return
+	}
+	if sb == nil || !sb.Running || sb.NewUTUN == "" {
+		logx.Warnf("restart after %s: %v", reason, singboxctl.ErrNoTunnel)
+		return
+	}
+	if err := applyPF(ctx, cfg, sb, w.wan, w.lan); err != nil {
+		logx.Warnf("restart after %s: %v", reason, err)
+		return
+	}
+	logx.Infof("[vpnrd] event=singbox_restarted reason=%q pid=%d utun=%s (not a recovery)", reason, sb.PID, sb.TunLabel())
+	w.startGrace("restart (" + reason + ")")
+}
+
+// checkLifetime proactively restarts an owned sing-box whose tunnel session
+// (since EnsureRunning saw its utun ready, see Status.StartedAt) is older than
+// sing_box_max_lifetime / max_tunnel_age. Called only on a healthy tick, so
+// the restart happens while nothing else is going on.
+func (w *Watchdog) checkLifetime(ctx context.Context) {
+	cfg := w.cfg
+	if cfg.SingBoxMaxLifetime <= 0 || cfg.AdoptOnly() {
+		return
+	}
+	sb, _ := singboxctl.Inspect(cfg)
+	if sb == nil || !sb.Running || !sb.OwnedByUs || sb.StartedAt.IsZero() {
+		return
+	}
+	age := time.Since(sb.StartedAt)
+	if age < cfg.SingBoxMaxLifetime {
+		return
+	}
+	logx.Infof("[vpnrd] event=singbox_max_lifetime pid=%d tunnel_age=%s max=%s proactive restart of owned sing-box (last check healthy)",
+		sb.PID, age.Round(time.Second), cfg.SingBoxMaxLifetime)
+	w.restartOwned(ctx, "max lifetime")
+}
+
+// singBoxRunning reports whether an owned or adopted (external) sing-box is alive.
+func singBoxRunning(ctx context.Context, cfg *config.Config) bool {
+	if sb, _ := singboxctl.Inspect(cfg); sb != nil && sb.Running {
+		return true
+	}
+	ext, _ := singboxctl.InspectExternal(ctx, cfg)
+	return ext != nil && ext.Running
+}
+
+// sleepCtx waits for d or until ctx is done; it reports whether the full wait elapsed.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,66 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+)
+
+func TestCountRecovery(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int
+		results []bool
+		want    []bool // exhausted after each result
+	}{
+		{name: "unlimited", max: 0, results: []bool{false, false, false, false}, want: []bool{false, false, false, false}},
+		{name: "gives up at the limit", max: 3, results: []bool{false, false, false}, want: []bool{false, false, true}},
+		{name: "success resets the streak", max: 3, results: []bool{false, false, true, false, false, false}, want: []bool{false, false, false, false, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := NewWatchdog(&config.Config{MaxConsecutiveRecoveryFailures: tt.max}, Options{})
+			w.consecutiveFails = 5
+			for i, ok := range tt.results {
+				if got := w.countRecovery(ok); got != tt.want[i] {
+					t.Fatalf("result %d (ok=%t): exhausted = %t, want %t (streak %d)", i, ok, got, tt.want[i], w.recoveryFailures)
+				}
+				if ok && (w.recoveryFailures != 0 || w.failedRecoveries != 0 || w.consecutiveFails != 0) {
+					t.Fatalf("success left counters %d/%d/%d", w.recoveryFailures, w.failedRecoveries, w.consecutiveFails)
+				}
+			}
+		})
+	}
+}
+
+func TestGiveUp(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "hook.out")
+	w := NewWatchdog(&config.Config{
+		MaxConsecutiveRecoveryFailures: 2,
+		CommandTimeout:                 5 * time.Second,
+		Hooks:                          config.Hooks{OnGiveUp: []string{`echo "$VPNRD_RECOVERY_FAILURES $VPNRD_HEALTH_ERR" > ` + out}},
+	}, Options{})
+	w.countRecovery(false)
+	if !w.countRecovery(false) {
+		t.Fatal("not exhausted after 2 failures")
+	}
+	w.giveUp(context.Background(), healthcheck.Result{Err: "http do: timeout"})
+
+	if !errors.Is(w.gaveUp, ErrGaveUp) || !strings.Contains(w.gaveUp.Error(), "after 2 failed recoveries") {
+		t.Fatalf("gaveUp = %v", w.gaveUp)
+	}
+	b, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("on_give_up hook did not run: %v", err)
+	}
+	if got := strings.TrimSpace(string(b)); got != "2 http do: timeout" {
+		t.Fatalf("hook saw %q", got)
+	}
+}
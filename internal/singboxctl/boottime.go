@@ -0,0 +1,73 @@
+package singboxctl
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+)
+
+var (
+	bootOnce sync.Once
+	bootAt   time.Time
+	bootErr  error
+)
+
+// bootTime returns when the system last booted: kern.boottime on macOS,
+// btime in /proc/stat on Linux. It is read once per process.
+func bootTime() (time.Time, error) {
+	bootOnce.Do(func() {
+		if runtime.GOOS == "linux" {
+			bootAt, bootErr = procStatBtime()
+		} else {
+			bootAt, bootErr = sysctlBoottime()
+		}
+	})
+	return bootAt, bootErr
+}
+
+// kern.boottime prints like "{ sec = 1697000000, usec = 123456 } Wed Oct 11 ...".
+var boottimeRe = regexp.MustCompile(`sec = (\d+)`)
+
+func sysctlBoottime() (time.Time, error) {
+	out, err := control.Output(context.Background(), "sysctl", "-n", "kern.boottime")
+	if err != nil {
+		return time.Time{}, err
+	}
+	m := boottimeRe.FindSubmatch(out)
+	if m == nil {
+		return time.Time{}, errors.New("unexpected kern.boottime: " + strings.TrimSpace(string(out)))
+	}
+	sec, err := strconv.ParseInt(string(m[1]), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, 0), nil
+}
+
+func procStatBtime() (time.Time, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "btime "); ok {
+			sec, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(sec, 0), nil
+		}
+	}
+	return time.Time{}, errors.New("no btime in /proc/stat")
+}
@@ -0,0 +1,49 @@
+package singboxctl
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+// Failure modes reported by this package, wrapped with context; use errors.Is.
+// Each also matches its vpnerr class.
+var (
+	// ErrNoTunnel: sing-box is not running or no utun could be attributed to it.
+	ErrNoTunnel = vpnerr.Class(vpnerr.ErrNoUTUN, errors.New("sing-box not running or utun not detected"))
+
+	// ErrSingBoxExited: a freshly started sing-box died before its utun was ready
+	// (usually a config problem; the reason is in singbox_log_file).
+	ErrSingBoxExited = errors.New("sing-box exited during startup")
+
+	// ErrUTUNTimeout: the utun wasn't ready within singbox_start_timeout.
+	// ErrUTUNNotCreated and ErrUTUNNoAddress both match it.
+	ErrUTUNTimeout = vpnerr.Class(vpnerr.ErrNoUTUN, errors.New("utun not ready in time"))
+
+	// ErrUTUNNotCreated: the expected tunnel interface never showed up (process/config problem).
+	ErrUTUNNotCreated error = &utunTimeoutError{"utun never created"}
+	// ErrUTUNNoAddress: the interface exists but never got an IPv4 address (routing/config problem).
+	ErrUTUNNoAddress error = &utunTimeoutError{"utun created but never got an address"}
+
+	// ErrPidfileStale: the pidfile names a process that is no longer alive.
+	ErrPidfileStale = vpnerr.Class(vpnerr.ErrSingBoxNotOwned, errors.New("stale pidfile"))
+
+	// ErrNotAdoptable: sing_box_manage_mode is adopt_only and no external sing-box is running.
+	ErrNotAdoptable = vpnerr.Class(vpnerr.ErrSingBoxNotOwned, errors.New("no external sing-box to adopt (sing_box_manage_mode: adopt_only)"))
+)
+
+// utunTimeoutError is a specific readiness timeout that also matches ErrUTUNTimeout.
+type utunTimeoutError struct{ msg string }
+
+func (e *utunTimeoutError) Error() string { return e.msg }
+func (e *utunTimeoutError) Unwrap() error { return ErrUTUNTimeout }
+
+// exitedError is ErrSingBoxExited noticed while waiting for the utun, with the
+// process's exit code (-1 = killed by a signal).
+type exitedError struct{ code int }
+
+func (e *exitedError) Error() string {
+	return fmt.Sprintf("sing-box exited before utun appeared (code=%d)", e.code)
+}
+func (e *exitedError) Unwrap() error { return ErrSingBoxExited }
@@ -0,0 +1,108 @@
+package singboxctl
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/utun"
+)
+
+// Iface is the subset of a network interface the utun selection logic needs.
+type Iface struct {
+	Name  string
+	Addrs []*net.IPNet
+}
+
+// HasIPv4 reports whether the interface has at least one IPv4 address.
+func (i Iface) HasIPv4() bool {
+	for _, a := range i.Addrs {
+		if a != nil && a.IP.To4() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// IfaceLister enumerates network interfaces.
+// The real implementation wraps net.Interfaces; tests can swap in a fake.
+type IfaceLister interface {
+	List() ([]Iface, error)
+}
+
+// netIfaceLister is the IfaceLister backed by the host's interfaces.
+type netIfaceLister struct{}
+
+func (netIfaceLister) List() ([]Iface, error) {
+	nifs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Iface, 0, len(nifs))
+	for _, nif := range nifs {
+		ifc := Iface{Name: nif.Name}
+		addrs, err := nif.Addrs()
+		if err == nil {
+			for _, a := range addrs {
+				ip, ipnet, err := net.ParseCIDR(a.String())
+				if err != nil {
+					continue
+				}
+				// ParseCIDR masks the network; keep the interface's own address.
+				ipnet.IP = ip
+				ifc.Addrs = append(ifc.Addrs, ipnet)
+			}
+		}
+		out = append(out, ifc)
+	}
+	return out, nil
+}
+
+// ifaceLister is used by all utun lookups in this package.
+var ifaceLister IfaceLister = netIfaceLister{}
+
+// SetIfaceLister replaces the interface lister (nil restores the real one).
+// It returns the previous lister so callers can restore it.
+func SetIfaceLister(l IfaceLister) IfaceLister {
+	prev := ifaceLister
+	if l == nil {
+		l = netIfaceLister{}
+	}
+	ifaceLister = l
+	return prev
+}
+
+// ifaceByName returns the named interface from the lister.
+func ifaceByName(name string) (Iface, error) {
+	ifs, err := ifaceLister.List()
+	if err != nil {
+		return Iface{}, err
+	}
+	for _, ifc := range ifs {
+		if ifc.Name == name {
+			return ifc, nil
+		}
+	}
+	return Iface{}, fmt.Errorf("interface %q not found", name)
+}
+
+// ignoreUTUNs lists utuns never considered for selection (config ignore_utuns).
+var ignoreUTUNs utun.Ignore
+
+// SetIgnoreUTUNs sets the utuns skipped by all utun selection in this package.
+func SetIgnoreUTUNs(ig utun.Ignore) { ignoreUTUNs = ig }
+
+// listUTUNIfaces returns only utun* interfaces from the lister, minus ignored ones.
+func listUTUNIfaces() ([]Iface, error) {
+	ifs, err := ifaceLister.List()
+	if err != nil {
+		return nil, err
+	}
+	var out []Iface
+	for _, ifc := range ifs {
+		if strings.HasPrefix(ifc.Name, "utun") && !ignoreUTUNs.Match(ifc.Name) {
+			out = append(out, ifc)
+		}
+	}
+	return out, nil
+}
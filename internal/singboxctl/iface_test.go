@@ -0,0 +1,97 @@
+package singboxctl
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"testing"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/utun"
+)
+
+func TestSetIfaceLister(t *testing.T) {
+	fake := staticLister()
+	prev := SetIfaceLister(fake)
+	t.Cleanup(func() { SetIfaceLister(prev) })
+	if _, ok := prev.(netIfaceLister); !ok {
+		t.Fatalf("default lister = %T, want netIfaceLister", prev)
+	}
+	if got := SetIfaceLister(nil); got == nil {
+		t.Fatal("SetIfaceLister returned nil for the fake")
+	}
+	if _, ok := ifaceLister.(netIfaceLister); !ok {
+		t.Fatalf("after SetIfaceLister(nil) lister = %T, want netIfaceLister", ifaceLister)
+	}
+}
+
+func TestListUTUN(t *testing.T) {
+	useLister(t, staticLister(
+		iface(t, "en0", "192.168.1.2/24"),
+		iface(t, "utun0", "fe80::1/64"),
+		iface(t, "utun4", "172.19.0.1/30"),
+		iface(t, "utun5"),
+	))
+	set, noIPv4, err := listUTUN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"utun0": true, "utun4": true, "utun5": true}; !maps.Equal(set, want) {
+		t.Errorf("utuns = %v, want %v", set, want)
+	}
+	if want := map[string]bool{"utun0": true, "utun5": true}; !maps.Equal(noIPv4, want) {
+		t.Errorf("without IPv4 = %v, want %v", noIPv4, want)
+	}
+
+	ok, err := utunHasIPv4("utun4")
+	if err != nil || !ok {
+		t.Errorf("utunHasIPv4(utun4) = %v, %v; want true", ok, err)
+	}
+	if _, err := ifaceByName("utun9"); err == nil {
+		t.Error("ifaceByName(utun9): no error for a missing interface")
+	}
+}
+
+func TestListerError(t *testing.T) {
+	boom := errors.New("boom")
+	useLister(t, fakeLister(func() ([]Iface, error) { return nil, boom }))
+	if _, _, err := listUTUN(); !errors.Is(err, boom) {
+		t.Errorf("listUTUN err = %v, want the lister's", err)
+	}
+	if _, err := findUTUNWithIPv4(nil); !errors.Is(err, boom) {
+		t.Errorf("findUTUNWithIPv4 err = %v, want the lister's", err)
+	}
+}
+
+func TestIgnoredUTUNsNeverSelected(t *testing.T) {
+	ig, err := utun.ParseIgnore([]string{"utun5", "utun8-utun9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetIgnoreUTUNs(ig)
+	t.Cleanup(func() { SetIgnoreUTUNs(utun.Ignore{}) })
+
+	// Only the ignored utuns have IPv4, one of them even in the tun prefix.
+	useLister(t, staticLister(
+		iface(t, "utun5", "100.64.0.2/32"),
+		iface(t, "utun9", "172.19.0.1/30"),
+		iface(t, "utun3"),
+	))
+	set, _, err := listUTUN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"utun3": true}; !maps.Equal(set, want) {
+		t.Errorf("listUTUN = %v, want %v", set, want)
+	}
+	if got, err := findUTUNWithIPv4(prefixes(t, "172.19.0.0/30")); err == nil {
+		t.Errorf("findUTUNWithIPv4 picked ignored %s", got)
+	}
+	if got, err := findBestUTUN(nil, nil, true); err == nil {
+		t.Errorf("findBestUTUN picked ignored %s", got)
+	}
+	_, err = waitForUTUNReady(context.Background(), map[string]bool{}, map[string]bool{}, 250*time.Millisecond, nil, false, nil, 0, nil)
+	if !errors.Is(err, ErrUTUNNoAddress) {
+		t.Errorf("waitForUTUNReady err = %v, want ErrUTUNNoAddress (only utun3 is a candidate)", err)
+	}
+}
@@ -0,0 +1,72 @@
+package singboxctl
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+)
+
+// watchInterfaceReady reports interface changes from the routing socket
+// (PF_ROUTE): a value is sent whenever an interface appears, changes or gains
+// or loses an address; prefer != "" limits that to the one interface. The
+// watch ends with ctx. Sends never block; a burst collapses into one wake-up.
+func watchInterfaceReady(ctx context.Context, prefer string) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_ROUTE, syscall.SOCK_RAW, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	// Non-blocking so the runtime poller owns it and Close interrupts Read.
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), "route")
+	wake := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return // closed; the caller's fallback poll carries on
+			}
+			if routeMsgMatches(buf[:n], prefer) {
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return wake, nil
+}
+
+// routeMsgMatches reports whether b holds an interface or address message
+// (RTM_IFINFO, RTM_NEWADDR, RTM_DELADDR) for prefer, or for any interface
+// when prefer is "". if_msghdr and ifa_msghdr both carry the type at offset 3
+// and the interface index at offset 12.
+func routeMsgMatches(b []byte, prefer string) bool {
+	for len(b) >= 14 {
+		msgLen := int(binary.NativeEndian.Uint16(b[0:2]))
+		if msgLen < 14 || msgLen > len(b) {
+			return false
+		}
+		switch b[3] {
+		case syscall.RTM_IFINFO, syscall.RTM_NEWADDR, syscall.RTM_DELADDR:
+			if prefer == "" {
+				return true
+			}
+			idx := int(binary.NativeEndian.Uint16(b[12:14]))
+			if ifi, err := net.InterfaceByIndex(idx); err == nil && ifi.Name == prefer {
+				return true
+			}
+		}
+		b = b[msgLen:]
+	}
+	return false
+}
@@ -0,0 +1,14 @@
+//go:build !darwin
+
+package singboxctl
+
+import (
+	"context"
+	"errors"
+)
+
+// watchInterfaceReady needs a routing socket (PF_ROUTE, macOS); elsewhere the
+// utun wait keeps polling.
+func watchInterfaceReady(ctx context.Context, prefer string) (<-chan struct{}, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -0,0 +1,82 @@
+package singboxctl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+)
+
+// Orphan is a utun attributed to this config's sing-box while no sing-box runs.
+type Orphan struct {
+	Name   string
+	Reason string // how it was attributed
+}
+
+// OrphanUTUNs lists utuns left behind by a dead sing-box. A utun counts only if
+// it is positively attributed: its name is the pinned tun_interface_name or the
+// interface_name of one of the config's tun inbounds, or it carries an address
+// inside their prefixes. Unattributed utuns may belong to another VPN and are never listed.
+// Nothing is an orphan while any sing-box process is running.
+func OrphanUTUNs(ctx context.Context, cfg *config.Config) ([]Orphan, error) {
+	pids, err := findPIDs(ctx, "sing-box", true)
+	if err != nil {
+		return nil, fmt.Errorf("cannot tell whether sing-box is running: %w", err)
+	}
+	if len(pids) > 0 {
+		return nil, fmt.Errorf("sing-box is running (pid %s); its utuns are not orphans", strings.Trim(fmt.Sprint(pids), "[]"))
+	}
+
+	pinned, prefixes := configTuns(cfg)
+	if len(pinned) == 0 && len(prefixes) == 0 {
+		return nil, fmt.Errorf("%s has no tun interface_name or address to attribute utuns by", cfg.SingBoxConfigPath)
+	}
+
+	ifs, err := listUTUNIfaces()
+	if err != nil {
+		return nil, err
+	}
+	var out []Orphan
+	for _, ifc := range ifs {
+		if why, ok := pinned[ifc.Name]; ok {
+			out = append(out, Orphan{Name: ifc.Name, Reason: why})
+			continue
+		}
+		if ifaceInSubnets(ifc, prefixes) {
+			out = append(out, Orphan{Name: ifc.Name, Reason: "address in sing-box tun prefix"})
+		}
+	}
+	return out, nil
+}
+
+// configTuns is what attributes a utun to cfg's sing-box: pinned interface
+// names (with where they are pinned) and the tun inbounds' address prefixes.
+func configTuns(cfg *config.Config) (pinned map[string]string, prefixes []*net.IPNet) {
+	tuns, _ := tunInboundsFromConfig(cfg.SingBoxConfigPath)
+	pinned = map[string]string{}
+	for _, t := range tuns {
+		if t.Name != "" {
+			pinned[t.Name] = "interface_name in " + cfg.SingBoxConfigPath
+		}
+	}
+	if cfg.TunInterfaceName != "" {
+		pinned[cfg.TunInterfaceName] = "tun_interface_name"
+	}
+	return pinned, tunPrefixes(tuns)
+}
+
+// DestroyUTUN removes an interface with "ifconfig <name> destroy".
+func DestroyUTUN(ctx context.Context, name string) error {
+	if dryRun {
+		logx.Infof("[dry-run] would run: ifconfig %s destroy", name)
+		return nil
+	}
+	if out, err := exec.CommandContext(ctx, "ifconfig", name, "destroy").CombinedOutput(); err != nil {
+		return fmt.Errorf("ifconfig %s destroy: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
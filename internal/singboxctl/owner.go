@@ -0,0 +1,145 @@
+package singboxctl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+)
+
+// Owner is the best guess at which process created a tun interface.
+type Owner struct {
+	Iface   string `json:"iface"`
+	PID     int    `json:"pid,omitempty"` // 0 = unknown
+	Command string `json:"command,omitempty"`
+	Reason  string `json:"reason"` // how it was attributed, or why it could not be
+}
+
+// UTUNOwner attributes interface name to a process. This config's sing-box is
+// tried first (owned via the pidfile, else an external "sing-box run -c" of
+// the same config) when the interface is one it would use; then any process
+// holding the interface open (lsof on macOS, /proc fdinfo on Linux), which
+// catches other VPN clients. PID stays 0 when neither finds one.
+func UTUNOwner(ctx context.Context, cfg *config.Config, name string) (Owner, error) {
+	ifc, err := ifaceByName(name)
+	if err != nil {
+		return Owner{}, err
+	}
+	o := Owner{Iface: name}
+
+	if why, ok := configTunReason(cfg, ifc); ok {
+		if sb, _ := Inspect(cfg); sb != nil && sb.Running {
+			o.PID, o.Reason = sb.PID, "owned sing-box (pidfile "+cfg.SingBoxPidFile+"); "+why
+		} else if ext, _ := InspectExternal(ctx, cfg); ext != nil && ext.Running {
+			o.PID, o.Reason = ext.PID, "external sing-box running "+cfg.SingBoxConfigPath+"; "+why
+		}
+	}
+	if o.PID == 0 {
+		pid, err := tunFDHolder(ctx, name)
+		switch {
+		case err != nil:
+			o.Reason = "unknown: " + err.Error()
+		case pid == 0:
+			o.Reason = "unknown: no process holds it open"
+		default:
+			o.PID, o.Reason = pid, "holds the interface open"
+		}
+	}
+	if o.PID != 0 {
+		o.Command, _ = processCommand(o.PID)
+	}
+	return o, nil
+}
+
+// configTunReason reports whether ifc is one cfg's sing-box would use, and why.
+func configTunReason(cfg *config.Config, ifc Iface) (string, bool) {
+	pinned, prefixes := configTuns(cfg)
+	if why, ok := pinned[ifc.Name]; ok {
+		return why, true
+	}
+	if ifaceInSubnets(ifc, prefixes) {
+		return "address in sing-box tun prefix", true
+	}
+	sb, _ := Inspect(cfg)
+	if sb == nil || !sb.Running {
+		sb, _ = InspectExternal(context.Background(), cfg)
+	}
+	ResolveTun(cfg, sb)
+	if sb != nil && sb.NewUTUN == ifc.Name {
+		return "selected by sing-box's tun detection", true
+	}
+	return "", false
+}
+
+// tunFDHolder returns the PID with a descriptor open on tun interface name, 0 if none.
+func tunFDHolder(ctx context.Context, name string) (int, error) {
+	if runtime.GOOS == "linux" {
+		return procTunHolder(name)
+	}
+	return lsofUTUNHolder(ctx, name)
+}
+
+// lsof shows a utun's control socket as "[ctl com.apple.net.utun_control id N
+// unit U]"; unit U is utun<U-1>.
+var utunCtlRe = regexp.MustCompile(`com\.apple\.net\.utun_control id \d+ unit (\d+)`)
+
+// lsofUTUNHolder finds the process owning utun<N>'s control socket (macOS).
+// Without root, only the caller's own processes are visible.
+func lsofUTUNHolder(ctx context.Context, name string) (int, error) {
+	n, ok := utunNumber(name)
+	if !ok {
+		return 0, fmt.Errorf("%s is not a utun", name)
+	}
+	// -F pn: a "p<pid>" line per process, then an "n<name>" line per descriptor.
+	out, err := control.Output(ctx, "lsof", "-n", "-P", "-F", "pn")
+	if err != nil && len(out) == 0 {
+		return 0, fmt.Errorf("lsof: %w", err)
+	}
+	pid := 0
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		ln := sc.Text()
+		switch {
+		case strings.HasPrefix(ln, "p"):
+			pid, _ = strconv.Atoi(ln[1:])
+		case strings.HasPrefix(ln, "n"):
+			if m := utunCtlRe.FindStringSubmatch(ln); m != nil {
+				if unit, _ := strconv.Atoi(m[1]); unit-1 == n {
+					return pid, nil
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+// procTunHolder finds a process with /dev/net/tun open for name: the kernel
+// lists the attached interface as "iff:" in the descriptor's fdinfo (Linux).
+func procTunHolder(name string) (int, error) {
+	fdinfos, err := filepath.Glob("/proc/[0-9]*/fdinfo/*")
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range fdinfos {
+		b, err := os.ReadFile(p)
+		if err != nil || !bytes.Contains(b, []byte("iff:")) {
+			continue
+		}
+		for _, ln := range strings.Split(string(b), "\n") {
+			if k, v, ok := strings.Cut(ln, ":"); ok && k == "iff" && strings.TrimSpace(v) == name {
+				pid, _ := strconv.Atoi(strings.Split(p, "/")[2])
+				return pid, nil
+			}
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,147 @@
+package singboxctl
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+)
+
+func TestConfigArg(t *testing.T) {
+	tests := []struct {
+		cmdline, want string
+		ok            bool
+	}{
+		{"/usr/local/bin/sing-box run -c /etc/sb/a.json", "/etc/sb/a.json", true},
+		{"sing-box run -c /Users/op/VPN configs/b.json", "/Users/op/VPN configs/b.json", true},
+		{"sing-box run -c /etc/sb/a.json -D /var/lib/sing-box", "/etc/sb/a.json", true},
+		{"sleep 30", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := configArg(tt.cmdline)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("configArg(%q) = %q, %t; want %q, %t", tt.cmdline, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// After a failover the owned sing-box runs singbox_configs[1] while a fresh
+// config load starts on [0]; it must still be ours.
+func TestOwnedAliveAfterFailover(t *testing.T) {
+	pid := os.Getpid() // alive; ps is faked
+	tests := []struct {
+		name    string
+		pidfile string
+		running string
+		want    bool
+	}{
+		{name: "recorded endpoint", pidfile: "b.json", running: "b.json", want: true},
+		{name: "recorded endpoint differs", pidfile: "a.json", running: "b.json"},
+		{name: "legacy pidfile, other endpoint", running: "b.json", want: true},
+		{name: "legacy pidfile, unknown config", running: "c.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := &config.Config{
+				SingBoxConfigPath: filepath.Join(dir, "a.json"),
+				SingBoxConfigs:    []string{filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")},
+				SingBoxPidFile:    filepath.Join(dir, "singbox.pid"),
+			}
+			if tt.pidfile != "" {
+				if err := writePID(cfg.SingBoxPidFile, pid, filepath.Join(dir, tt.pidfile)); err != nil {
+					t.Fatal(err)
+				}
+			} else if err := os.WriteFile(cfg.SingBoxPidFile, []byte("12345\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			useRunner(t, &fakeRunner{
+				pgrep: func([]string) ([]byte, error) { return nil, exitError(t, "1") },
+				ps: func([]string) ([]byte, error) {
+					return []byte("/usr/local/bin/sing-box run -c " + filepath.Join(dir, tt.running) + "\n"), nil
+				},
+			})
+
+			if got := ownedAlive(cfg, pid); got != tt.want {
+				t.Fatalf("ownedAlive = %t, want %t", got, tt.want)
+			}
+			if _, err := Inspect(cfg); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Stat(cfg.SingBoxPidFile); err != nil {
+				t.Fatalf("Inspect removed the pidfile: %v", err)
+			}
+		})
+	}
+}
+
+func TestPidfileFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "singbox.pid")
+	if err := writePID(path, 4242, "/etc/sb/b.json"); err != nil {
+		t.Fatal(err)
+	}
+	if pid, ok := readPID(path); !ok || pid != 4242 {
+		t.Fatalf("readPID = %d, %t", pid, ok)
+	}
+	if got := pidConfigPath(path); got != "/etc/sb/b.json" {
+		t.Fatalf("pidConfigPath = %q", got)
+	}
+	if err := os.WriteFile(path, []byte("4242\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if pid, ok := readPID(path); !ok || pid != 4242 || pidConfigPath(path) != "" {
+		t.Fatalf("legacy pidfile: pid %d ok %t config %q", pid, ok, pidConfigPath(path))
+	}
+}
+
+func TestMigrateLegacyPidfile(t *testing.T) {
+	dir := t.TempDir()
+	legacy := filepath.Join(dir, "config", "vpnrd", "singbox.pid")
+	if err := writePID(legacy, 4242, ""); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(legacy, started, started); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{SingBoxPidFile: filepath.Join(dir, "run", "vpnrd", "singbox.pid")}
+
+	migrateLegacyPidfile(cfg, legacy)
+	if pid, ok := readPID(cfg.SingBoxPidFile); !ok || pid != 4242 {
+		t.Fatalf("migrated pidfile: pid %d ok %t", pid, ok)
+	}
+	if got := pidStartedAt(cfg.SingBoxPidFile); !got.Equal(started) {
+		t.Errorf("mtime = %v, want the legacy pidfile's %v", got, started)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy pidfile still there: %v", err)
+	}
+
+	// An existing pidfile at the new location wins over a legacy one.
+	if err := writePID(legacy, 5353, ""); err != nil {
+		t.Fatal(err)
+	}
+	migrateLegacyPidfile(cfg, legacy)
+	if pid, _ := readPID(cfg.SingBoxPidFile); pid != 4242 {
+		t.Errorf("pid = %d, want the current pidfile's 4242", pid)
+	}
+}
+
+func TestFindExternalOtherEndpoint(t *testing.T) {
+	cfg := &config.Config{SingBoxConfigPath: "/etc/sb/a.json", SingBoxConfigs: []string{"/etc/sb/a.json", "/etc/sb/b.json"}}
+	pid := os.Getpid()
+	useRunner(t, &fakeRunner{pgrep: func(args []string) ([]byte, error) {
+		if args[len(args)-1] == "sing-box run -c /etc/sb/b.json" {
+			return []byte(strconv.Itoa(pid) + "\n"), nil
+		}
+		return nil, exitError(t, "1")
+	}})
+	st, err := InspectExternal(context.Background(), cfg)
+	if err != nil || !st.Running || st.PID != pid {
+		t.Fatalf("InspectExternal = %+v, %v; want pid %d on the other endpoint", st, err, pid)
+	}
+}
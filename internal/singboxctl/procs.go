@@ -0,0 +1,129 @@
+package singboxctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+)
+
+// findPIDs returns the PIDs of processes whose full command line contains
+// pattern (exact: whose executable is named pattern), via pgrep. pgrep exiting
+// 1 means no match; when pgrep itself is missing (minimal systems) the process
+// table is scanned instead (/proc on Linux, ps elsewhere), so a missing tool
+// never reads as "no sing-box running".
+func findPIDs(ctx context.Context, pattern string, exact bool) ([]int, error) {
+	flag := "-f"
+	if exact {
+		flag = "-x"
+	}
+	out, err := control.Output(ctx, "pgrep", flag, pattern)
+	var ee *exec.ExitError
+	switch {
+	case err == nil:
+		return parsePIDs(string(out)), nil
+	case errors.As(err, &ee) && ee.ExitCode() == 1:
+		return nil, nil
+	case !errors.Is(err, exec.ErrNotFound):
+		return nil, fmt.Errorf("pgrep %s %q: %w", flag, pattern, err)
+	}
+
+	logx.Debugf("[vpnrd] pgrep not found; scanning the process table for %q", pattern)
+	procs, err := listProcesses(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pgrep missing and process table unreadable: %w", err)
+	}
+	var pids []int
+	for _, p := range procs {
+		if p.pid == os.Getpid() {
+			continue // pgrep never reports itself; we are the equivalent
+		}
+		if exact && procName(p.command) == pattern || !exact && strings.Contains(p.command, pattern) {
+			pids = append(pids, p.pid)
+		}
+	}
+	return pids, nil
+}
+
+// parsePIDs reads pgrep's one-PID-per-line output, skipping anything else.
+func parsePIDs(out string) []int {
+	var pids []int
+	for _, f := range strings.Fields(out) {
+		if pid, err := strconv.Atoi(f); err == nil && pid > 1 {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// process is one entry of the process table.
+type process struct {
+	pid     int
+	command string // full command line
+}
+
+// procName is the executable name of a command line (pgrep -x matches it).
+func procName(command string) string {
+	f := strings.Fields(command)
+	if len(f) == 0 {
+		return ""
+	}
+	return filepath.Base(f[0])
+}
+
+// listProcesses reads /proc/<pid>/cmdline on Linux and "ps -axo pid=,command="
+// elsewhere.
+func listProcesses(ctx context.Context) ([]process, error) {
+	if runtime.GOOS == "linux" {
+		return procProcesses()
+	}
+	out, err := control.Output(ctx, "ps", "-axo", "pid=,command=")
+	if err != nil {
+		return nil, fmt.Errorf("ps: %w", err)
+	}
+	return parsePS(string(out)), nil
+}
+
+// parsePS reads "ps -axo pid=,command=" output.
+func parsePS(out string) []process {
+	var procs []process
+	for _, ln := range strings.Split(out, "\n") {
+		pidStr, cmd, ok := strings.Cut(strings.TrimSpace(ln), " ")
+		if !ok {
+			continue
+		}
+		if pid, err := strconv.Atoi(pidStr); err == nil {
+			procs = append(procs, process{pid: pid, command: strings.TrimSpace(cmd)})
+		}
+	}
+	return procs
+}
+
+func procProcesses() ([]process, error) {
+	dirs, err := filepath.Glob("/proc/[0-9]*/cmdline")
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return nil, errors.New("/proc not mounted")
+	}
+	var procs []process
+	for _, p := range dirs {
+		b, err := os.ReadFile(p)
+		if err != nil || len(b) == 0 {
+			continue // exited, or a kernel thread
+		}
+		pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(p)))
+		cmd := strings.TrimSpace(strings.ReplaceAll(string(b), "\x00", " "))
+		procs = append(procs, process{pid: pid, command: cmd})
+	}
+	return procs, nil
+}
@@ -0,0 +1,122 @@
+package singboxctl
+
+import (
+	"context"
+	"os/exec"
+	"slices"
+	"testing"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+)
+
+// fakeRunner answers pgrep (and ps, when set) itself and passes every other
+// command to the real runner.
+type fakeRunner struct {
+	pgrep func(args []string) ([]byte, error)
+	ps    func(args []string) ([]byte, error)
+	calls []string
+}
+
+func (f *fakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	f.calls = append(f.calls, name)
+	if name == "pgrep" {
+		return f.pgrep(args)
+	}
+	if name == "ps" && f.ps != nil {
+		return f.ps(args)
+	}
+	return control.ExecRunner{}.Run(ctx, name, args...)
+}
+
+func useRunner(t *testing.T, r control.Runner) {
+	t.Helper()
+	prev := control.SetRunner(r)
+	t.Cleanup(func() { control.SetRunner(prev) })
+}
+
+// exitError is a real *exec.ExitError with the given code.
+func exitError(t *testing.T, code string) error {
+	t.Helper()
+	err := exec.Command("sh", "-c", "exit "+code).Run()
+	if err == nil {
+		t.Fatal("sh exited 0")
+	}
+	return err
+}
+
+func TestFindPIDsPgrep(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		err     error
+		want    []int
+		wantErr bool
+	}{
+		{name: "matches", out: "123\n456\n", want: []int{123, 456}},
+		{name: "junk and pid 1 skipped", out: "1\nabc\n789\n", want: []int{789}},
+		{name: "no match is exit 1", err: exitError(t, "1")},
+		{name: "pgrep failure", err: exitError(t, "2"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fr := &fakeRunner{pgrep: func([]string) ([]byte, error) { return []byte(tt.out), tt.err }}
+			useRunner(t, fr)
+			got, err := findPIDs(context.Background(), "sing-box run -c /etc/sb.json", false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("pids = %v, want %v", got, tt.want)
+			}
+			if !slices.Equal(fr.calls, []string{"pgrep"}) {
+				t.Fatalf("ran %v; the process table is only for a missing pgrep", fr.calls)
+			}
+		})
+	}
+}
+
+func TestFindPIDsWithoutPgrep(t *testing.T) {
+	fr := &fakeRunner{pgrep: func([]string) ([]byte, error) {
+		return nil, &exec.Error{Name: "pgrep", Err: exec.ErrNotFound}
+	}}
+	useRunner(t, fr)
+
+	// A child with a command line no other process has.
+	child := exec.Command("sleep", "29.4242")
+	if err := child.Start(); err != nil {
+		t.Skip(err)
+	}
+	defer func() { _ = child.Process.Kill(); _ = child.Wait() }()
+
+	got, err := findPIDs(context.Background(), "sleep 29.4242", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []int{child.Process.Pid}) {
+		t.Fatalf("pids = %v, want [%d]", got, child.Process.Pid)
+	}
+
+	got, err = findPIDs(context.Background(), "sleep", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(got, child.Process.Pid) {
+		t.Fatalf("exact match: pids = %v, want %d among them", got, child.Process.Pid)
+	}
+}
+
+func TestParsePS(t *testing.T) {
+	out := "    1 /sbin/launchd\n  412 /usr/local/bin/sing-box run -c /etc/sb.json\n\nbogus line\n 9000 vpnrd run\n"
+	got := parsePS(out)
+	want := []process{
+		{pid: 1, command: "/sbin/launchd"},
+		{pid: 412, command: "/usr/local/bin/sing-box run -c /etc/sb.json"},
+		{pid: 9000, command: "vpnrd run"},
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("parsePS = %+v, want %+v", got, want)
+	}
+	if name := procName(got[1].command); name != "sing-box" {
+		t.Fatalf("procName = %q, want sing-box", name)
+	}
+}
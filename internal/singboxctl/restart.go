@@ -4,53 +4,37 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"syscall"
+	"os/exec"
+	"strings"
 	"time"
 
 	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
 )
 
+// StopOwned stops the pidfile's sing-box (SIGTERM, then SIGKILL after timeout
+// unless singbox_stop_sigkill is false).
+// A pidfile naming a dead (or reused) PID is removed and reported as ErrPidfileStale.
 func StopOwned(ctx context.Context, cfg *config.Config, timeout time.Duration) error {
 	pid, ok := readPID(cfg.SingBoxPidFile)
 	if !ok {
-		return fmt.Errorf("pidfile not found: %s", cfg.SingBoxPidFile)
+		return fmt.Errorf("pidfile not found: %s: %w", cfg.SingBoxPidFile, vpnerr.ErrSingBoxNotOwned)
 	}
-	if !processAlive(pid) {
-		_ = os.Remove(cfg.SingBoxPidFile)
+	if dryRun {
+		logx.Infof("[dry-run] would stop owned sing-box pid=%d (%s, SIGKILL after %s)", pid, signalNames(cfg.StopSignalList()), timeout)
 		return nil
 	}
-
-	proc, err := os.FindProcess(pid)
-	if err != nil {
-		return fmt.Errorf("find process %d: %w", pid, err)
+	if !ownedAlive(cfg, pid) {
+		dropStalePidfile(cfg, pid)
+		return fmt.Errorf("pid %d (%s removed): %w", pid, cfg.SingBoxPidFile, ErrPidfileStale)
 	}
 
-	// polite stop first
-	_ = proc.Signal(syscall.SIGTERM)
-
-	deadline := time.NewTimer(timeout)
-	defer deadline.Stop()
-
-	tick := time.NewTicker(200 * time.Millisecond)
-	defer tick.Stop()
-
-	for {
-		if !processAlive(pid) {
-			_ = os.Remove(cfg.SingBoxPidFile)
-			return nil
-		}
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-deadline.C:
-			// force kill
-			_ = proc.Signal(syscall.SIGKILL)
-			time.Sleep(200 * time.Millisecond)
-			_ = os.Remove(cfg.SingBoxPidFile)
-			return fmt.Errorf("sing-box did not stop in time; killed pid=%d", pid)
-		case <-tick.C:
-		}
+	if err := stopPID(ctx, cfg, pid, timeout); err != nil {
+		return err
 	}
+	_ = os.Remove(cfg.SingBoxPidFile)
+	return nil
 }
 
 func RestartOwned(ctx context.Context, cfg *config.Config) (*Status, error) {
@@ -60,3 +44,19 @@ func RestartOwned(ctx context.Context, cfg *config.Config) (*Status, error) {
 	// Start / ensure running again (this should create a new utun)
 	return EnsureRunning(ctx, cfg, cfg.SingBoxStartTimeout)
 }
+
+// CheckConfig validates path with "sing-box check". It only reads the file, so it
+// also runs in dry-run mode; the error carries sing-box's own diagnostics.
+func CheckConfig(ctx context.Context, cfg *config.Config, path string) error {
+	cctx, cancel := context.WithTimeout(ctx, cfg.CommandTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(cctx, cfg.SingBoxPath, "check", "-c", path).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("sing-box check %s: %w: %s", path, err, msg)
+		}
+		return fmt.Errorf("sing-box check %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package singboxctl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// ServerHosts best-effort extracts the remote server addresses (IPs or domains)
+// of the proxy outbounds in a sing-box JSON config: outbounds[].server,
+// outbounds[].peers[].server (legacy WireGuard) and endpoints[].peers[].address.
+func ServerHosts(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var root map[string]any
+	if err := json.Unmarshal(b, &root); err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	add := func(m map[string]any, key string) {
+		if h, _ := m[key].(string); strings.TrimSpace(h) != "" {
+			hosts = append(hosts, strings.TrimSpace(h))
+		}
+	}
+	walk := func(section, peerKey string) {
+		list, _ := root[section].([]any)
+		for _, v := range list {
+			m, ok := v.(map[string]any)
+			if !ok {
+				continue
+			}
+			add(m, "server")
+			peers, _ := m["peers"].([]any)
+			for _, p := range peers {
+				if pm, ok := p.(map[string]any); ok {
+					add(pm, peerKey)
+				}
+			}
+		}
+	}
+	walk("outbounds", "server")
+	walk("endpoints", "address")
+	return hosts, nil
+}
+
+// ServerIPs resolves ServerHosts to a de-duplicated IP list; domains are looked
+// up (A and AAAA) so the pf allowlist follows a server that moved.
+func ServerIPs(ctx context.Context, path string) ([]string, error) {
+	hosts, err := ServerHosts(path)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	var ips []string
+	var errs []string
+	for _, h := range hosts {
+		addrs := []string{h}
+		if net.ParseIP(h) == nil {
+			resolved, err := net.DefaultResolver.LookupHost(ctx, h)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+			addrs = resolved
+		}
+		for _, a := range addrs {
+			if !seen[a] {
+				seen[a] = true
+				ips = append(ips, a)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return ips, fmt.Errorf("resolve sing-box servers: %s", strings.Join(errs, "; "))
+	}
+	return ips, nil
+}
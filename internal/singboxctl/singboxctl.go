@@ -9,29 +9,41 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
 )
 
-// tunNameFromConfig best-effort extracts the TUN interface name from a sing-box JSON config.
-// It looks for an inbound with type=="tun" and a non-empty "interface_name".
-func tunNameFromConfig(path string) (string, error) {
+// tunInbound is what we care about from a sing-box "tun" inbound.
+type tunInbound struct {
+	Name     string       // interface_name (may be empty)
+	Prefixes []*net.IPNet // address / inet4_address / inet6_address
+}
+
+// tunInboundsFromConfig best-effort extracts every TUN inbound (type=="tun") from a
+// sing-box JSON config, in config order, with its "interface_name" and addresses.
+// Both the current "address" and the legacy "inet4_address"/"inet6_address" keys are read;
+// each may be a single string or a list.
+func tunInboundsFromConfig(path string) ([]tunInbound, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	var root map[string]any
 	if err := json.Unmarshal(b, &root); err != nil {
-		return "", err
-	}
-	inb, ok := root["inbounds"].([]any)
-	if !ok {
-		return "", nil
+		return nil, err
 	}
+	inb, _ := root["inbounds"].([]any)
+	var tuns []tunInbound
 	for _, v := range inb {
 		m, ok := v.(map[string]any)
 		if !ok {
@@ -41,39 +53,90 @@ func tunNameFromConfig(path string) (string, error) {
 		if t != "tun" {
 			continue
 		}
-		ifn, _ := m["interface_name"].(string)
-		if ifn != "" {
-			return ifn, nil
+		var tun tunInbound
+		tun.Name, _ = m["interface_name"].(string)
+		for _, key := range []string{"address", "inet4_address", "inet6_address"} {
+			for _, cidr := range stringList(m[key]) {
+				ip, ipnet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+				if err != nil {
+					continue
+				}
+				ipnet.IP = ip.Mask(ipnet.Mask)
+				tun.Prefixes = append(tun.Prefixes, ipnet)
+			}
 		}
+		tuns = append(tuns, tun)
 	}
-	return "", nil
+	return tuns, nil
 }
 
-func utunHasIPv4(name string) (bool, error) {
-	ifi, err := net.InterfaceByName(name)
-	if err != nil {
-		return false, err
+// tunInboundFromConfig is the first TUN inbound (see tunInboundsFromConfig);
+// zero if there is none.
+func tunInboundFromConfig(path string) (tunInbound, error) {
+	tuns, err := tunInboundsFromConfig(path)
+	if len(tuns) == 0 {
+		return tunInbound{}, err
 	}
-	addrs, err := ifi.Addrs()
-	if err != nil {
-		return false, err
-	}
-	for _, a := range addrs {
-		ipnet, ok := a.(*net.IPNet)
-		if !ok || ipnet.IP == nil {
-			continue
+	return tuns[0], err
+}
+
+// tunNameFromConfig best-effort extracts the first TUN interface name from a sing-box JSON config.
+func tunNameFromConfig(path string) (string, error) {
+	tun, err := tunInboundFromConfig(path)
+	return tun.Name, err
+}
+
+// tunNamesFromConfig returns every TUN inbound's interface_name, skipping
+// inbounds that leave it to sing-box.
+func tunNamesFromConfig(path string) ([]string, error) {
+	tuns, err := tunInboundsFromConfig(path)
+	var names []string
+	for _, t := range tuns {
+		if t.Name != "" {
+			names = append(names, t.Name)
 		}
-		if ip4 := ipnet.IP.To4(); ip4 != nil {
-			return true, nil
+	}
+	return names, err
+}
+
+// tunPrefixes is the union of all TUN inbounds' address prefixes.
+func tunPrefixes(tuns []tunInbound) []*net.IPNet {
+	var out []*net.IPNet
+	for _, t := range tuns {
+		out = append(out, t.Prefixes...)
+	}
+	return out
+}
+
+// stringList accepts a JSON string or array of strings.
+func stringList(v any) []string {
+	switch x := v.(type) {
+	case string:
+		return []string{x}
+	case []any:
+		var out []string
+		for _, e := range x {
+			if s, ok := e.(string); ok {
+				out = append(out, s)
+			}
 		}
+		return out
+	}
+	return nil
+}
+
+func utunHasIPv4(name string) (bool, error) {
+	ifc, err := ifaceByName(name)
+	if err != nil {
+		return false, err
 	}
-	return false, nil
+	return ifc.HasIPv4(), nil
 }
 
 func waitForUTUNGone(name string, timeout time.Duration) error {
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
-		_, err := net.InterfaceByName(name)
+		_, err := ifaceByName(name)
 		if err != nil {
 			return nil
 		}
@@ -82,75 +145,219 @@ func waitForUTUNGone(name string, timeout time.Duration) error {
 	return fmt.Errorf("utun %q still exists after %s", name, timeout)
 }
 
+var dryRun bool
+
+// SetDryRun makes the package log instead of starting/stopping sing-box or touching the pidfile.
+// Read-only inspection (pidfile, pgrep, interfaces) still runs.
+func SetDryRun(v bool) { dryRun = v }
+
+// dryRunUTUN is reported when a pinned interface_name is unknown in dry-run mode.
+const dryRunUTUN = "utun-dry-run"
+
 type Status struct {
 	Running         bool
 	PID             int
 	OwnedByUs       bool
 	AdoptedExternal bool
 	NewUTUN         string
+
+	// Addresses on NewUTUN when it was selected (empty if unknown).
+	TunIPv4 string // e.g. 10.7.0.2
+	TunIPv6 string
+	TunCIDR string // IPv4 with prefix, e.g. 10.7.0.2/24
+
+	// When the owned sing-box's current tunnel session started: the pidfile's
+	// mtime, which EnsureRunning sets once the utun it started is ready. Zero
+	// if unknown.
+	StartedAt time.Time
+
+	// Planned is set in dry-run mode for the sing-box EnsureRunning would have
+	// started: Running is true so callers go on to plan pf, but nothing runs.
+	Planned bool
 }
 
-func EnsureRunning(ctx context.Context, cfg *config.Config, timeout time.Duration) (*Status, error) {
-	// Snapshot current utun interfaces so we can detect a *new* one after we start sing-box.
-	beforeSet, beforeNoIPv4, err := listUTUN()
+// TunLabel renders NewUTUN with its address, e.g. "utun66 (10.7.0.2/24)".
+func (s *Status) TunLabel() string {
+	switch {
+	case s == nil || s.NewUTUN == "":
+		return ""
+	case s.TunCIDR != "":
+		return fmt.Sprintf("%s (%s)", s.NewUTUN, s.TunCIDR)
+	case s.TunIPv6 != "":
+		return fmt.Sprintf("%s (%s)", s.NewUTUN, s.TunIPv6)
+	}
+	return s.NewUTUN
+}
+
+// fillTunAddrs copies NewUTUN's current addresses into s (best-effort).
+func (s *Status) fillTunAddrs() {
+	if s.NewUTUN == "" {
+		return
+	}
+	ifc, err := ifaceByName(s.NewUTUN)
 	if err != nil {
-		return nil, fmt.Errorf("list utun (before): %w", err)
+		return
+	}
+	for _, a := range ifc.Addrs {
+		if a == nil {
+			continue
+		}
+		if v4 := a.IP.To4(); v4 != nil {
+			if s.TunIPv4 == "" {
+				ones, _ := a.Mask.Size()
+				s.TunIPv4 = v4.String()
+				s.TunCIDR = fmt.Sprintf("%s/%d", v4, ones)
+			}
+		} else if s.TunIPv6 == "" && !a.IP.IsLinkLocalUnicast() {
+			s.TunIPv6 = a.IP.String()
+		}
+	}
+}
+
+// ResolveTun fills in NewUTUN and its addresses for a running sing-box found by
+// Inspect/InspectExternal, using the same selection as EnsureRunning (pinned
+// interface_name, else tun address subnet, else newest utun with IPv4).
+func ResolveTun(cfg *config.Config, s *Status) {
+	if s == nil || !s.Running {
+		return
+	}
+	if s.NewUTUN == "" {
+		tuns, _ := tunInboundsFromConfig(cfg.SingBoxConfigPath)
+		names, _ := tunNamesFromConfig(cfg.SingBoxConfigPath)
+		if cfg.TunInterfaceName != "" {
+			s.NewUTUN = cfg.TunInterfaceName
+		} else if len(names) > 0 {
+			// The first ready one, as EnsureRunning would pick.
+			s.NewUTUN = names[0]
+			for _, n := range names {
+				if ok, _ := utunHasIPv4(n); ok {
+					s.NewUTUN = n
+					break
+				}
+			}
+		} else if name, err := findUTUNWithIPv4(tunPrefixes(tuns)); err == nil {
+			s.NewUTUN = name
+		}
+	}
+	s.fillTunAddrs()
+}
+
+func EnsureRunning(ctx context.Context, cfg *config.Config, timeout time.Duration) (*Status, error) {
+	// If sing-box config pins tun.interface_name (e.g. utun66), prefer waiting for that interface
+	// (for several tun inbounds, any or all of them: tun_wait_all).
+	// Otherwise the tun addresses are used to pick our utun among unrelated ones.
+	tuns, _ := tunInboundsFromConfig(cfg.SingBoxConfigPath)
+	preferUTUNs, _ := tunNamesFromConfig(cfg.SingBoxConfigPath)
+	prefixes := tunPrefixes(tuns)
+
+	// tun_interface_name is authoritative: no before/after heuristics at all.
+	var beforeSet, beforeNoIPv4 map[string]bool
+	if cfg.TunInterfaceName != "" {
+		if len(preferUTUNs) > 0 && !slices.Contains(preferUTUNs, cfg.TunInterfaceName) {
+			logx.Warnf("warning: tun_interface_name %q differs from sing-box interface_name %q; trusting tun_interface_name",
+				cfg.TunInterfaceName, strings.Join(preferUTUNs, ","))
+		}
+		preferUTUNs = []string{cfg.TunInterfaceName}
+	} else {
+		// Snapshot current utun interfaces so we can detect a *new* one after we start sing-box.
+		var err error
+		beforeSet, beforeNoIPv4, err = listUTUN()
+		if err != nil {
+			return nil, fmt.Errorf("list utun (before): %w", err)
+		}
+	}
+
+	// exited is the started process's exit code; nil when waiting on a running sing-box.
+	wait := func(exited <-chan int) (string, error) {
+		utun, err := waitForUTUNReady(ctx, beforeSet, beforeNoIPv4, timeout, preferUTUNs, cfg.TunWaitAll, prefixes, cfg.TunReadyStable, exited)
+		if err != nil && cfg.TunInterfaceName != "" && !errors.Is(err, ErrSingBoxExited) {
+			return "", fmt.Errorf("pinned tun_interface_name %q not ready (check sing-box interface_name): %w", cfg.TunInterfaceName, err)
+		}
+		return utun, err
 	}
-	// If sing-box config pins tun.interface_name (e.g. utun66), prefer waiting for that interface.
-	preferUTUN, _ := tunNameFromConfig(cfg.SingBoxConfigPath)
 
 	// Helper: if sing-box is already running (owned or external), we usually want the *current* utun,
 	// not necessarily a *new* one.
 	pickReady := func() (string, error) {
-		afterSet, afterNoIPv4, err := listUTUN()
-		if err != nil {
-			return "", fmt.Errorf("list utun (after): %w", err)
-		}
-		if utun := pickAnyUTUNWithIPv4(afterSet, afterNoIPv4); utun != "" {
-			return utun, nil
+		if len(preferUTUNs) == 0 {
+			// With tun prefixes known only a match counts; wait() falls back at its deadline.
+			if utun, err := findBestUTUN(prefixes, nil, len(prefixes) == 0); err == nil {
+				return utun, nil
+			}
 		}
 		// If no utun has IPv4 yet, wait a bit for one to become ready.
-		return waitForUTUNReady(beforeSet, beforeNoIPv4, timeout, preferUTUN)
+		return wait(nil)
 	}
 
 	// 1) pidfile + alive => owned
-	if pid, ok := readPID(cfg.SingBoxPidFile); ok && processAlive(pid) {
+	if pid, ok := readPID(cfg.SingBoxPidFile); ok && ownedAlive(cfg, pid) {
 		utun, err := pickReady()
 		if err != nil {
 			return nil, fmt.Errorf("sing-box running (owned) but no utun: %w", err)
 		}
-		return &Status{PID: pid, NewUTUN: utun, OwnedByUs: true, Running: true}, nil
+		st := &Status{PID: pid, NewUTUN: utun, OwnedByUs: true, Running: true, StartedAt: pidStartedAt(cfg.SingBoxPidFile)}
+		st.fillTunAddrs()
+		return st, nil
 	}
 
 	// 2) Policy B: adopt external if enabled
-	if boolVal(cfg.SingBoxAdoptExternal, true) {
+	if cfg.AdoptExternal() {
 		pid, ok := findExternalSingBoxPID(cfg)
 		if ok && pid > 0 && processAlive(pid) {
 			utun, err := pickReady()
 			if err != nil {
 				return nil, fmt.Errorf("adopted external sing-box pid=%d but no utun: %w", pid, err)
 			}
-			return &Status{PID: pid, NewUTUN: utun, OwnedByUs: false, Running: true}, nil
+			st := &Status{PID: pid, NewUTUN: utun, OwnedByUs: false, Running: true}
+			st.fillTunAddrs()
+			return st, nil
 		}
 	}
 
 	// 3) Start new sing-box and become owner
-	pid, err := startSingBox(ctx, cfg)
+	if cfg.AdoptOnly() {
+		return nil, ErrNotAdoptable
+	}
+	if dryRun {
+		logx.Infof("[dry-run] would start sing-box: %s run -c %s (pidfile=%s log=%s)",
+			cfg.SingBoxPath, cfg.SingBoxConfigPath, cfg.SingBoxPidFile, cfg.SingBoxLogFile)
+		utun := dryRunUTUN
+		if len(preferUTUNs) > 0 {
+			utun = preferUTUNs[0]
+		}
+		return &Status{PID: 0, NewUTUN: utun, OwnedByUs: true, Running: true, Planned: true}, nil
+	}
+	pid, exited, err := startSingBox(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
-	if err := writePID(cfg.SingBoxPidFile, pid); err != nil {
-		_ = stopPID(pid)
+	if err := writePID(cfg.SingBoxPidFile, pid, cfg.SingBoxConfigPath); err != nil {
+		_ = stopPID(ctx, cfg, pid, cfg.SingBoxStopTimeout)
 		return nil, fmt.Errorf("pidfile write: %w", err)
 	}
 
-	utun, err := waitForUTUNReady(beforeSet, beforeNoIPv4, timeout, preferUTUN)
+	utun, err := wait(exited)
 	if err != nil {
-		_ = stopPID(pid)
+		if errors.Is(err, ErrSingBoxExited) {
+			// Died early (bad config, port in use): no point waiting out the timeout.
+			_ = os.Remove(cfg.SingBoxPidFile)
+			return nil, fmt.Errorf("%w (pid=%d, see %s)", err, pid, cfg.SingBoxLogFile)
+		}
+		gone := !processAlive(pid)
+		_ = stopPID(ctx, cfg, pid, cfg.SingBoxStopTimeout)
 		_ = os.Remove(cfg.SingBoxPidFile)
-		return nil, fmt.Errorf("sing-box started but no utun appeared before timeout: %w", err)
+		if gone {
+			return nil, fmt.Errorf("%w (pid=%d, see %s): %w", ErrSingBoxExited, pid, cfg.SingBoxLogFile, err)
+		}
+		return nil, fmt.Errorf("sing-box started but utun not ready: %w", err)
 	}
-	return &Status{PID: pid, NewUTUN: utun, OwnedByUs: true, Running: true}, nil
+	// The tunnel session starts now, not when sing-box was exec'd: the
+	// session age (sing_box_max_lifetime) is measured from here.
+	now := time.Now()
+	_ = os.Chtimes(cfg.SingBoxPidFile, now, now)
+	st := &Status{PID: pid, NewUTUN: utun, OwnedByUs: true, Running: true, StartedAt: pidStartedAt(cfg.SingBoxPidFile)}
+	st.fillTunAddrs()
+	return st, nil
 }
 
 func pickNowReadyUTUN(beforeNoIPv4 map[string]bool, afterNoIPv4 map[string]bool) string {
@@ -162,17 +369,25 @@ func pickNowReadyUTUN(beforeNoIPv4 map[string]bool, afterNoIPv4 map[string]bool)
 	return ""
 }
 
-// findBestUTUN returns the highest-numbered utun interface (e.g. utun66),
-// which is typically the most recently created tunnel on macOS.
-func findBestUTUN() (string, error) {
-	ifaces, err := net.Interfaces()
+// findBestUTUN picks among utun interfaces that have an IPv4 address, except
+// those in skip: first one whose address falls inside subnets (the sing-box
+// tun address), otherwise, with fallback, the highest-numbered one (e.g.
+// utun66), which is typically the most recently created tunnel on macOS.
+func findBestUTUN(subnets []*net.IPNet, skip map[string]bool, fallback bool) (string, error) {
+	utuns, err := listUTUNIfaces()
 	if err != nil {
 		return "", err
 	}
 	bestName := ""
 	bestNum := -1
-	for _, ifc := range ifaces {
-		if !strings.HasPrefix(ifc.Name, "utun") {
+	for _, ifc := range utuns {
+		if !ifc.HasIPv4() || skip[ifc.Name] {
+			continue
+		}
+		if ifaceInSubnets(ifc, subnets) {
+			return ifc.Name, nil
+		}
+		if !fallback {
 			continue
 		}
 		n, ok := utunNumber(ifc.Name)
@@ -185,11 +400,26 @@ func findBestUTUN() (string, error) {
 		}
 	}
 	if bestName == "" {
-		return "", errors.New("no utun interfaces found")
+		return "", errors.New("no utun interface with IPv4 found")
 	}
 	return bestName, nil
 }
 
+// ifaceInSubnets reports whether any IPv4 address of ifc lies in one of subnets.
+func ifaceInSubnets(ifc Iface, subnets []*net.IPNet) bool {
+	for _, a := range ifc.Addrs {
+		if a == nil || a.IP.To4() == nil {
+			continue
+		}
+		for _, sn := range subnets {
+			if sn != nil && sn.Contains(a.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func utunNumber(name string) (int, bool) {
 	if !strings.HasPrefix(name, "utun") {
 		return 0, false
@@ -201,53 +431,157 @@ func utunNumber(name string) (int, bool) {
 	return n, true
 }
 
-func StopIfOwned(cfg *config.Config) error {
+func StopIfOwned(ctx context.Context, cfg *config.Config) error {
 
 	pid, ok := readPID(cfg.SingBoxPidFile)
 	if !ok {
 		return nil // we don't own anything
 	}
-	if !processAlive(pid) {
-		_ = os.Remove(cfg.SingBoxPidFile)
+	if dryRun {
+		logx.Infof("[dry-run] would stop owned sing-box pid=%d and remove %s", pid, cfg.SingBoxPidFile)
 		return nil
 	}
-	if err := stopPID(pid); err != nil {
+	if !ownedAlive(cfg, pid) {
+		dropStalePidfile(cfg, pid)
+		return nil
+	}
+	if err := stopPID(ctx, cfg, pid, cfg.SingBoxStopTimeout); err != nil {
 		return err
 	}
 	_ = os.Remove(cfg.SingBoxPidFile)
 	return nil
 }
 
-func stopPID(pid int) error {
-	// sing-box is started in its own process group (Setpgid: true).
-	// Prefer signaling the *process group* so we don't leave helpers/zombies behind.
-	term := func() { _ = syscall.Kill(-pid, syscall.SIGTERM) }
-	kill := func() { _ = syscall.Kill(-pid, syscall.SIGKILL) }
+// stopPID sends each of stop_signals (default SIGTERM) to sing-box, waiting up
+// to grace after each for it to exit, then escalates to SIGKILL unless
+// singbox_stop_sigkill is false. stop_signal_scope picks whether the process
+// group is signaled too.
+func stopPID(ctx context.Context, cfg *config.Config, pid int, grace time.Duration) error {
+	// sing-box is started in its own process group (Setpgid: true). By default
+	// the *process group* is signaled so we don't leave helpers/zombies behind.
+	group := cfg.StopSignalScope != config.StopScopePID
+	sigs := cfg.StopSignalList()
+	for _, sig := range sigs {
+		if err := signalPID(pid, sig, group); errors.Is(err, syscall.EPERM) {
+			return fmt.Errorf("signal sing-box pid %d: %w", pid, vpnerr.ErrPermission)
+		}
+		if waitPIDExit(ctx, pid, grace) {
+			return nil
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		logx.Infof("[vpnrd] sing-box pid %d still running %s after %s", pid, grace, signalName(sig))
+	}
+	if !cfg.StopSIGKILL() {
+		return fmt.Errorf("sing-box pid %d still running after %s (SIGKILL disabled)", pid, signalNames(sigs))
+	}
 
-	// Best-effort TERM (pgid first, then pid).
-	term()
-	if p, err := os.FindProcess(pid); err == nil {
-		_ = p.Signal(syscall.SIGTERM)
+	logx.Warnf("sing-box pid %d ignored %s; sending SIGKILL", pid, signalNames(sigs))
+	_ = signalPID(pid, syscall.SIGKILL, group)
+	if waitPIDExit(context.Background(), pid, 1*time.Second) {
+		return nil
 	}
+	return fmt.Errorf("failed to stop sing-box pid %d", pid)
+}
 
-	deadline := time.Now().Add(3 * time.Second)
-	for time.Now().Before(deadline) {
-		if !processAlive(pid) {
-			return nil
-		}
-		time.Sleep(150 * time.Millisecond)
+// signalPID signals pid's process group when group is set, then pid
+// (best-effort). The error is the direct kill's, so callers can tell EPERM
+// (not root) from a process that's gone.
+func signalPID(pid int, sig syscall.Signal, group bool) error {
+	if group {
+		_ = syscall.Kill(-pid, sig)
 	}
+	return syscall.Kill(pid, sig)
+}
 
-	// Force kill (pgid first, then pid).
-	kill()
-	if p, err := os.FindProcess(pid); err == nil {
-		_ = p.Signal(syscall.SIGKILL)
+// signalName is "SIGTERM" for syscall.SIGTERM (the signals stop_signals allows, plus KILL).
+func signalName(sig syscall.Signal) string {
+	switch sig {
+	case syscall.SIGHUP:
+		return "SIGHUP"
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGQUIT:
+		return "SIGQUIT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	case syscall.SIGUSR1:
+		return "SIGUSR1"
+	case syscall.SIGUSR2:
+		return "SIGUSR2"
+	case syscall.SIGKILL:
+		return "SIGKILL"
 	}
-	time.Sleep(200 * time.Millisecond)
-	if processAlive(pid) {
-		return fmt.Errorf("failed to stop sing-box pid %d", pid)
+	return sig.String()
+}
+
+// signalNames joins signalName over sigs, e.g. "SIGINT, SIGTERM".
+func signalNames(sigs []syscall.Signal) string {
+	names := make([]string, len(sigs))
+	for i, s := range sigs {
+		names[i] = signalName(s)
+	}
+	return strings.Join(names, ", ")
+}
+
+// sleepCtx waits for d or until ctx is done; it reports whether the full wait elapsed.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// Utun wait spacing: polling every utunPollInterval, or with an interface
+// watcher as a fallback every utunWatchFallback between its wake-ups.
+const (
+	utunPollInterval  = 200 * time.Millisecond
+	utunWatchFallback = time.Second
+)
+
+// pollSleep waits until the next utun check: one poll interval, or with a
+// watcher (wake != nil) until it reports a change, at most utunWatchFallback.
+// It never sleeps past until (zero = no limit) and fails early when ctx is
+// done or the sing-box being waited for exits (exited may be nil).
+func pollSleep(ctx context.Context, exited <-chan int, wake <-chan struct{}, until time.Time) error {
+	d := utunPollInterval
+	if wake != nil {
+		d = utunWatchFallback
+	}
+	if !until.IsZero() {
+		d = max(min(d, time.Until(until)), 0)
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case code := <-exited:
+		return &exitedError{code: code}
+	case <-wake:
+		return nil
+	case <-t.C:
+		return nil
+	}
+}
+
+// waitPIDExit polls until pid is gone (true) or timeout/ctx ends (false).
+func waitPIDExit(ctx context.Context, pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !processAlive(pid) {
+			return true
+		}
+		if !time.Now().Before(deadline) || ctx.Err() != nil {
+			return false
+		}
+		time.Sleep(150 * time.Millisecond)
 	}
-	return nil
 }
 
 func processAlive(pid int) bool {
@@ -267,141 +601,353 @@ func processAlive(pid int) bool {
 	return false
 }
 
+// ownedAlive reports whether pid (read from the pidfile) is still our sing-box.
+// After a crash the PID can be reused by an unrelated process, so the process's
+// "-c" argument must be the config the pidfile records (see writePID); a
+// pidfile without one matches any of cfg.SingBoxConfigPaths. It only looks:
+// callers that act on the pidfile remove a stale one with dropStalePidfile.
+func ownedAlive(cfg *config.Config, pid int) bool {
+	if !processAlive(pid) {
+		return false
+	}
+	cmdline, err := processCommand(pid)
+	if err != nil {
+		// Can't tell (ps failed or raced the exit); keep trusting the pidfile.
+		return true
+	}
+	arg, ok := configArg(cmdline)
+	if !ok {
+		return false
+	}
+	if want := pidConfigPath(cfg.SingBoxPidFile); want != "" {
+		return arg == want
+	}
+	return slices.Contains(cfg.SingBoxConfigPaths(), arg)
+}
+
+// dropStalePidfile removes a pidfile whose pid is dead or no longer our
+// sing-box (see ownedAlive), logging why.
+func dropStalePidfile(cfg *config.Config, pid int) {
+	reason := "process gone"
+	if processAlive(pid) {
+		cmdline, _ := processCommand(pid)
+		reason = fmt.Sprintf("pid reused by %q", cmdline)
+	}
+	logx.Warnf("[vpnrd] event=pidfile_stale pid=%d pidfile=%q reason=%s", pid, cfg.SingBoxPidFile, reason)
+	if !dryRun {
+		_ = os.Remove(cfg.SingBoxPidFile)
+	}
+}
+
+// MigrateLegacyPidfile moves a pidfile left at the old default location
+// (config.LegacySingBoxPidFile) to the default singbox_pid_file, so a sing-box
+// started by an older vpnrd stays owned. If the move is not possible (dry run,
+// no permission) the legacy pidfile is used in place for this run.
+func MigrateLegacyPidfile(cfg *config.Config) {
+	if cfg.SingBoxPidFile != config.DefaultSingBoxPidFile() {
+		return // set explicitly: not ours to second-guess
+	}
+	migrateLegacyPidfile(cfg, config.LegacySingBoxPidFile())
+}
+
+func migrateLegacyPidfile(cfg *config.Config, legacy string) {
+	if _, err := os.Stat(cfg.SingBoxPidFile); err == nil {
+		return
+	}
+	pid, ok := readPID(legacy)
+	if !ok {
+		return
+	}
+	startedAt := pidStartedAt(legacy)
+	if dryRun {
+		logx.Infof("[vpnrd] (dry run) would move legacy pidfile %q (pid=%d) to %q; using it in place", legacy, pid, cfg.SingBoxPidFile)
+		cfg.SingBoxPidFile = legacy
+		return
+	}
+	err := writePID(cfg.SingBoxPidFile, pid, pidConfigPath(legacy))
+	if err == nil {
+		// Keep the mtime: it is when the tunnel session started (pidStartedAt).
+		_ = os.Chtimes(cfg.SingBoxPidFile, startedAt, startedAt)
+		err = os.Remove(legacy)
+	}
+	if err != nil {
+		logx.Warnf("[vpnrd] event=pidfile_migrate_failed pid=%d from=%q to=%q err=%v; using the legacy pidfile in place", pid, legacy, cfg.SingBoxPidFile, err)
+		cfg.SingBoxPidFile = legacy
+		return
+	}
+	logx.Infof("[vpnrd] event=pidfile_migrated pid=%d from=%q to=%q", pid, legacy, cfg.SingBoxPidFile)
+}
+
+// configArg returns the config path from a "sing-box run -c <path>" command
+// line. ps joins argv with spaces, so the path runs to the end of the line
+// (PlannedStart puts -c last) or to the next " -" flag.
+func configArg(cmdline string) (string, bool) {
+	_, rest, ok := strings.Cut(cmdline, " -c ")
+	if !ok {
+		return "", false
+	}
+	if i := strings.Index(rest, " -"); i >= 0 {
+		rest = rest[:i]
+	}
+	return rest, rest != ""
+}
+
+// processCommand returns pid's full command line (ps -o command=).
+func processCommand(pid int) (string, error) {
+	out, err := control.Output(context.Background(), "ps", "-o", "command=", "-p", strconv.Itoa(pid))
+	if err != nil {
+		return "", err
+	}
+	cmdline := strings.TrimSpace(string(out))
+	if cmdline == "" {
+		return "", fmt.Errorf("ps: no command for pid %d", pid)
+	}
+	return cmdline, nil
+}
+
+// readPID reads the pidfile at path. A pidfile written before the last boot
+// is ignored (and removed): its PID belonged to a process that is gone, and
+// anything running under that number now is unrelated.
 func readPID(path string) (int, bool) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		return 0, false
 	}
-	s := strings.TrimSpace(string(b))
-	n, err := strconv.Atoi(s)
+	first, _, _ := strings.Cut(string(b), "\n")
+	n, err := strconv.Atoi(strings.TrimSpace(first))
 	if err != nil || n <= 1 {
 		return 0, false
 	}
+	if boot, err := bootTime(); err == nil {
+		if mt := pidStartedAt(path); !mt.IsZero() && mt.Before(boot) {
+			logx.Warnf("[vpnrd] event=pidfile_stale pid=%d pidfile=%q reason=written before last boot (%s < %s); stale pidfile ignored",
+				n, path, mt.Format(time.RFC3339), boot.Format(time.RFC3339))
+			if !dryRun {
+				_ = os.Remove(path)
+			}
+			return 0, false
+		}
+	} else {
+		logx.Debugf("[vpnrd] boot time unknown, pidfile age not checked: %v", err)
+	}
 	return n, true
 }
 
-func writePID(path string, pid int) error {
-	return os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0o644)
+// pidStartedAt is the pidfile's mtime: writePID runs right after sing-box
+// starts and EnsureRunning touches it again when its utun is ready.
+func pidStartedAt(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
 }
 
-func pickAnyUTUNWithIPv4(present map[string]bool, noIPv4 map[string]bool) string {
-	for ifname := range present {
-		if ifname == "" {
-			continue
-		}
-		if !noIPv4[ifname] {
-			return ifname
-		}
+// writePID writes the pidfile: the pid, then the config sing-box was started
+// with, so ownership survives a failover to another singbox_configs entry.
+func writePID(path string, pid int, configPath string) error {
+	// Runtime dirs are emptied at boot, so our subdirectory may be gone.
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
 	}
-	return ""
+	return os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"+configPath+"\n"), 0o644)
+}
+
+// pidConfigPath returns the config path recorded in the pidfile ("" for a
+// pidfile from an older vpnrd that only holds the pid).
+func pidConfigPath(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	_, rest, _ := strings.Cut(string(b), "\n")
+	p, _, _ := strings.Cut(rest, "\n")
+	return strings.TrimSpace(p)
 }
 
 // listUTUN returns:
 // - a set of utun interface names that exist now
 // - a set of utun interface names that exist now BUT do not yet have an IPv4 address
 func listUTUN() (map[string]bool, map[string]bool, error) {
-	ifaces, err := net.Interfaces()
+	utuns, err := listUTUNIfaces()
 	if err != nil {
 		return nil, nil, err
 	}
 	set := map[string]bool{}
 	noIPv4 := map[string]bool{}
-	for _, iface := range ifaces {
-		if !strings.HasPrefix(iface.Name, "utun") {
-			continue
-		}
-		set[iface.Name] = true
-		addrs, err := iface.Addrs()
-		if err != nil {
-			continue
-		}
-		hasV4 := false
-		for _, a := range addrs {
-			ip, _, err := net.ParseCIDR(a.String())
-			if err != nil {
-				continue
-			}
-			if ip.To4() != nil {
-				hasV4 = true
-				break
-			}
-		}
-		if !hasV4 {
-			noIPv4[iface.Name] = true
+	for _, ifc := range utuns {
+		set[ifc.Name] = true
+		if !ifc.HasIPv4() {
+			noIPv4[ifc.Name] = true
 		}
 	}
 	return set, noIPv4, nil
 }
 
 func waitForUTUNReady(
+	ctx context.Context,
 	beforeSet map[string]bool,
 	beforeNoIPv4 map[string]bool,
 	timeout time.Duration,
-	preferUTUN string,
+	preferUTUNs []string,
+	waitAll bool,
+	subnets []*net.IPNet,
+	stable time.Duration,
+	exited <-chan int,
 ) (string, error) {
 	deadline := time.Now().Add(timeout)
 
-	// If sing-box config pins interface_name, wait for *that* interface to exist and have IPv4.
-	if preferUTUN != "" {
+	// Debounce: during sing-box startup a utun can get a transient IPv4, lose it,
+	// then get its final one. Only accept an interface whose IPv4 set stayed the
+	// same for `stable` across consecutive polls.
+	var (
+		candName  string
+		candAddrs string
+		candSince time.Time
+	)
+	settled := func(name string) bool {
+		var addrs string
+		for _, n := range strings.Split(name, ",") {
+			addrs += ipv4Key(n) + ";"
+		}
+		if name != candName || addrs != candAddrs {
+			candName, candAddrs, candSince = name, addrs, time.Now()
+		}
+		return time.Since(candSince) >= stable
+	}
+
+	// Wake up on routing-socket events where available; the poll is the fallback.
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	prefer := ""
+	if len(preferUTUNs) == 1 {
+		prefer = preferUTUNs[0]
+	}
+	wake, err := watchInterfaceReady(wctx, prefer)
+	if err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		logx.Debugf("[vpnrd] interface watch unavailable, polling: %v", err)
+	}
+	// Sleep no later than the deadline or the moment the candidate would settle.
+	nextCheck := func() time.Time {
+		if candName != "" && candSince.Add(stable).Before(deadline) {
+			return candSince.Add(stable)
+		}
+		return deadline
+	}
+
+	// If sing-box config pins interface_name(s), wait for *those* interfaces to exist and have
+	// IPv4: the first ready one, or with waitAll every one (the first is then returned).
+	if len(preferUTUNs) > 0 {
+		label := strings.Join(preferUTUNs, ",")
+		seen := false
 		for time.Now().Before(deadline) {
-			ok, err := utunHasIPv4(preferUTUN)
-			if err == nil && ok {
-				return preferUTUN, nil
+			var ready []string
+			for _, name := range preferUTUNs {
+				ok, err := utunHasIPv4(name)
+				if err == nil {
+					seen = true
+				}
+				if err == nil && ok {
+					ready = append(ready, name)
+				}
 			}
-			time.Sleep(200 * time.Millisecond)
+			switch {
+			case waitAll && len(ready) == len(preferUTUNs):
+				if settled(label) {
+					return preferUTUNs[0], nil
+				}
+			case !waitAll && len(ready) > 0:
+				if settled(ready[0]) {
+					return ready[0], nil
+				}
+			default:
+				candName = ""
+			}
+			if err := pollSleep(ctx, exited, wake, nextCheck()); err != nil {
+				return "", err
+			}
+		}
+		if seen {
+			return "", fmt.Errorf("preferred utun %q: %w within %s", label, ErrUTUNNoAddress, timeout)
+		}
+		return "", fmt.Errorf("preferred utun %q: %w within %s", label, ErrUTUNNotCreated, timeout)
+	}
+
+	// A utun that already had IPv4 before sing-box started belongs to someone
+	// else (Tailscale, another VPN, Personal Hotspot).
+	skip := map[string]bool{}
+	for name := range beforeSet {
+		if !beforeNoIPv4[name] {
+			skip[name] = true
 		}
-		return "", fmt.Errorf("preferred utun %q not ready before timeout", preferUTUN)
 	}
 
+	// Candidates without IPv4 that we saw at some point: brand new utuns, or
+	// pre-existing ones that had no IPv4 in the snapshot.
+	var pending []string
 	for time.Now().Before(deadline) {
-		utun, err := findUTUNWithIPv4()
+		// Accepts a brand new utun or a previously-existing one that became
+		// ready. With tun prefixes known only a match counts while polling: a
+		// foreign utun must not settle before ours gets its address.
+		utun, err := findBestUTUN(subnets, skip, len(subnets) == 0)
 		if err == nil && utun != "" {
-			if beforeSet == nil {
+			if settled(utun) {
 				return utun, nil
 			}
-
-			// Accept:
-			// - a brand new utun (not in the snapshot), OR
-			// - a previously-existing utun that didn't have IPv4 yet (became ready)
-			if !beforeSet[utun] || (beforeNoIPv4 != nil && beforeNoIPv4[utun]) {
-				return utun, nil
+		} else {
+			candName = ""
+		}
+		if len(pending) == 0 {
+			if set, noIPv4, err := listUTUN(); err == nil {
+				for name := range noIPv4 {
+					if set[name] && (!beforeSet[name] || beforeNoIPv4[name]) {
+						pending = append(pending, name)
+					}
+				}
 			}
+		}
+		if err := pollSleep(ctx, exited, wake, nextCheck()); err != nil {
+			return "", err
+		}
+	}
 
-			// If only one tunnel exists, this is still good enough.
+	// Nothing in the tun prefixes: fall back to the highest-numbered new utun.
+	if len(subnets) > 0 {
+		if utun, err := findBestUTUN(nil, skip, true); err == nil {
+			logx.Warnf("[vpnrd] event=utun_prefix_mismatch utun=%s: no utun in the sing-box tun prefix within %s; using the highest-numbered new one", utun, timeout)
 			return utun, nil
 		}
-		time.Sleep(200 * time.Millisecond)
 	}
 
-	return "", fmt.Errorf("no utun with IPv4 within %s", timeout)
+	if candName != "" {
+		return "", fmt.Errorf("%w: %s IPv4 did not stay stable for %s within %s", ErrUTUNNoAddress, candName, stable, timeout)
+	}
+	if len(pending) > 0 {
+		sort.Strings(pending)
+		return "", fmt.Errorf("%w within %s (seen: %s)", ErrUTUNNoAddress, timeout, strings.Join(pending, ","))
+	}
+	return "", fmt.Errorf("%w within %s", ErrUTUNNotCreated, timeout)
 }
 
-func findUTUNWithIPv4() (string, error) {
-	ifaces, err := net.Interfaces()
+// ipv4Key returns name's IPv4 addresses as a sorted, comma-joined string ("" if none/unknown).
+func ipv4Key(name string) string {
+	ifc, err := ifaceByName(name)
 	if err != nil {
-		return "", err
+		return ""
 	}
-
-	for _, ifc := range ifaces {
-		if !strings.HasPrefix(ifc.Name, "utun") {
-			continue
-		}
-		addrs, err := ifc.Addrs()
-		if err != nil {
-			continue
-		}
-		for _, a := range addrs {
-			ip, _, err := net.ParseCIDR(a.String())
-			if err != nil {
-				continue
-			}
-			if ip.To4() != nil {
-				return ifc.Name, nil // first utun that has IPv4
-			}
+	var out []string
+	for _, a := range ifc.Addrs {
+		if a != nil && a.IP.To4() != nil {
+			out = append(out, a.IP.String())
 		}
 	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
 
-	return "", errors.New("no utun interface with IPv4 found")
+// findUTUNWithIPv4 returns the best ready utun (see findBestUTUN).
+func findUTUNWithIPv4(subnets []*net.IPNet) (string, error) {
+	return findBestUTUN(subnets, nil, true)
 }
 
 func Inspect(cfg *config.Config) (*Status, error) {
@@ -409,34 +955,34 @@ func Inspect(cfg *config.Config) (*Status, error) {
 	if !ok {
 		return &Status{Running: false, PID: 0, OwnedByUs: false}, nil
 	}
+	if ownedAlive(cfg, pid) {
+		return &Status{Running: true, PID: pid, OwnedByUs: true, StartedAt: pidStartedAt(cfg.SingBoxPidFile)}, nil
+	}
 	if processAlive(pid) {
-		return &Status{Running: true, PID: pid, OwnedByUs: true}, nil
+		// PID reused by a foreign process: nothing is ours. The stale pidfile
+		// stays; StopIfOwned or the next start replaces it.
+		return &Status{Running: false, PID: 0, OwnedByUs: false}, nil
 	}
 	// pidfile exists but process dead
 	return &Status{Running: false, PID: pid, OwnedByUs: true}, nil
 }
 
 func InspectExternal(ctx context.Context, cfg *config.Config) (*Status, error) {
-	// Look for: sing-box run -c <cfg.SingBoxConfigPath>
-	// pgrep -f searches the full command line.
-	pattern := fmt.Sprintf("sing-box run -c %s", cfg.SingBoxConfigPath)
-
-	out, err := exec.CommandContext(ctx, "pgrep", "-f", pattern).Output()
-	if err != nil {
-		// Not found is normal (pgrep exits non-zero).
-		return &Status{Running: false, PID: 0, OwnedByUs: false}, nil
-	}
-
-	// pgrep can return multiple PIDs (one per line); take the first.
-	lines := strings.Fields(string(out))
-	if len(lines) == 0 {
-		return &Status{Running: false, PID: 0, OwnedByUs: false}, nil
+	// Look for: sing-box run -c <path> in the full command line, for the active
+	// config and then the other failover endpoints.
+	var pids []int
+	for _, path := range cfg.SingBoxConfigPaths() {
+		found, err := findPIDs(ctx, "sing-box run -c "+path, false)
+		if err != nil {
+			logx.Debugf("[vpnrd] external sing-box lookup: %v", err)
+		}
+		pids = append(pids, found...)
 	}
-
-	pid, convErr := strconv.Atoi(lines[0])
-	if convErr != nil || pid <= 1 {
+	// There can be several matches; take the first.
+	if len(pids) == 0 {
 		return &Status{Running: false, PID: 0, OwnedByUs: false}, nil
 	}
+	pid := pids[0]
 
 	return &Status{
 		Running:   processAlive(pid),
@@ -446,28 +992,14 @@ func InspectExternal(ctx context.Context, cfg *config.Config) (*Status, error) {
 }
 
 func findExternalSingBoxPID(cfg *config.Config) (int, bool) {
-	// Uses pgrep to find: sing-box run -c <cfg.SingBoxConfigPath>
-	out, err := exec.Command("pgrep", "-af", "sing-box").Output()
-	if err != nil {
-		return 0, false
-	}
-	lines := strings.Split(string(out), "\n")
-	needle := "sing-box run -c " + cfg.SingBoxConfigPath
-	for _, ln := range lines {
-		ln = strings.TrimSpace(ln)
-		if ln == "" {
+	// Find: sing-box run -c <path>, for any endpoint (see InspectExternal).
+	for _, path := range cfg.SingBoxConfigPaths() {
+		pids, err := findPIDs(context.Background(), "sing-box run -c "+path, false)
+		if err != nil {
+			logx.Debugf("[vpnrd] external sing-box lookup: %v", err)
 			continue
 		}
-		if strings.Contains(ln, needle) {
-			// line format: "<pid> <cmdline...>"
-			fields := strings.Fields(ln)
-			if len(fields) < 2 {
-				continue
-			}
-			pid, err := strconv.Atoi(fields[0])
-			if err != nil {
-				continue
-			}
+		for _, pid := range pids {
 			if processAlive(pid) {
 				return pid, true
 			}
@@ -476,13 +1008,6 @@ func findExternalSingBoxPID(cfg *config.Config) (int, bool) {
 	return 0, false
 }
 
-func boolVal(p *bool, def bool) bool {
-	if p == nil {
-		return def
-	}
-	return *p
-}
-
 func pickNewUTUN(before, after []string) string {
 	beforeSet := map[string]struct{}{}
 	for _, x := range before {
@@ -502,7 +1027,7 @@ func pickNewUTUN(before, after []string) string {
 
 // adopt external sing-box process by matching "-c <configpath>" in process args
 func findExternalByConfig(configPath string) (int, bool) {
-	out, err := exec.Command("ps", "aux").Output()
+	out, err := control.Output(context.Background(), "ps", "aux")
 	if err != nil {
 		return 0, false
 	}
@@ -528,15 +1053,46 @@ func findExternalByConfig(configPath string) (int, bool) {
 	return 0, false
 }
 
-func startSingBox(ctx context.Context, cfg *config.Config) (int, error) {
-	cmd := exec.CommandContext(ctx, cfg.SingBoxPath, "run", "-c", cfg.SingBoxConfigPath)
+// StartCommand is how vpnrd launches sing-box: its argv, run with vpnrd's
+// environment in a process group of its own.
+type StartCommand struct {
+	Argv    []string `json:"argv"`
+	LogFile string   `json:"log_file,omitempty"` // stdout and stderr are appended here; "" = discarded
+}
+
+// PlannedStart returns the command startSingBox runs for cfg.
+func PlannedStart(cfg *config.Config) StartCommand {
+	return StartCommand{
+		Argv:    []string{cfg.SingBoxPath, "run", "-c", cfg.SingBoxConfigPath},
+		LogFile: cfg.SingBoxLogFile,
+	}
+}
+
+// String renders c as a shell command line, output redirection included.
+func (c StartCommand) String() string {
+	out := "/dev/null"
+	if c.LogFile != "" {
+		out = control.CommandLine(c.LogFile)
+	}
+	return control.CommandLine(c.Argv[0], c.Argv[1:]...) + " >>" + out + " 2>&1"
+}
+
+// startSingBox starts sing-box (PlannedStart) in the background. exited
+// receives its exit code once it ends.
+func startSingBox(ctx context.Context, cfg *config.Config) (pid int, exited <-chan int, err error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	plan := PlannedStart(cfg)
+	// Not CommandContext: sing-box must outlive the operation (and vpnrd) that started it.
+	cmd := exec.Command(plan.Argv[0], plan.Argv[1:]...)
 
 	// Do NOT inherit vpnrd's stdout/stderr, otherwise sing-box logs will "mix" into vpnrd output.
 	// If SingBoxLogFile is set, append logs there. Otherwise discard.
-	if cfg.SingBoxLogFile != "" {
-		f, err := os.OpenFile(cfg.SingBoxLogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if plan.LogFile != "" {
+		f, err := os.OpenFile(plan.LogFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 		if err != nil {
-			return 0, fmt.Errorf("open sing-box log file %q: %w", cfg.SingBoxLogFile, err)
+			return 0, nil, fmt.Errorf("open sing-box log file %q: %w", plan.LogFile, err)
 		}
 		cmd.Stdout = f
 		cmd.Stderr = f
@@ -549,12 +1105,18 @@ func startSingBox(ctx context.Context, cfg *config.Config) (int, error) {
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 
 	if err := cmd.Start(); err != nil {
-		return 0, fmt.Errorf("sing-box start: %w", err)
+		if errors.Is(err, os.ErrPermission) {
+			err = vpnerr.Class(vpnerr.ErrPermission, err)
+		}
+		return 0, nil, fmt.Errorf("sing-box start: %w", err)
 	}
-	pid := cmd.Process.Pid
 
 	// Reap the child; otherwise it can become a zombie after exit.
-	go func() { _ = cmd.Wait() }()
+	done := make(chan int, 1)
+	go func() {
+		_ = cmd.Wait()
+		done <- cmd.ProcessState.ExitCode()
+	}()
 
-	return pid, nil
+	return cmd.Process.Pid, done, nil
 }
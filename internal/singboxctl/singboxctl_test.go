@@ -0,0 +1,208 @@
+package singboxctl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeLister is an IfaceLister backed by a function, so a test can change the
+// interfaces from one poll to the next.
+type fakeLister func() ([]Iface, error)
+
+func (f fakeLister) List() ([]Iface, error) { return f() }
+
+// staticLister always returns ifs.
+func staticLister(ifs ...Iface) IfaceLister {
+	return fakeLister(func() ([]Iface, error) { return ifs, nil })
+}
+
+// useLister installs l for the duration of the test.
+func useLister(t *testing.T, l IfaceLister) {
+	t.Helper()
+	prev := SetIfaceLister(l)
+	t.Cleanup(func() { SetIfaceLister(prev) })
+}
+
+// iface builds an Iface from CIDR-notation addresses ("172.19.0.1/30").
+func iface(t *testing.T, name string, cidrs ...string) Iface {
+	t.Helper()
+	ifc := Iface{Name: name}
+	for _, c := range cidrs {
+		ip, n, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.IP = ip
+		ifc.Addrs = append(ifc.Addrs, n)
+	}
+	return ifc
+}
+
+func prefixes(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var out []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, n)
+	}
+	return out
+}
+
+func TestFindBestUTUN(t *testing.T) {
+	tests := []struct {
+		name     string
+		ifs      []Iface
+		subnets  []string
+		skip     map[string]bool
+		fallback bool
+		want     string // "" = error
+	}{
+		{
+			name:     "subnet match beats a higher number",
+			ifs:      []Iface{iface(t, "utun4", "172.19.0.1/30"), iface(t, "utun9", "100.64.0.2/32")},
+			subnets:  []string{"172.19.0.0/30"},
+			fallback: true,
+			want:     "utun4",
+		},
+		{
+			name:     "highest number without subnets",
+			ifs:      []Iface{iface(t, "utun4", "10.0.0.1/32"), iface(t, "utun12", "10.0.0.2/32"), iface(t, "utun9", "10.0.0.3/32")},
+			fallback: true,
+			want:     "utun12",
+		},
+		{
+			name:    "no fallback: a foreign utun is not picked",
+			ifs:     []Iface{iface(t, "utun9", "100.64.0.2/32")},
+			subnets: []string{"172.19.0.0/30"},
+			want:    "",
+		},
+		{
+			name:     "skipped utun is never picked",
+			ifs:      []Iface{iface(t, "utun9", "100.64.0.2/32"), iface(t, "utun3", "10.0.0.1/32")},
+			skip:     map[string]bool{"utun9": true},
+			fallback: true,
+			want:     "utun3",
+		},
+		{
+			name:     "utun without IPv4 is not ready",
+			ifs:      []Iface{iface(t, "utun4", "fd00::1/64"), iface(t, "en0", "192.168.1.2/24")},
+			fallback: true,
+			want:     "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useLister(t, staticLister(tt.ifs...))
+			got, err := findBestUTUN(prefixes(t, tt.subnets...), tt.skip, tt.fallback)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("got %q, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("got %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// A foreign utun that already had IPv4 must not win while sing-box's own utun
+// is still waiting for its address.
+func TestWaitForUTUNReadyIgnoresForeignUTUN(t *testing.T) {
+	foreign := iface(t, "utun9", "100.64.0.2/32")
+	polls := 0
+	useLister(t, fakeLister(func() ([]Iface, error) {
+		polls++
+		if polls < 4 {
+			return []Iface{foreign, iface(t, "utun4")}, nil
+		}
+		return []Iface{foreign, iface(t, "utun4", "172.19.0.1/30")}, nil
+	}))
+	before, beforeNoIPv4, err := listUTUN()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := waitForUTUNReady(context.Background(), before, beforeNoIPv4, 3*time.Second, nil, false,
+		prefixes(t, "172.19.0.0/30"), 0, nil)
+	if err != nil || got != "utun4" {
+		t.Fatalf("got %q, %v; want utun4", got, err)
+	}
+}
+
+func TestWaitForUTUNReadyFallback(t *testing.T) {
+	t.Run("new utun outside the prefix after the deadline", func(t *testing.T) {
+		useLister(t, staticLister(iface(t, "utun9", "100.64.0.2/32")))
+		start := time.Now()
+		got, err := waitForUTUNReady(context.Background(), map[string]bool{}, map[string]bool{}, 300*time.Millisecond, nil, false,
+			prefixes(t, "172.19.0.0/30"), 0, nil)
+		if err != nil || got != "utun9" {
+			t.Fatalf("got %q, %v; want utun9", got, err)
+		}
+		if time.Since(start) < 300*time.Millisecond {
+			t.Fatalf("fell back after %s, before the deadline", time.Since(start))
+		}
+	})
+	t.Run("pre-existing utun is never the fallback", func(t *testing.T) {
+		useLister(t, staticLister(iface(t, "utun9", "100.64.0.2/32")))
+		before, beforeNoIPv4, _ := listUTUN()
+		_, err := waitForUTUNReady(context.Background(), before, beforeNoIPv4, 300*time.Millisecond, nil, false,
+			prefixes(t, "172.19.0.0/30"), 0, nil)
+		if !errors.Is(err, ErrUTUNNotCreated) {
+			t.Fatalf("err = %v, want ErrUTUNNotCreated", err)
+		}
+	})
+}
+
+func TestWaitForUTUNReadyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefer []string
+		ifs    []Iface
+		want   error
+	}{
+		{name: "no utun appears", ifs: []Iface{iface(t, "en0", "192.168.1.2/24")}, want: ErrUTUNNotCreated},
+		{name: "new utun without IPv4", ifs: []Iface{iface(t, "utun4", "fe80::1/64")}, want: ErrUTUNNoAddress},
+		{name: "pinned utun missing", prefer: []string{"utun66"}, ifs: []Iface{iface(t, "utun4", "10.0.0.1/32")}, want: ErrUTUNNotCreated},
+		{name: "pinned utun without IPv4", prefer: []string{"utun66"}, ifs: []Iface{iface(t, "utun66")}, want: ErrUTUNNoAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The before snapshot is empty: every utun the lister shows is new.
+			useLister(t, staticLister(tt.ifs...))
+			_, err := waitForUTUNReady(context.Background(), map[string]bool{}, map[string]bool{}, 250*time.Millisecond,
+				tt.prefer, false, nil, 0, nil)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			other := ErrUTUNNoAddress
+			if tt.want == ErrUTUNNoAddress {
+				other = ErrUTUNNotCreated
+			}
+			if errors.Is(err, other) {
+				t.Fatalf("err = %v also matches %v", err, other)
+			}
+		})
+	}
+}
+
+func TestWaitForUTUNReadyPinned(t *testing.T) {
+	polls := 0
+	useLister(t, fakeLister(func() ([]Iface, error) {
+		polls++
+		if polls < 3 {
+			return nil, nil
+		}
+		return []Iface{iface(t, "utun66", "172.19.0.1/30")}, nil
+	}))
+	got, err := waitForUTUNReady(context.Background(), nil, nil, 3*time.Second, []string{"utun66"}, false, nil, 0, nil)
+	if err != nil || got != "utun66" {
+		t.Fatalf("got %q, %v; want utun66", got, err)
+	}
+}
@@ -0,0 +1,104 @@
+package singboxctl
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+)
+
+// startGroup starts a shell running script in its own process group, like
+// startSingBox does, and reaps it in the background so it does not linger
+// as a zombie that still looks alive.
+func startGroup(t *testing.T, script string) int {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	pid := cmd.Process.Pid
+	go func() { _ = cmd.Wait() }()
+	t.Cleanup(func() { _ = syscall.Kill(-pid, syscall.SIGKILL) })
+	time.Sleep(100 * time.Millisecond) // let the shell install its trap
+	return pid
+}
+
+const ignoresTERM = `trap "" TERM; while :; do sleep 0.05; done`
+
+func TestStopPID(t *testing.T) {
+	noKill := false
+	term := []string{"TERM"} // the applyDefaults default
+	tests := []struct {
+		name     string
+		script   string
+		cfg      config.Config
+		grace    time.Duration
+		wantErr  string
+		min, max time.Duration
+	}{
+		{
+			name:   "exits on TERM",
+			script: "exec sleep 30",
+			cfg:    config.Config{StopSignalScope: config.StopScopePGID, StopSignals: term},
+			grace:  2 * time.Second,
+			max:    time.Second,
+		},
+		{
+			name:   "ignores TERM: SIGKILL after the grace period",
+			script: ignoresTERM,
+			cfg:    config.Config{StopSignalScope: config.StopScopePGID, StopSignals: term},
+			grace:  400 * time.Millisecond,
+			min:    400 * time.Millisecond,
+			max:    2 * time.Second,
+		},
+		{
+			name:   "each signal gets its own grace period",
+			script: ignoresTERM,
+			cfg:    config.Config{StopSignalScope: config.StopScopePID, StopSignals: []string{"TERM", "TERM"}},
+			grace:  300 * time.Millisecond,
+			min:    600 * time.Millisecond,
+			max:    2 * time.Second,
+		},
+		{
+			name:    "ignores TERM, SIGKILL disabled",
+			script:  ignoresTERM,
+			cfg:     config.Config{StopSignalScope: config.StopScopePGID, StopSignals: term, SingBoxStopSIGKILL: &noKill},
+			grace:   300 * time.Millisecond,
+			wantErr: "SIGKILL disabled",
+			min:     300 * time.Millisecond,
+			max:     2 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid := startGroup(t, tt.script)
+			start := time.Now()
+			err := stopPID(context.Background(), &tt.cfg, pid, tt.grace)
+			took := time.Since(start)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				if !processAlive(pid) {
+					t.Fatal("process gone although SIGKILL is disabled")
+				}
+			} else {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if processAlive(pid) {
+					t.Fatal("process still alive")
+				}
+			}
+			if took < tt.min || took > tt.max {
+				t.Fatalf("took %s, want between %s and %s", took, tt.min, tt.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package status
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+)
+
+// LiveState is the running watchdog's current view, updated every tick and
+// read by anything reporting on the daemon (status file, admin API) without
+// re-probing. It is safe for concurrent use.
+type LiveState struct {
+	mu sync.RWMutex
+	s  Snapshot
+}
+
+// Update replaces the stored snapshot under the lock.
+func (l *LiveState) Update(s Snapshot) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.s = s
+}
+
+// Snapshot returns a copy of the current state; the caller may modify it freely.
+func (l *LiveState) Snapshot() Snapshot {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	s := l.s
+	s.UTUNs = slices.Clone(l.s.UTUNs)
+	s.SingBoxLogTail = slices.Clone(l.s.SingBoxLogTail)
+	s.SingBoxCommand.Argv = slices.Clone(l.s.SingBoxCommand.Argv)
+	s.SingBox = clonePtr(l.s.SingBox)
+	s.SingBoxExternal = clonePtr(l.s.SingBoxExternal)
+	s.DefaultRoute = clonePtr(l.s.DefaultRoute)
+	s.Throughput = clonePtr(l.s.Throughput)
+	s.ClashAPI = clonePtr(l.s.ClashAPI)
+	s.Health = cloneResult(l.s.Health)
+	if l.s.Watchdog != nil {
+		w := *l.s.Watchdog
+		w.HealthBreaker = clonePtr(w.HealthBreaker)
+		w.History = slices.Clone(w.History)
+		s.Watchdog = &w
+	}
+	return s
+}
+
+// clonePtr returns a pointer to a copy of *p, or nil. Only for types without
+// reference fields of their own.
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// cloneResult copies a health result including its slices, map and IPv6 probe.
+func cloneResult(r healthcheck.Result) healthcheck.Result {
+	r.Redirects = slices.Clone(r.Redirects)
+	r.Headers = maps.Clone(r.Headers)
+	if r.IPv6 != nil {
+		v6 := cloneResult(*r.IPv6)
+		r.IPv6 = &v6
+	}
+	return r
+}
+
+// Fresh reports whether s was written within maxAge, i.e. it comes from a
+// watchdog that is still running.
+func Fresh(s Snapshot, maxAge time.Duration) bool {
+	t, err := time.Parse(time.RFC3339, s.TimeUTC)
+	return err == nil && time.Since(t) <= maxAge
+}
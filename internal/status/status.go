@@ -1,14 +1,19 @@
 package status
 
 import (
-	"bytes"
 	"context"
-	"os/exec"
-	"strings"
+	"encoding/json"
+	"fmt"
+	"os"
 	"time"
 
+	"github.com/revolver-sys/vpn-router-daemon/internal/atomicfile"
+	"github.com/revolver-sys/vpn-router-daemon/internal/clashapi"
 	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/firewall"
 	"github.com/revolver-sys/vpn-router-daemon/internal/healthcheck"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logtail"
+	"github.com/revolver-sys/vpn-router-daemon/internal/netdetect"
 	"github.com/revolver-sys/vpn-router-daemon/internal/singboxctl"
 )
 
@@ -20,16 +25,87 @@ type Snapshot struct {
 	SingBox         *singboxctl.Status `json:"singbox"`
 	SingBoxExternal *singboxctl.Status `json:"singbox_external"`
 
+	// How vpnrd starts sing-box (whether or not it is running).
+	SingBoxCommand singboxctl.StartCommand `json:"singbox_command"`
+
 	UTUNs []string `json:"utuns"`
 
-	PFEnabled bool   `json:"pf_enabled"`
-	PFInfo    string `json:"pf_info"`
-	PFErr     string `json:"pf_err"`
+	// Current default route: shows which NIC wan_auto_detect would pick.
+	DefaultRoute    *netdetect.Route `json:"default_route,omitempty"`
+	DefaultRouteErr string           `json:"default_route_err,omitempty"`
+
+	// Last lines of singbox_log_file (crash reasons show up here).
+	SingBoxLogTail []string `json:"singbox_log_tail,omitempty"`
+	SingBoxLogErr  string   `json:"singbox_log_err,omitempty"`
+
+	// Firewall state (pf or nftables); the pf_* names predate Linux support.
+	Firewall   string `json:"firewall"`
+	PFEnabled  bool   `json:"pf_enabled"`
+	PFInfo     string `json:"pf_info"`
+	PFErr      string `json:"pf_err"`
+	NATPackets int64  `json:"nat_packets,omitempty"`
+	NATBytes   int64  `json:"nat_bytes,omitempty"`
 
 	Health healthcheck.Result `json:"health"`
+
+	// Probed only when health failed: a portal explains the failure better than a dead tunnel.
+	CaptivePortal       bool   `json:"captive_portal"`
+	CaptivePortalDetail string `json:"captive_portal_detail,omitempty"`
+
+	// Only set by the watchdog (status file); nil for one-shot "vpnrd status".
+	Watchdog *WatchdogState `json:"watchdog,omitempty"`
+
+	// The watchdog's last throughput probe (throughput_check_url), or a fresh
+	// one from "vpnrd status --probe"; nil before either ran.
+	Throughput *healthcheck.ThroughputResult `json:"throughput,omitempty"`
+
+	// Only collected when clash_api_addr is configured.
+	ClashAPI    *clashapi.Stats `json:"clash_api,omitempty"`
+	ClashAPIErr string          `json:"clash_api_err,omitempty"`
 }
 
+// singBoxLogTailLines is how many sing-box log lines a Snapshot carries.
+const singBoxLogTailLines = 30
+
+// WatchdogState is the run loop's in-memory state exported with a Snapshot.
+type WatchdogState struct {
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Recoveries          int    `json:"recoveries"`
+	CaptivePortal       bool   `json:"captive_portal"`        // recovery suspended while true
+	RecoveryRateLimited bool   `json:"recovery_rate_limited"` // max_recoveries_per_window reached
+	Paused              bool   `json:"paused"`                // automatic recovery paused via the admin API
+	SingBoxConfigPath   string `json:"singbox_config_path"`   // active endpoint
+	WAN                 string `json:"wan_if"`                // WAN the watchdog applies pf with
+	RecoveryFailures    int    `json:"recovery_failures"`     // failed recoveries in a row (max_consecutive_recovery_failures)
+
+	// HealthBreaker is the health endpoint's circuit breaker (nil when health_breaker_threshold is 0).
+	HealthBreaker *healthcheck.BreakerState `json:"health_breaker,omitempty"`
+
+	// Recent health results, oldest first, and how many of them failed.
+	History       []healthcheck.HistoryEntry `json:"history,omitempty"`
+	HistoryFailed int                        `json:"history_failed"`
+	HistoryStats  healthcheck.HistoryStats   `json:"history_stats"`
+}
+
+// Collect runs a health check and gathers the system side. It never runs the
+// throughput probe: that downloads a payload, so it is left to the watchdog
+// (every throughput_interval) and "vpnrd status --probe" (see ProbeThroughput).
 func Collect(ctx context.Context, cfg *config.Config, cfgPath string, healthTimeout time.Duration) Snapshot {
+	return CollectWithHealth(ctx, cfg, cfgPath, healthcheck.Check(ctx, cfg.HealthCheckURL, healthTimeout))
+}
+
+// ProbeThroughput runs the throughput probe into s when throughput_check_url is set.
+func ProbeThroughput(ctx context.Context, cfg *config.Config, s *Snapshot) {
+	if cfg.ThroughputCheckURL == "" {
+		return
+	}
+	tp := healthcheck.CheckThroughput(ctx, cfg.ThroughputCheckURL, cfg.ThroughputMinBPS, cfg.ThroughputTimeout)
+	s.Throughput = &tp
+}
+
+// CollectWithHealth is Collect without probing: it reuses a health result the
+// caller already has (the watchdog's last check).
+func CollectWithHealth(ctx context.Context, cfg *config.Config, cfgPath string, h healthcheck.Result) Snapshot {
 	s := Snapshot{
 		TimeUTC:    time.Now().UTC().Format(time.RFC3339),
 		ConfigPath: cfgPath,
@@ -41,45 +117,78 @@ func Collect(ctx context.Context, cfg *config.Config, cfgPath string, healthTime
 
 	ext, _ := singboxctl.InspectExternal(ctx, cfg)
 	s.SingBoxExternal = ext
+	s.SingBoxCommand = singboxctl.PlannedStart(cfg)
+
+	// which utun (and address) the running sing-box is on
+	singboxctl.ResolveTun(cfg, sb)
+	singboxctl.ResolveTun(cfg, ext)
 
 	// utun list (all)
 	if us, err := ListUTUN(); err == nil {
 		s.UTUNs = us
 	}
 
-	// pf info (best-effort)
-	s.PFEnabled, s.PFInfo, s.PFErr = pfInfo(ctx)
+	// default route (best-effort)
+	if r, err := netdetect.DefaultRoute(ctx); err != nil {
+		s.DefaultRouteErr = err.Error()
+	} else {
+		s.DefaultRoute = &r
+	}
 
-	// healthcheck (always)
-	s.Health = healthcheck.Check(ctx, cfg.HealthCheckURL, healthTimeout)
+	// sing-box log tail (best-effort)
+	if cfg.SingBoxLogFile != "" {
+		if lines, err := logtail.Lines(cfg.SingBoxLogFile, singBoxLogTailLines); err != nil {
+			s.SingBoxLogErr = err.Error()
+		} else {
+			s.SingBoxLogTail = lines
+		}
+	}
 
-	return s
-}
+	// firewall info (best-effort)
+	if fw, err := firewall.For(cfg.Firewall); err != nil {
+		s.PFErr = err.Error()
+	} else {
+		fi := fw.Info(ctx)
+		s.Firewall, s.PFEnabled, s.PFInfo, s.PFErr = fi.Backend, fi.Enabled, fi.Info, fi.Err
+		s.NATPackets, s.NATBytes = fi.NATPackets, fi.NATBytes
+	}
 
-func pfInfo(ctx context.Context) (enabled bool, info string, errStr string) {
-	cmd := exec.CommandContext(ctx, "pfctl", "-s", "info")
+	// sing-box Clash API (best-effort; absent when not enabled)
+	if cfg.ClashAPIAddr != "" {
+		c := clashapi.Client{Addr: cfg.ClashAPIAddr, Secret: cfg.ClashAPISecret, Timeout: cfg.HealthTimeout}
+		if st, err := c.Stats(ctx); err != nil {
+			s.ClashAPIErr = err.Error()
+		} else {
+			s.ClashAPI = &st
+		}
+	}
 
-	var out bytes.Buffer
-	var errb bytes.Buffer
-	cmd.Stdout = &out
-	cmd.Stderr = &errb
+	s.Health = h
+	if !h.OK {
+		s.CaptivePortal, s.CaptivePortalDetail = healthcheck.DetectCaptivePortal(ctx, cfg.HealthTimeout)
+	}
 
-	err := cmd.Run()
-	info = strings.TrimSpace(out.String())
+	return s
+}
 
+// WriteFile atomically writes s as indented JSON to path (temp file + rename).
+func WriteFile(path string, s Snapshot) error {
+	b, err := json.MarshalIndent(s, "", "  ")
 	if err != nil {
-		// Not fatal: user might not be root, or pfctl might be restricted.
-		errStr = strings.TrimSpace(errb.String())
-		if errStr == "" {
-			errStr = err.Error()
-		}
-		return false, info, errStr
+		return fmt.Errorf("marshal status: %w", err)
 	}
+	return atomicfile.Write(path, append(b, '\n'), 0o644)
+}
 
-	// Parse a simple indicator
-	// "Status: Enabled" appears on macOS
-	if strings.Contains(info, "Status: Enabled") || strings.Contains(info, "Enabled") {
-		enabled = true
+// ReadFile loads a Snapshot previously written by WriteFile.
+func ReadFile(path string) (Snapshot, error) {
+	var s Snapshot
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, fmt.Errorf("parse status file %q: %w", path, err)
 	}
-	return enabled, info, ""
+	return s, nil
 }
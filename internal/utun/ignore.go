@@ -0,0 +1,65 @@
+package utun
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Ignore matches utun names that must never be selected (e.g. Tailscale's tunnel).
+type Ignore struct {
+	ranges [][2]int // inclusive unit-number ranges
+}
+
+// ParseIgnore parses specs like "utun5", "5", "utun0-utun3" or "0-3".
+func ParseIgnore(specs []string) (Ignore, error) {
+	var ig Ignore
+	for _, spec := range specs {
+		sp := strings.TrimSpace(spec)
+		if sp == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(sp, "-")
+		a, err := unitNumber(lo)
+		if err != nil {
+			return Ignore{}, fmt.Errorf("ignore_utuns %q: %w", spec, err)
+		}
+		b := a
+		if isRange {
+			if b, err = unitNumber(hi); err != nil {
+				return Ignore{}, fmt.Errorf("ignore_utuns %q: %w", spec, err)
+			}
+			if b < a {
+				return Ignore{}, fmt.Errorf("ignore_utuns %q: range end before start", spec)
+			}
+		}
+		ig.ranges = append(ig.ranges, [2]int{a, b})
+	}
+	return ig, nil
+}
+
+// Match reports whether name (e.g. "utun5") is ignored.
+func (ig Ignore) Match(name string) bool {
+	if !strings.HasPrefix(name, "utun") {
+		return false
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "utun"))
+	if err != nil {
+		return false
+	}
+	for _, r := range ig.ranges {
+		if n >= r[0] && n <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func unitNumber(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "utun")
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("want utunN, N, or a range of those")
+	}
+	return n, nil
+}
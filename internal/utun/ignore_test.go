@@ -0,0 +1,26 @@
+package utun
+
+import "testing"
+
+func TestParseIgnore(t *testing.T) {
+	ig, err := ParseIgnore([]string{"utun5", " 7 ", "utun10-utun12", "20-21", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"utun5": true, "utun7": true, "utun10": true, "utun11": true, "utun12": true, "utun20": true, "utun21": true,
+		"utun4": false, "utun9": false, "utun13": false, "utun22": false, "en5": false, "utunX": false,
+	} {
+		if got := ig.Match(name); got != want {
+			t.Errorf("Match(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestParseIgnoreErrors(t *testing.T) {
+	for _, spec := range []string{"tun5", "utun-1", "utun3-utun1", "5-x", "-3"} {
+		if _, err := ParseIgnore([]string{spec}); err == nil {
+			t.Errorf("ParseIgnore(%q): no error", spec)
+		}
+	}
+}
@@ -2,21 +2,35 @@ package utun
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"log"
+	"net"
 	"os/exec"
 	"regexp"
 	"sort"
 	"strings"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/control"
+	"github.com/revolver-sys/vpn-router-daemon/internal/debugdump"
 )
 
 var reUTUN = regexp.MustCompile(`(?m)^(utun[0-9]+):`)
 
 // List returns utun interfaces seen in ifconfig output (e.g. ["utun0","utun66"]).
+// When ifconfig is missing (minimal installs, launchd PATH) or fails, it falls
+// back to net.Interfaces, so callers never fail just because of the tool.
 func List() ([]string, error) {
-	out, err := exec.Command("ifconfig").Output()
+	out, err := control.Output(context.Background(), "ifconfig")
 	if err != nil {
-		return nil, fmt.Errorf("ifconfig: %w", err)
+		names, nerr := listNet()
+		if nerr != nil {
+			return nil, fmt.Errorf("ifconfig: %w; net.Interfaces: %v", err, nerr)
+		}
+		debugf("utun.List: ifconfig unavailable (%v); used net.Interfaces", err)
+		return names, nil
 	}
+	debugf("utun.List: used ifconfig")
 	m := reUTUN.FindAllSubmatch(out, -1)
 	seen := make(map[string]struct{}, len(m))
 	for _, mm := range m {
@@ -32,6 +46,30 @@ func List() ([]string, error) {
 	return res, nil
 }
 
+// listNet is List via the Go runtime (same utunN name filter as the ifconfig parser).
+func listNet() ([]string, error) {
+	ifs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for _, ifc := range ifs {
+		if reUTUNName.MatchString(ifc.Name) {
+			res = append(res, ifc.Name)
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+var reUTUNName = regexp.MustCompile(`^utun[0-9]+$`)
+
+func debugf(format string, args ...any) {
+	if debugdump.Enabled() {
+		log.Printf(format, args...)
+	}
+}
+
 // Diff returns items present in after but not in before.
 func Diff(before, after []string) []string {
 	bm := make(map[string]struct{}, len(before))
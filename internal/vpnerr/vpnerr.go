@@ -0,0 +1,40 @@
+// Package vpnerr holds the failure classes shared across packages. Package
+// errors (singboxctl.ErrUTUNTimeout, ...) keep their own identity and also match
+// one of these, so the CLI and watchdog can branch with errors.Is without
+// knowing which package failed.
+package vpnerr
+
+import "errors"
+
+var (
+	// ErrConfig: the config file is missing, unparsable or invalid. Retrying won't help.
+	ErrConfig = errors.New("config invalid")
+
+	// ErrPermission: an operation needs root (pfctl, signalling sing-box, ...).
+	ErrPermission = errors.New("permission denied")
+
+	// ErrNoUTUN: no tunnel interface could be brought up or attributed to sing-box.
+	ErrNoUTUN = errors.New("no utun")
+
+	// ErrSingBoxNotOwned: the operation needs a sing-box started by vpnrd (pidfile) and there is none.
+	ErrSingBoxNotOwned = errors.New("sing-box not owned by vpnrd")
+
+	// ErrHealthFailed: the tunnel is up but traffic doesn't egress correctly.
+	ErrHealthFailed = errors.New("health check failed")
+
+	// ErrTimeout: a script or wait ran out of time.
+	ErrTimeout = errors.New("timed out")
+)
+
+// Class returns err, unchanged in message, that additionally matches class.
+func Class(class, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classified{class: class, err: err}
+}
+
+type classified struct{ class, err error }
+
+func (e *classified) Error() string   { return e.err.Error() }
+func (e *classified) Unwrap() []error { return []error{e.err, e.class} }
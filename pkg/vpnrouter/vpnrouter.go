@@ -0,0 +1,98 @@
+// Package vpnrouter embeds vpnrd in another Go program: bring the router up
+// and down, read its status and run the watchdog without the vpnrd binary.
+//
+// Health probe settings, dry-run and the log level are process-wide, so a
+// process should drive one router config at a time. Logging goes through the
+// standard log package.
+package vpnrouter
+
+import (
+	"context"
+
+	"github.com/revolver-sys/vpn-router-daemon/internal/config"
+	"github.com/revolver-sys/vpn-router-daemon/internal/logx"
+	"github.com/revolver-sys/vpn-router-daemon/internal/router"
+	"github.com/revolver-sys/vpn-router-daemon/internal/status"
+	"github.com/revolver-sys/vpn-router-daemon/internal/vpnerr"
+)
+
+// Config is a vpnrd config file (see config.example.yaml). Get one from LoadConfig.
+type Config = config.Config
+
+// Snapshot is the router's status, as printed by "vpnrd status --json".
+type Snapshot = status.Snapshot
+
+// Failure classes; match them with errors.Is.
+var (
+	ErrConfig          = vpnerr.ErrConfig
+	ErrPermission      = vpnerr.ErrPermission
+	ErrNoUTUN          = vpnerr.ErrNoUTUN
+	ErrSingBoxNotOwned = vpnerr.ErrSingBoxNotOwned
+	ErrHealthFailed    = vpnerr.ErrHealthFailed
+	ErrTimeout         = vpnerr.ErrTimeout
+)
+
+// LoadConfig reads and validates a config file and applies its process-wide settings.
+func LoadConfig(path string) (*Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	router.Configure(cfg)
+	if lvl, err := logx.ParseLevel(cfg.LogLevel); err == nil {
+		logx.SetLevel(lvl)
+	}
+	return cfg, nil
+}
+
+// SetLogLevel sets the log verbosity: error, warn, info or debug.
+func SetLogLevel(level string) error {
+	lvl, err := logx.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	logx.SetLevel(lvl)
+	return nil
+}
+
+// Up is "vpnrd up": run setup, start (or adopt) sing-box and apply pf.
+func Up(ctx context.Context, cfg *Config) error {
+	_, err := router.Up(ctx, cfg, router.DefaultOptions(ctx, cfg))
+	return err
+}
+
+// Down is "vpnrd down": stop an owned sing-box and restore the router state.
+func Down(ctx context.Context, cfg *Config) error {
+	_, err := router.Down(ctx, cfg)
+	return err
+}
+
+// Status probes the router now, like "vpnrd status --probe".
+func Status(ctx context.Context, cfg *Config) Snapshot {
+	s := status.Collect(ctx, cfg, cfg.Path, cfg.HealthTimeout)
+	status.ProbeThroughput(ctx, cfg, &s)
+	return s
+}
+
+// Watchdog is "vpnrd run": it checks health every check_interval and recovers
+// the tunnel when it fails.
+type Watchdog struct {
+	w *router.Watchdog
+}
+
+// NewWatchdog prepares a watchdog for cfg; nothing runs until Run.
+func NewWatchdog(cfg *Config) *Watchdog {
+	return &Watchdog{w: router.NewWatchdog(cfg, router.DefaultOptions(context.Background(), cfg))}
+}
+
+// Run blocks until ctx is canceled, then tears the router down if
+// down_on_exit is set. A Watchdog runs once.
+func (w *Watchdog) Run(ctx context.Context) error {
+	return w.w.Run(ctx)
+}
+
+// Snapshot returns the state published after the watchdog's last check; it
+// is safe to call while Run is active.
+func (w *Watchdog) Snapshot() Snapshot {
+	return w.w.Snapshot()
+}
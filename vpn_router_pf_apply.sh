@@ -11,6 +11,13 @@
 #   $4 = VPN_SERVER_IPS CSV        [required; e.g. "1.2.3.4,5.6.7.8"]
 #   $5 = WAN_DNS_IPS CSV           [optional; e.g. "1.1.1.1,8.8.8.8"]
 #   $6 = ALLOW_WAN_NTP             [optional; "true" or "false"]
+#   $7 = TUN_IP (utun IPv4)        [optional; informational]
+#   $8 = TUN_IP6 (utun IPv6)       [optional; informational]
+#   $9 = TUN_CIDR (e.g. 10.7.0.2/24) [optional; informational]
+#   $10 = VPN_SERVER_IPS_V4 CSV     [optional; IPv4 subset of $4]
+#   $11 = VPN_SERVER_IPS_V6 CSV     [optional; IPv6 subset of $4]
+#   $12 = WAN_DNS_V4 CSV            [optional; IPv4 subset of $5]
+#   $13 = WAN_DNS_V6 CSV            [optional; IPv6 subset of $5]
 
 set -e
 
@@ -40,6 +47,11 @@ echo "=== PF APPLY (fast) ==="
  VPN_SERVER_IPS_CSV="$(strip_kv "${4:-}")"
  WAN_DNS_IPS_CSV="$(strip_kv "${5:-}")"
  ALLOW_WAN_NTP="$(strip_kv "${6:-false}")"
+ TUN_IP="$(strip_kv "${7:-}")"
+ TUN_IP6="$(strip_kv "${8:-}")"
+ TUN_CIDR="$(strip_kv "${9:-}")"
+ VPN_SERVER_IPS_V6_CSV="$(strip_kv "${11:-}")"
+ WAN_DNS_V6_CSV="$(strip_kv "${13:-}")"
 
 LAN_CIDR="192.168.50.0/24"
 LAN_IP="192.168.50.1"
@@ -66,7 +78,7 @@ if [ -z "$VPN_SERVER_IPS_CSV" ]; then
   exit 1
 fi
 
-echo "VPN: $VPN_IF  WAN: $WAN_IF  LAN: $LAN_IF"
+echo "VPN: $VPN_IF${TUN_CIDR:+ ($TUN_CIDR)}  WAN: $WAN_IF  LAN: $LAN_IF"
 echo "VPN servers (WAN allowlist): $VPN_SERVER_IPS_CSV"
 
 # Convert CSV -> pf table list: "1.2.3.4,5.6.7.8" -> "1.2.3.4 5.6.7.8"
@@ -116,12 +128,25 @@ pass out quick on $WAN_IF inet from ($WAN_IF) to <vpnrd_vpn_servers> keep state
 
 EOF
 
+# Optional: IPv6 VPN servers (vpnrd passes the IPv6 subset separately)
+if [ -n "$VPN_SERVER_IPS_V6_CSV" ]; then
+  echo "VPN servers (IPv6): $VPN_SERVER_IPS_V6_CSV"
+  sudo tee -a "$PF_ANCHOR_VPN" >/dev/null <<EOF
+pass out quick on $WAN_IF inet6 from ($WAN_IF) to { $(printf "%s" "$VPN_SERVER_IPS_V6_CSV" | tr ',' ' ') } keep state
+EOF
+fi
+
 # Optional: allow WAN DNS (helpful before tunnel comes up)
 if [ -n "$WAN_DNS_IPS_PF" ]; then
   sudo tee -a "$PF_ANCHOR_VPN" >/dev/null <<EOF
 pass out quick on $WAN_IF inet proto { udp, tcp } from ($WAN_IF) to <vpnrd_wan_dns> port 53 keep state
 EOF
 fi
+if [ -n "$WAN_DNS_V6_CSV" ]; then
+  sudo tee -a "$PF_ANCHOR_VPN" >/dev/null <<EOF
+pass out quick on $WAN_IF inet6 proto { udp, tcp } from ($WAN_IF) to { $(printf "%s" "$WAN_DNS_V6_CSV" | tr ',' ' ') } port 53 keep state
+EOF
+fi
 
 # Optional: allow WAN NTP
 if [ "$ALLOW_WAN_NTP" = "true" ]; then